@@ -2,6 +2,8 @@ package main
 
 import (
 	"log"
+	"path/filepath"
+	"time"
 
 	"github.com/joejulian/csi-justmount/pkg/node"
 	"github.com/spf13/pflag"
@@ -12,6 +14,23 @@ func main() {
 	// Define command-line flags for the node service
 	pflag.String("node-endpoint", "/tmp/csi-node.sock", "CSI Node service endpoint")
 	pflag.String("node-id", "example-node-id", "Unique identifier for the node")
+	pflag.String("state-dir", "", "Directory for per-volume stage state (defaults to a volumes directory next to the node endpoint)")
+	pflag.String("secrets-dir", "", "Directory for the private tmpfs holding secret files referenced by mountOptions (defaults to a secrets directory next to the node endpoint)")
+	pflag.Duration("mount-ready-timeout", 30*time.Second, "Maximum time to wait for a new staging mount to become usable")
+	pflag.Duration("mount-helper-timeout", 2*time.Minute, "Maximum time a mount helper may run before it is killed (0 leaves only the request deadline)")
+	pflag.Duration("watchdog-interval", 30*time.Second, "Interval between staged mount health checks (0 disables the watchdog)")
+	pflag.Duration("watchdog-probe-timeout", 5*time.Second, "Timeout for a single staged mount health probe")
+	pflag.Int("watchdog-concurrency", 4, "Maximum number of staged mounts probed concurrently")
+	pflag.String("scratch-template-dir", "", "Directory holding template directories that tmpfs and ramfs volumes can be seeded from (empty disables templates)")
+	pflag.StringSlice("bind-allowed-paths", nil, "Host directories that bind volumes may use, along with everything below them (empty refuses bind volumes)")
+	pflag.String("overlay-upper-root", "", "Directory below which writable overlay volumes keep their upper layers; upperDir is relative to it (empty refuses upperDir)")
+	pflag.String("policy-file", "", "YAML file with the fsType, source and mount option rules every staged volume must pass (empty allows everything)")
+	pflag.Duration("fsck-timeout", 10*time.Minute, "Maximum time a pre-mount filesystem check may run before staging fails")
+	unmountDefaults := node.DefaultUnmountConfig()
+	pflag.Int("unmount-retries", unmountDefaults.Retries, "Retries of a busy unmount before escalating")
+	pflag.Duration("unmount-backoff", unmountDefaults.Backoff, "Delay before the first unmount retry, doubled for each later retry")
+	pflag.StringSlice("unmount-force-fstypes", unmountDefaults.ForceFsTypes, "Filesystem types that escalate a stuck unmount to MNT_FORCE (fuse.* matches FUSE subtypes, * matches all)")
+	pflag.StringSlice("unmount-detach-fstypes", unmountDefaults.DetachFsTypes, "Filesystem types that escalate a stuck unmount to MNT_DETACH as the last step")
 	pflag.Parse()
 
 	// Bind flags to Viper
@@ -22,9 +41,46 @@ func main() {
 	// Read values from Viper
 	nodeEndpoint := viper.GetString("node-endpoint")
 	nodeID := viper.GetString("node-id")
+	stateDir := viper.GetString("state-dir")
+	if stateDir == "" {
+		stateDir = filepath.Join(filepath.Dir(nodeEndpoint), "volumes")
+	}
+	secretsDir := viper.GetString("secrets-dir")
+	if secretsDir == "" {
+		secretsDir = filepath.Join(filepath.Dir(nodeEndpoint), "secrets")
+	}
+
+	var policy *node.Policy
+	if policyFile := viper.GetString("policy-file"); policyFile != "" {
+		var err error
+		if policy, err = node.LoadPolicy(policyFile); err != nil {
+			log.Fatalf("Failed to load policy: %v", err)
+		}
+	}
 
 	// Initialize and run the Node service
-	nodeService := node.NewNode(nodeID, nodeEndpoint)
+	nodeService := node.NewNode(nodeID, nodeEndpoint,
+		node.WithStateDir(stateDir),
+		node.WithSecretsDir(secretsDir),
+		node.WithMountReadyTimeout(viper.GetDuration("mount-ready-timeout")),
+		node.WithMountHelperTimeout(viper.GetDuration("mount-helper-timeout")),
+		node.WithFsckTimeout(viper.GetDuration("fsck-timeout")),
+		node.WithScratchTemplateDir(viper.GetString("scratch-template-dir")),
+		node.WithBindAllowedPaths(viper.GetStringSlice("bind-allowed-paths")),
+		node.WithOverlayUpperRoot(viper.GetString("overlay-upper-root")),
+		node.WithPolicy(policy),
+		node.WithUnmount(node.UnmountConfig{
+			Retries:       viper.GetInt("unmount-retries"),
+			Backoff:       viper.GetDuration("unmount-backoff"),
+			ForceFsTypes:  viper.GetStringSlice("unmount-force-fstypes"),
+			DetachFsTypes: viper.GetStringSlice("unmount-detach-fstypes"),
+		}),
+		node.WithWatchdog(node.WatchdogConfig{
+			Interval:     viper.GetDuration("watchdog-interval"),
+			ProbeTimeout: viper.GetDuration("watchdog-probe-timeout"),
+			Concurrency:  viper.GetInt("watchdog-concurrency"),
+		}),
+	)
 	if err := nodeService.Run(); err != nil {
 		log.Fatalf("Failed to run Node service: %v", err)
 	}
@@ -0,0 +1,224 @@
+// Package mountinfo parses /proc/<pid>/mountinfo as described in proc(5).
+package mountinfo
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// SelfPath is the mountinfo file of the calling process.
+const SelfPath = "/proc/self/mountinfo"
+
+// Entry is a single mountinfo line.
+type Entry struct {
+	// ID is the unique mount ID.
+	ID int
+	// ParentID is the mount ID of the parent mount.
+	ParentID int
+	// Major and Minor identify the device (st_dev) backing the mount.
+	Major int
+	Minor int
+	// Root is the path within the filesystem that forms the root of the mount.
+	Root string
+	// MountPoint is the path of the mount point relative to the process root.
+	MountPoint string
+	// Options are the per-mount options.
+	Options string
+	// OptionalFields are the raw tag[:value] fields before the separator.
+	OptionalFields []string
+	// Shared is the peer group ID when the mount is shared, otherwise 0.
+	Shared int
+	// Master is the peer group ID of the master when the mount is a slave, otherwise 0.
+	Master int
+	// PropagateFrom is the nearest dominant peer group of a slave, otherwise 0.
+	PropagateFrom int
+	// Unbindable reports whether the mount is unbindable.
+	Unbindable bool
+	// FSType is the filesystem type, including any subtype (e.g. fuse.sshfs).
+	FSType string
+	// Source is the filesystem-specific mount source, or "none".
+	Source string
+	// SuperOptions are the per-superblock options.
+	SuperOptions string
+	// Line is the unparsed mountinfo line.
+	Line string
+}
+
+// Device returns the major:minor pair as printed in mountinfo.
+func (e Entry) Device() string {
+	return fmt.Sprintf("%d:%d", e.Major, e.Minor)
+}
+
+// ReadFile parses the mountinfo file at path. Malformed lines are skipped and
+// returned as warnings, as for Parse.
+func ReadFile(path string) ([]Entry, []error, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return Parse(bytes.NewReader(data))
+}
+
+// Parse parses mountinfo content, one entry per non-blank line. A malformed
+// line is skipped and returned as a warning rather than failing the parse, so
+// one line the parser does not understand cannot hide every other mount.
+func Parse(r io.Reader) ([]Entry, []error, error) {
+	var entries []Entry
+	var warnings []error
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		entry, err := ParseLine(line)
+		if err != nil {
+			warnings = append(warnings, fmt.Errorf("line %d: %w", lineNumber, err))
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+	return entries, warnings, nil
+}
+
+// ParseLine parses a single mountinfo line.
+func ParseLine(line string) (Entry, error) {
+	fields := strings.Fields(line)
+	separator := -1
+	for i := 6; i < len(fields); i++ {
+		if fields[i] == "-" {
+			separator = i
+			break
+		}
+	}
+	if len(fields) < 7 || separator < 0 || len(fields) < separator+4 {
+		return Entry{}, fmt.Errorf("malformed entry %q", line)
+	}
+
+	id, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return Entry{}, fmt.Errorf("invalid mount id %q: %w", fields[0], err)
+	}
+	parentID, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return Entry{}, fmt.Errorf("invalid parent id %q: %w", fields[1], err)
+	}
+	majorStr, minorStr, ok := strings.Cut(fields[2], ":")
+	if !ok {
+		return Entry{}, fmt.Errorf("invalid major:minor %q", fields[2])
+	}
+	major, err := strconv.Atoi(majorStr)
+	if err != nil {
+		return Entry{}, fmt.Errorf("invalid major %q: %w", majorStr, err)
+	}
+	minor, err := strconv.Atoi(minorStr)
+	if err != nil {
+		return Entry{}, fmt.Errorf("invalid minor %q: %w", minorStr, err)
+	}
+
+	entry := Entry{
+		ID:           id,
+		ParentID:     parentID,
+		Major:        major,
+		Minor:        minor,
+		Root:         Unescape(fields[3]),
+		MountPoint:   Unescape(fields[4]),
+		Options:      fields[5],
+		FSType:       Unescape(fields[separator+1]),
+		Source:       Unescape(fields[separator+2]),
+		SuperOptions: fields[separator+3],
+		Line:         line,
+	}
+	for _, field := range fields[6:separator] {
+		entry.OptionalFields = append(entry.OptionalFields, field)
+		tag, value, _ := strings.Cut(field, ":")
+		switch tag {
+		case "shared":
+			entry.Shared, _ = strconv.Atoi(value)
+		case "master":
+			entry.Master, _ = strconv.Atoi(value)
+		case "propagate_from":
+			entry.PropagateFrom, _ = strconv.Atoi(value)
+		case "unbindable":
+			entry.Unbindable = true
+		}
+	}
+	return entry, nil
+}
+
+// Unescape decodes the \ooo octal escapes the kernel uses for space, tab,
+// newline, backslash and any other byte it will not print verbatim.
+func Unescape(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	b.Grow(len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) && isOctal(s[i+1]) && isOctal(s[i+2]) && isOctal(s[i+3]) {
+			v := (s[i+1]-'0')<<6 | (s[i+2]-'0')<<3 | (s[i+3] - '0')
+			b.WriteByte(v)
+			i += 3
+			continue
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+func isOctal(c byte) bool {
+	return c >= '0' && c <= '7'
+}
+
+// FindByMountPoint returns the top-most entry mounted at path. When several
+// mounts are stacked on the same path, the last one listed is the visible one.
+func FindByMountPoint(entries []Entry, path string) (Entry, bool) {
+	cleaned := filepath.Clean(path)
+	for i := len(entries) - 1; i >= 0; i-- {
+		if filepath.Clean(entries[i].MountPoint) == cleaned {
+			return entries[i], true
+		}
+	}
+	return Entry{}, false
+}
+
+// FindAllByMountPoint returns every entry mounted at path, bottom-most first.
+func FindAllByMountPoint(entries []Entry, path string) []Entry {
+	cleaned := filepath.Clean(path)
+	var out []Entry
+	for _, entry := range entries {
+		if filepath.Clean(entry.MountPoint) == cleaned {
+			out = append(out, entry)
+		}
+	}
+	return out
+}
+
+// FindByDevice returns every entry backed by the same device as major:minor.
+func FindByDevice(entries []Entry, major, minor int) []Entry {
+	var out []Entry
+	for _, entry := range entries {
+		if entry.Major == major && entry.Minor == minor {
+			out = append(out, entry)
+		}
+	}
+	return out
+}
+
+// IsMountPoint reports whether any entry is mounted at path.
+func IsMountPoint(entries []Entry, path string) bool {
+	_, ok := FindByMountPoint(entries, path)
+	return ok
+}
@@ -0,0 +1,210 @@
+package mountinfo
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const sample = `22 1 8:1 / / rw,relatime shared:1 - ext4 /dev/sda1 rw,errors=remount-ro
+36 22 0:32 / /var/lib/kubelet/plugins/justmount.csi.driver/vol/globalmount rw,nosuid,nodev,relatime shared:42 master:7 - fuse.glusterfs gluster:media rw,user_id=0,group_id=0,allow_other
+37 36 0:32 /projects/2026 /var/lib/kubelet/pods/uid/volume-subpaths/media/app/0 rw,relatime master:42 propagate_from:7 - fuse.glusterfs gluster:media rw
+38 22 0:33 / /mnt/with\040space\011tab\012nl\134slash\043hash rw unbindable - fuse.sshfs sshfs#host:/srv\040data rw
+`
+
+func TestParseLine(t *testing.T) {
+	tests := []struct {
+		name    string
+		line    string
+		want    Entry
+		wantErr bool
+	}{
+		{
+			name: "optional fields",
+			line: "36 22 0:32 / /stage rw,nosuid shared:42 master:7 propagate_from:3 - fuse.glusterfs gluster:media rw,allow_other",
+			want: Entry{
+				ID:             36,
+				ParentID:       22,
+				Major:          0,
+				Minor:          32,
+				Root:           "/",
+				MountPoint:     "/stage",
+				Options:        "rw,nosuid",
+				OptionalFields: []string{"shared:42", "master:7", "propagate_from:3"},
+				Shared:         42,
+				Master:         7,
+				PropagateFrom:  3,
+				FSType:         "fuse.glusterfs",
+				Source:         "gluster:media",
+				SuperOptions:   "rw,allow_other",
+			},
+		},
+		{
+			name: "no optional fields",
+			line: "40 22 253:4 /sub /target ro - xfs /dev/mapper/vg-lv ro,attr2",
+			want: Entry{
+				ID:           40,
+				ParentID:     22,
+				Major:        253,
+				Minor:        4,
+				Root:         "/sub",
+				MountPoint:   "/target",
+				Options:      "ro",
+				FSType:       "xfs",
+				Source:       "/dev/mapper/vg-lv",
+				SuperOptions: "ro,attr2",
+			},
+		},
+		{
+			name: "escaped paths and unbindable",
+			line: `38 22 0:33 /a\134b /mnt/with\040space rw unbindable - fuse.sshfs sshfs#host:/srv\040data rw`,
+			want: Entry{
+				ID:             38,
+				ParentID:       22,
+				Major:          0,
+				Minor:          33,
+				Root:           `/a\b`,
+				MountPoint:     "/mnt/with space",
+				Options:        "rw",
+				OptionalFields: []string{"unbindable"},
+				Unbindable:     true,
+				FSType:         "fuse.sshfs",
+				Source:         "sshfs#host:/srv data",
+				SuperOptions:   "rw",
+			},
+		},
+		{name: "missing separator", line: "36 22 0:32 / /stage rw shared:1 fuse x rw", wantErr: true},
+		{name: "truncated after separator", line: "36 22 0:32 / /stage rw - fuse", wantErr: true},
+		{name: "bad id", line: "x 22 0:32 / /stage rw - tmpfs tmpfs rw", wantErr: true},
+		{name: "bad device", line: "36 22 032 / /stage rw - tmpfs tmpfs rw", wantErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := ParseLine(tc.line)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("ParseLine(%q) error = nil, want error", tc.line)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseLine(%q) error = %v", tc.line, err)
+			}
+			tc.want.Line = tc.line
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("ParseLine(%q) = %+v, want %+v", tc.line, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestUnescape(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{`/plain`, "/plain"},
+		{`/a\040b`, "/a b"},
+		{`/a\011b\012c`, "/a\tb\nc"},
+		{`/a\134b`, `/a\b`},
+		{`/a\043b`, "/a#b"},
+		{`/trailing\04`, `/trailing\04`},
+		{`/not\089octal`, `/not\089octal`},
+	}
+	for _, tc := range tests {
+		if got := Unescape(tc.in); got != tc.want {
+			t.Errorf("Unescape(%q) = %q, want %q", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestParseAndLookups(t *testing.T) {
+	entries, warnings, err := Parse(strings.NewReader(sample))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if len(entries) != 4 || len(warnings) != 0 {
+		t.Fatalf("Parse() = %d entries, warnings %v, want 4 entries and no warnings", len(entries), warnings)
+	}
+
+	entry, ok := FindByMountPoint(entries, "/var/lib/kubelet/plugins/justmount.csi.driver/vol/globalmount/")
+	if !ok || entry.ID != 36 || entry.Device() != "0:32" {
+		t.Fatalf("FindByMountPoint() = %+v, %v, want mount 36 on 0:32", entry, ok)
+	}
+	if !IsMountPoint(entries, "/mnt/with space\ttab\nnl\\slash#hash") {
+		t.Fatalf("IsMountPoint() for escaped path = false, want true")
+	}
+	if IsMountPoint(entries, "/mnt") {
+		t.Fatalf("IsMountPoint(/mnt) = true, want false")
+	}
+	if got := FindByDevice(entries, 0, 32); len(got) != 2 || got[1].Root != "/projects/2026" {
+		t.Fatalf("FindByDevice(0:32) = %+v, want the staging mount and its subpath bind", got)
+	}
+
+	entries, warnings, err = Parse(strings.NewReader("garbage\n" + sample))
+	if err != nil {
+		t.Fatalf("Parse() with malformed line error = %v", err)
+	}
+	if len(entries) != 4 || len(warnings) != 1 || !strings.HasPrefix(warnings[0].Error(), "line 1: ") {
+		t.Fatalf("Parse() with malformed line = %d entries, warnings %v, want 4 entries and line 1 skipped", len(entries), warnings)
+	}
+}
+
+func TestFindByMountPointReturnsTopOfStack(t *testing.T) {
+	entries, _, err := Parse(strings.NewReader(
+		"36 22 0:32 / /stage rw - tmpfs tmpfs rw\n" +
+			"37 36 0:40 / /stage rw - fuse.sshfs host:/ rw\n",
+	))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	entry, ok := FindByMountPoint(entries, "/stage")
+	if !ok || entry.ID != 37 {
+		t.Fatalf("FindByMountPoint() = %d, want 37", entry.ID)
+	}
+	if got := FindAllByMountPoint(entries, "/stage"); len(got) != 2 || got[0].ID != 36 {
+		t.Fatalf("FindAllByMountPoint() = %+v, want mounts 36 and 37", got)
+	}
+}
+
+func TestTree(t *testing.T) {
+	entries, _, err := Parse(strings.NewReader(sample))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	tree := NewTree(entries)
+	if len(tree.Roots) != 1 || tree.Roots[0].ID != 22 {
+		t.Fatalf("NewTree() roots = %+v, want mount 22", tree.Roots)
+	}
+	staging, ok := tree.Lookup(36)
+	if !ok {
+		t.Fatalf("Lookup(36) found = false")
+	}
+	if staging.Parent == nil || staging.Parent.ID != 22 {
+		t.Fatalf("Lookup(36).Parent = %+v, want mount 22", staging.Parent)
+	}
+	var ids []int
+	for _, node := range tree.Roots[0].Descendants() {
+		ids = append(ids, node.ID)
+	}
+	if want := []int{36, 37, 38}; !reflect.DeepEqual(ids, want) {
+		t.Fatalf("Descendants() = %v, want %v", ids, want)
+	}
+}
+
+func TestReadFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mountinfo")
+	if err := os.WriteFile(path, []byte(sample), 0644); err != nil {
+		t.Fatalf("write mountinfo: %v", err)
+	}
+	entries, _, err := ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	if len(entries) != 4 {
+		t.Fatalf("ReadFile() entries = %d, want 4", len(entries))
+	}
+}
@@ -0,0 +1,53 @@
+package mountinfo
+
+// Node is a mount in a Tree.
+type Node struct {
+	Entry
+	Parent   *Node
+	Children []*Node
+}
+
+// Tree arranges entries by their parent mount IDs.
+type Tree struct {
+	Roots []*Node
+	byID  map[int]*Node
+}
+
+// NewTree builds a Tree from entries. Entries whose parent is not listed, such
+// as the root of a mount namespace, become roots.
+func NewTree(entries []Entry) *Tree {
+	t := &Tree{byID: make(map[int]*Node, len(entries))}
+	nodes := make([]*Node, 0, len(entries))
+	for _, entry := range entries {
+		node := &Node{Entry: entry}
+		t.byID[entry.ID] = node
+		nodes = append(nodes, node)
+	}
+	for _, node := range nodes {
+		parent, ok := t.byID[node.ParentID]
+		if !ok || parent == node {
+			t.Roots = append(t.Roots, node)
+			continue
+		}
+		node.Parent = parent
+		parent.Children = append(parent.Children, node)
+	}
+	return t
+}
+
+// Lookup returns the node with the given mount ID.
+func (t *Tree) Lookup(id int) (*Node, bool) {
+	node, ok := t.byID[id]
+	return node, ok
+}
+
+// Descendants returns every mount below node, depth first, children before
+// their own descendants.
+func (n *Node) Descendants() []*Node {
+	var out []*Node
+	for _, child := range n.Children {
+		out = append(out, child)
+		out = append(out, child.Descendants()...)
+	}
+	return out
+}
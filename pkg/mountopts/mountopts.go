@@ -0,0 +1,195 @@
+// Package mountopts translates mount(8) style option strings into mount(2)
+// flags and filesystem-specific data.
+package mountopts
+
+import (
+	"fmt"
+	"strings"
+	"syscall"
+)
+
+// Mount flags the syscall package does not define.
+const (
+	msNoSymFollow = 0x100
+	msLazyTime    = 1 << 25
+)
+
+// Options is the result of parsing an option string.
+type Options struct {
+	// Flags are the MS_* flags for the initial mount(2) call.
+	Flags uintptr
+	// Propagation holds MS_SHARED, MS_SLAVE, MS_PRIVATE or MS_UNBINDABLE,
+	// optionally with MS_REC, to apply after the mount exists.
+	Propagation uintptr
+	// Data holds the remaining filesystem-specific options, comma separated.
+	Data string
+}
+
+type flagOption struct {
+	flag  uintptr
+	clear bool
+	// group names options that are mutually exclusive with each other.
+	group string
+}
+
+// flagOptions is the util-linux vocabulary of options that map to mount flags.
+var flagOptions = map[string]flagOption{
+	"ro":            {flag: syscall.MS_RDONLY, group: "ro"},
+	"rw":            {flag: syscall.MS_RDONLY, clear: true, group: "ro"},
+	"nosuid":        {flag: syscall.MS_NOSUID, group: "suid"},
+	"suid":          {flag: syscall.MS_NOSUID, clear: true, group: "suid"},
+	"nodev":         {flag: syscall.MS_NODEV, group: "dev"},
+	"dev":           {flag: syscall.MS_NODEV, clear: true, group: "dev"},
+	"noexec":        {flag: syscall.MS_NOEXEC, group: "exec"},
+	"exec":          {flag: syscall.MS_NOEXEC, clear: true, group: "exec"},
+	"sync":          {flag: syscall.MS_SYNCHRONOUS, group: "sync"},
+	"async":         {flag: syscall.MS_SYNCHRONOUS, clear: true, group: "sync"},
+	"dirsync":       {flag: syscall.MS_DIRSYNC, group: "dirsync"},
+	"mand":          {flag: syscall.MS_MANDLOCK, group: "mand"},
+	"nomand":        {flag: syscall.MS_MANDLOCK, clear: true, group: "mand"},
+	"noatime":       {flag: syscall.MS_NOATIME, group: "atime"},
+	"atime":         {flag: syscall.MS_NOATIME, clear: true, group: "atime"},
+	"relatime":      {flag: syscall.MS_RELATIME, group: "atime"},
+	"norelatime":    {flag: syscall.MS_RELATIME, clear: true, group: "atime"},
+	"strictatime":   {flag: syscall.MS_STRICTATIME, group: "atime"},
+	"nostrictatime": {flag: syscall.MS_STRICTATIME, clear: true, group: "atime"},
+	"nodiratime":    {flag: syscall.MS_NODIRATIME, group: "diratime"},
+	"diratime":      {flag: syscall.MS_NODIRATIME, clear: true, group: "diratime"},
+	"lazytime":      {flag: msLazyTime, group: "lazytime"},
+	"nolazytime":    {flag: msLazyTime, clear: true, group: "lazytime"},
+	"silent":        {flag: syscall.MS_SILENT, group: "silent"},
+	"loud":          {flag: syscall.MS_SILENT, clear: true, group: "silent"},
+	"nosymfollow":   {flag: msNoSymFollow, group: "symfollow"},
+	"symfollow":     {flag: msNoSymFollow, clear: true, group: "symfollow"},
+	"iversion":      {flag: syscall.MS_I_VERSION, group: "iversion"},
+	"noiversion":    {flag: syscall.MS_I_VERSION, clear: true, group: "iversion"},
+	"remount":       {flag: syscall.MS_REMOUNT, group: "remount"},
+	"bind":          {flag: syscall.MS_BIND, group: "bind"},
+	"rbind":         {flag: syscall.MS_BIND | syscall.MS_REC, group: "bind"},
+	"move":          {flag: syscall.MS_MOVE, group: "move"},
+}
+
+// propagationOptions map to a propagation change on the new mount.
+var propagationOptions = map[string]uintptr{
+	"shared":      syscall.MS_SHARED,
+	"rshared":     syscall.MS_SHARED | syscall.MS_REC,
+	"slave":       syscall.MS_SLAVE,
+	"rslave":      syscall.MS_SLAVE | syscall.MS_REC,
+	"private":     syscall.MS_PRIVATE,
+	"rprivate":    syscall.MS_PRIVATE | syscall.MS_REC,
+	"unbindable":  syscall.MS_UNBINDABLE,
+	"runbindable": syscall.MS_UNBINDABLE | syscall.MS_REC,
+}
+
+// userspaceOptions are only meaningful to mount(8) and fstab and are never
+// passed to the kernel.
+var userspaceOptions = map[string]bool{
+	"defaults": true,
+	"auto":     true,
+	"noauto":   true,
+	"user":     true,
+	"nouser":   true,
+	"users":    true,
+	"owner":    true,
+	"group":    true,
+	"nofail":   true,
+	"_netdev":  true,
+}
+
+// Parse splits a comma separated option string into mount flags, propagation
+// flags and filesystem data. It rejects options that contradict each other,
+// such as "ro,rw" or "noatime,strictatime"; repeating the same option is
+// allowed.
+func Parse(opts string) (Options, error) {
+	var out Options
+	var data []string
+	chosen := map[string]string{}
+	propagation := ""
+
+	for _, opt := range Split(opts) {
+		if f, ok := flagOptions[opt]; ok {
+			if prev, ok := chosen[f.group]; ok && prev != opt {
+				return Options{}, fmt.Errorf("conflicting mount options %q and %q", prev, opt)
+			}
+			chosen[f.group] = opt
+			if f.clear {
+				out.Flags &^= f.flag
+			} else {
+				out.Flags |= f.flag
+			}
+			continue
+		}
+		if p, ok := propagationOptions[opt]; ok {
+			if propagation != "" && propagation != opt {
+				return Options{}, fmt.Errorf("conflicting mount options %q and %q", propagation, opt)
+			}
+			propagation = opt
+			out.Propagation = p
+			continue
+		}
+		if isUserspaceOption(opt) {
+			continue
+		}
+		data = append(data, opt)
+	}
+
+	if out.Flags&syscall.MS_MOVE != 0 && out.Flags&^syscall.MS_MOVE != 0 {
+		return Options{}, fmt.Errorf("mount option %q cannot be combined with other flags", "move")
+	}
+	out.Data = strings.Join(data, ",")
+	return out, nil
+}
+
+// Split returns the trimmed, non-empty options in opts.
+func Split(opts string) []string {
+	var out []string
+	for _, opt := range strings.Split(opts, ",") {
+		if o := strings.TrimSpace(opt); o != "" {
+			out = append(out, o)
+		}
+	}
+	return out
+}
+
+func isUserspaceOption(opt string) bool {
+	if userspaceOptions[opt] {
+		return true
+	}
+	return strings.HasPrefix(opt, "x-") || strings.HasPrefix(opt, "comment=")
+}
+
+// PerMountFlags are the flags that apply to an individual mount rather than
+// the filesystem, so they can differ between bind mounts of the same source.
+const PerMountFlags = syscall.MS_RDONLY | syscall.MS_NOSUID | syscall.MS_NODEV | syscall.MS_NOEXEC |
+	syscall.MS_NOATIME | syscall.MS_NODIRATIME | syscall.MS_RELATIME | syscall.MS_STRICTATIME | msNoSymFollow
+
+// Merge combines two option strings. An option in override replaces any option
+// in base that sets the same flag, propagation type or data key, so "rw" in
+// override drops "ro" from base and "uid=2" drops "uid=1". Conflicts within
+// either string are left for Parse to report.
+func Merge(base, override string) string {
+	overrides := Split(override)
+	replaced := map[string]bool{}
+	for _, opt := range overrides {
+		replaced[optionKey(opt)] = true
+	}
+	var out []string
+	for _, opt := range Split(base) {
+		if !replaced[optionKey(opt)] {
+			out = append(out, opt)
+		}
+	}
+	return strings.Join(append(out, overrides...), ",")
+}
+
+// optionKey identifies what an option sets, for Merge.
+func optionKey(opt string) string {
+	if f, ok := flagOptions[opt]; ok {
+		return "flag:" + f.group
+	}
+	if _, ok := propagationOptions[opt]; ok {
+		return "propagation"
+	}
+	key, _, _ := strings.Cut(opt, "=")
+	return "data:" + key
+}
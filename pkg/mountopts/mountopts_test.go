@@ -0,0 +1,116 @@
+package mountopts
+
+import (
+	"syscall"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    string
+		want    Options
+		wantErr bool
+	}{
+		{name: "empty", opts: ""},
+		{name: "blank entries", opts: " , ro ,, ", want: Options{Flags: syscall.MS_RDONLY}},
+		{
+			name: "legacy set",
+			opts: "ro,nosuid,nodev,noexec,noatime",
+			want: Options{Flags: syscall.MS_RDONLY | syscall.MS_NOSUID | syscall.MS_NODEV | syscall.MS_NOEXEC | syscall.MS_NOATIME},
+		},
+		{
+			name: "write behaviour",
+			opts: "sync,dirsync,lazytime",
+			want: Options{Flags: syscall.MS_SYNCHRONOUS | syscall.MS_DIRSYNC | msLazyTime},
+		},
+		{
+			name: "access time",
+			opts: "strictatime,nodiratime",
+			want: Options{Flags: syscall.MS_STRICTATIME | syscall.MS_NODIRATIME},
+		},
+		{
+			name: "misc flags",
+			opts: "nosymfollow,mand,silent,iversion",
+			want: Options{Flags: msNoSymFollow | syscall.MS_MANDLOCK | syscall.MS_SILENT | syscall.MS_I_VERSION},
+		},
+		{
+			name: "negations are not data",
+			opts: "rw,suid,dev,exec,async,atime,diratime,nolazytime,loud,symfollow,nomand,noiversion",
+		},
+		{name: "repeated option", opts: "ro,ro", want: Options{Flags: syscall.MS_RDONLY}},
+		{name: "rbind", opts: "rbind", want: Options{Flags: syscall.MS_BIND | syscall.MS_REC}},
+		{name: "propagation", opts: "rslave", want: Options{Propagation: syscall.MS_SLAVE | syscall.MS_REC}},
+		{name: "private", opts: "ro,private", want: Options{Flags: syscall.MS_RDONLY, Propagation: syscall.MS_PRIVATE}},
+		{
+			name: "data keeps order",
+			opts: "allow_other,ro,user_id=0,max_read=131072",
+			want: Options{Flags: syscall.MS_RDONLY, Data: "allow_other,user_id=0,max_read=131072"},
+		},
+		{
+			name: "userspace options dropped",
+			opts: "defaults,nofail,_netdev,noauto,x-systemd.automount,comment=foo,cache=loose",
+			want: Options{Data: "cache=loose"},
+		},
+		{name: "ro and rw", opts: "ro,rw", wantErr: true},
+		{name: "suid and nosuid", opts: "nosuid,suid", wantErr: true},
+		{name: "noatime and relatime", opts: "noatime,relatime", wantErr: true},
+		{name: "strictatime and norelatime", opts: "strictatime,norelatime", wantErr: true},
+		{name: "sync and async", opts: "sync,async", wantErr: true},
+		{name: "two propagation types", opts: "shared,slave", wantErr: true},
+		{name: "recursive and plain propagation", opts: "private,rprivate", wantErr: true},
+		{name: "bind and rbind", opts: "bind,rbind", wantErr: true},
+		{name: "move with other flags", opts: "move,ro", wantErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := Parse(tc.opts)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("Parse(%q) error = nil, want error", tc.opts)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse(%q) error = %v", tc.opts, err)
+			}
+			if got != tc.want {
+				t.Fatalf("Parse(%q) = %+v, want %+v", tc.opts, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSplit(t *testing.T) {
+	got := Split(" ro, ,user_id=0 ,")
+	if len(got) != 2 || got[0] != "ro" || got[1] != "user_id=0" {
+		t.Fatalf("Split() = %q, want [ro user_id=0]", got)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	tests := []struct {
+		name     string
+		base     string
+		override string
+		want     string
+	}{
+		{name: "empty", want: ""},
+		{name: "base only", base: "ro,nosuid", want: "ro,nosuid"},
+		{name: "override only", override: "noexec", want: "noexec"},
+		{name: "negation replaces flag", base: "ro,nosuid", override: "rw", want: "nosuid,rw"},
+		{name: "atime group", base: "noatime", override: "strictatime", want: "strictatime"},
+		{name: "data key", base: "uid=1000,allow_other", override: "uid=0", want: "allow_other,uid=0"},
+		{name: "propagation", base: "rshared", override: "private", want: "private"},
+		{name: "duplicates", base: "nodev", override: "nodev", want: "nodev"},
+		{name: "base conflict kept", base: "ro,rw", override: "nodev", want: "ro,rw,nodev"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := Merge(tc.base, tc.override); got != tc.want {
+				t.Fatalf("Merge(%q, %q) = %q, want %q", tc.base, tc.override, got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/joejulian/csi-justmount/pkg/mountopts"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// bindFsType selects a bind mount of a host directory instead of a
+// filesystem mount.
+const bindFsType = "bind"
+
+var errBindNotAllowed = errors.New("host path is not below an allowed bind path")
+
+// parseBindRecursive validates the recursive attribute of a bind volume.
+func parseBindRecursive(volumeContext map[string]string, fsType string) (bool, error) {
+	v, ok := volumeContext["recursive"]
+	if !ok {
+		return false, nil
+	}
+	if fsType != bindFsType {
+		return false, errors.New("recursive is only supported for bind volumes")
+	}
+	recursive, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("invalid recursive %q: %w", v, err)
+	}
+	return recursive, nil
+}
+
+// resolveBindSource resolves symlinks in the host directory source and checks
+// the result against the allowed bind paths, so a symlink cannot lead a bind
+// volume outside them.
+func (n *Node) resolveBindSource(source string) (string, error) {
+	if !filepath.IsAbs(source) {
+		return "", fmt.Errorf("bind source %q must be an absolute host path", source)
+	}
+	resolved, err := filepath.EvalSymlinks(source)
+	if err != nil {
+		return "", err
+	}
+	if !bindPathAllowed(n.bindAllowedPaths, resolved) {
+		return "", fmt.Errorf("%w: %s", errBindNotAllowed, resolved)
+	}
+	info, err := os.Stat(resolved)
+	if err != nil {
+		return "", err
+	}
+	if !info.IsDir() {
+		return "", fmt.Errorf("bind source %q is not a directory", resolved)
+	}
+	return resolved, nil
+}
+
+// bindPathAllowed reports whether path is one of the allowed prefixes or
+// below one of them.
+func bindPathAllowed(allowed []string, path string) bool {
+	for _, prefix := range allowed {
+		prefix = filepath.Clean(prefix)
+		if !filepath.IsAbs(prefix) {
+			continue
+		}
+		if path == prefix || prefix == "/" || strings.HasPrefix(path, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// bindSourceError maps a resolveBindSource failure to a status code.
+func bindSourceError(err error) error {
+	switch {
+	case errors.Is(err, errBindNotAllowed):
+		return status.Errorf(codes.PermissionDenied, "%v; add it to --bind-allowed-paths to allow it", err)
+	case os.IsNotExist(err):
+		return status.Errorf(codes.FailedPrecondition, "bind source does not exist: %v", err)
+	}
+	return status.Errorf(codes.InvalidArgument, "invalid bind source: %v", err)
+}
+
+// mountBindStaging binds the host directory of rec onto its staging path. A
+// recursive bind carries the submounts below the host directory along.
+func (n *Node) mountBindStaging(ctx context.Context, rec *volumeRecord) error {
+	volumePath := rec.StagingTargetPath
+	source, err := n.resolveBindSource(rec.Source)
+	if err != nil {
+		Logger(ctx).Error("refusing bind source", zap.String("source", rec.Source), zap.Error(err))
+		return bindSourceError(err)
+	}
+	flags := rec.MountFlags & (mountopts.PerMountFlags | syscall.MS_REC)
+	if err := n.bindMount(ctx, source, volumePath, flags); err != nil {
+		Logger(ctx).Error("bind mount failed",
+			zap.String("source", source),
+			zap.String("target", volumePath),
+			zap.Bool("recursive", flags&syscall.MS_REC != 0),
+			zap.Error(err),
+		)
+		return status.Errorf(codes.Internal, "failed to bind-mount %s: %v", source, err)
+	}
+	if err := n.waitForMount(ctx, volumePath, true); err != nil {
+		Logger(ctx).Error("staging mount did not become ready", zap.String("target", volumePath), zap.Error(err))
+		return err
+	}
+	logMountInfo(ctx, volumePath, "mountinfo after bind mount")
+	if rec.MountPropagation != 0 {
+		if err := n.mounter.Mount("", volumePath, "", rec.MountPropagation, ""); err != nil {
+			Logger(ctx).Error("failed to set mount propagation", zap.String("target", volumePath), zap.Error(err))
+			return status.Errorf(codes.Internal, "failed to set mount propagation: %v", err)
+		}
+	}
+	return nil
+}
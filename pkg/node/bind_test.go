@@ -0,0 +1,323 @@
+package node
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestNodeStageVolumeBind(t *testing.T) {
+	allowed, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	export := filepath.Join(allowed, "export")
+	if err := os.Mkdir(export, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(export, filepath.Join(allowed, "current")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("/etc", filepath.Join(allowed, "escape")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(allowed, "file"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	outside := t.TempDir()
+
+	tests := []struct {
+		name       string
+		fsType     string
+		source     string
+		context    map[string]string
+		allowed    []string
+		wantCode   codes.Code
+		wantSource string
+		wantFlags  uintptr
+	}{
+		{
+			name:       "allowed directory",
+			fsType:     "bind",
+			source:     export,
+			wantSource: export,
+			wantFlags:  syscall.MS_BIND,
+		},
+		{
+			name:       "symlink is resolved",
+			fsType:     "bind",
+			source:     filepath.Join(allowed, "current"),
+			wantSource: export,
+			wantFlags:  syscall.MS_BIND,
+		},
+		{
+			name:       "recursive",
+			fsType:     "bind",
+			source:     export,
+			context:    map[string]string{"recursive": "true"},
+			wantSource: export,
+			wantFlags:  syscall.MS_BIND | syscall.MS_REC,
+		},
+		{
+			name:       "rbind mount option",
+			fsType:     "bind",
+			source:     export,
+			context:    map[string]string{"mountOptions": "rbind"},
+			wantSource: export,
+			wantFlags:  syscall.MS_BIND | syscall.MS_REC,
+		},
+		{
+			name:     "directory outside the allowed paths",
+			fsType:   "bind",
+			source:   outside,
+			wantCode: codes.PermissionDenied,
+		},
+		{
+			name:     "symlink out of the allowed paths",
+			fsType:   "bind",
+			source:   filepath.Join(allowed, "escape"),
+			wantCode: codes.PermissionDenied,
+		},
+		{
+			name:     "prefix of a sibling directory",
+			fsType:   "bind",
+			source:   export,
+			allowed:  []string{filepath.Join(allowed, "exp")},
+			wantCode: codes.PermissionDenied,
+		},
+		{
+			name:     "no allowed paths",
+			fsType:   "bind",
+			source:   export,
+			allowed:  []string{},
+			wantCode: codes.PermissionDenied,
+		},
+		{
+			name:     "missing directory",
+			fsType:   "bind",
+			source:   filepath.Join(allowed, "missing"),
+			wantCode: codes.FailedPrecondition,
+		},
+		{
+			name:     "regular file",
+			fsType:   "bind",
+			source:   filepath.Join(allowed, "file"),
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name:     "relative source",
+			fsType:   "bind",
+			source:   "export",
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name:     "filesystem options",
+			fsType:   "bind",
+			source:   export,
+			context:  map[string]string{"mountOptions": "uid=1000"},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name:     "bind option on another filesystem",
+			fsType:   "ext4",
+			source:   outside,
+			context:  map[string]string{"mountOptions": "bind"},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name:     "recursive on another filesystem",
+			fsType:   "glusterfs",
+			source:   "gluster:media",
+			context:  map[string]string{"recursive": "true"},
+			wantCode: codes.InvalidArgument,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			allowedPaths := []string{allowed}
+			if tt.allowed != nil {
+				allowedPaths = tt.allowed
+			}
+			volumeContext := map[string]string{"source": tt.source}
+			for k, v := range tt.context {
+				volumeContext[k] = v
+			}
+			mounter := &recordingMounter{mounted: map[string]bool{}}
+			n := NewNodeWithMounter("node-id", "/tmp/test-csi.sock", mounter, WithBindAllowedPaths(allowedPaths))
+			stubMountInfo(t, "")
+
+			_, err := n.NodeStageVolume(context.Background(),
+				stageRequest(filepath.Join(t.TempDir(), "stage"), tt.fsType, volumeContext))
+			if status.Code(err) != tt.wantCode {
+				t.Fatalf("NodeStageVolume() error = %v, want code %v", err, tt.wantCode)
+			}
+			if tt.wantCode != codes.OK {
+				if len(mounter.mounts) != 0 {
+					t.Fatalf("NodeStageVolume() mounted %v", mounter.mounts)
+				}
+				return
+			}
+			if len(mounter.mounts) != 1 || mounter.sources[0] != tt.wantSource || mounter.flags[0] != tt.wantFlags {
+				t.Fatalf("NodeStageVolume() mounted %v from %v with flags %#x, want %s with %#x",
+					mounter.mounts, mounter.sources, mounter.flags, tt.wantSource, tt.wantFlags)
+			}
+		})
+	}
+}
+
+func TestBindVolumeRecursiveSubmounts(t *testing.T) {
+	allowed, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	stagingPath := filepath.Join(t.TempDir(), "stage")
+	targetPath := filepath.Join(t.TempDir(), "target")
+	mounter := &recordingMounter{mounted: map[string]bool{}}
+	n := NewNodeWithMounter("node-id", "/tmp/test-csi.sock", mounter, WithBindAllowedPaths([]string{allowed}))
+	stubMountInfo(t, "")
+
+	if _, err := n.NodeStageVolume(context.Background(), stageRequest(stagingPath, "bind", map[string]string{
+		"source":    allowed,
+		"recursive": "true",
+	})); err != nil {
+		t.Fatalf("NodeStageVolume() error = %v", err)
+	}
+	if _, err := n.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{
+		VolumeId:          "test-volume",
+		StagingTargetPath: stagingPath,
+		TargetPath:        targetPath,
+		VolumeCapability: &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+		},
+	}); err != nil {
+		t.Fatalf("NodePublishVolume() error = %v", err)
+	}
+	if len(mounter.flags) != 2 || mounter.flags[1] != syscall.MS_BIND|syscall.MS_REC {
+		t.Fatalf("NodePublishVolume() flags = %#x, want a recursive bind", mounter.flags)
+	}
+
+	// The host mount the volume was taken from shares its device but is not
+	// a dependent of the staging mount.
+	for _, path := range []string{stagingPath + "/nfs", targetPath + "/nfs"} {
+		mounter.mounted[path] = true
+	}
+	stubMountInfo(t, "1 0 8:1 / "+allowed+" rw - ext4 /dev/sda1 rw\n"+
+		"2 0 8:1 /data "+stagingPath+" rw - ext4 /dev/sda1 rw\n"+
+		"3 0 0:50 / "+stagingPath+"/nfs rw - nfs4 server:/export rw\n"+
+		"4 0 8:1 /data "+targetPath+" rw - ext4 /dev/sda1 rw\n"+
+		"5 0 0:50 / "+targetPath+"/nfs rw - nfs4 server:/export rw\n")
+
+	if _, err := n.NodeUnpublishVolume(context.Background(), &csi.NodeUnpublishVolumeRequest{
+		VolumeId:   "test-volume",
+		TargetPath: targetPath,
+	}); err != nil {
+		t.Fatalf("NodeUnpublishVolume() error = %v", err)
+	}
+	stubMountInfo(t, "1 0 8:1 / "+allowed+" rw - ext4 /dev/sda1 rw\n"+
+		"2 0 8:1 /data "+stagingPath+" rw - ext4 /dev/sda1 rw\n"+
+		"3 0 0:50 / "+stagingPath+"/nfs rw - nfs4 server:/export rw\n")
+	if _, err := n.NodeUnstageVolume(context.Background(), &csi.NodeUnstageVolumeRequest{
+		VolumeId:          "test-volume",
+		StagingTargetPath: stagingPath,
+	}); err != nil {
+		t.Fatalf("NodeUnstageVolume() error = %v", err)
+	}
+
+	want := []string{targetPath + "/nfs", targetPath, stagingPath + "/nfs", stagingPath}
+	if len(mounter.unmounts) != len(want) {
+		t.Fatalf("unmounts = %v, want %v", mounter.unmounts, want)
+	}
+	for i := range want {
+		if mounter.unmounts[i] != want[i] {
+			t.Fatalf("unmounts = %v, want %v", mounter.unmounts, want)
+		}
+	}
+}
+
+func TestBindVolumeFromMountRoot(t *testing.T) {
+	// The host directory is itself the root of a mount, as with a pre-mounted
+	// NFS export, so the host mount and the staging mount look alike.
+	allowed, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	stagingPath := filepath.Join(t.TempDir(), "stage")
+	targetPath := filepath.Join(t.TempDir(), "target")
+	mounter := &recordingMounter{mounted: map[string]bool{}}
+	n := NewNodeWithMounter("node-id", "/tmp/test-csi.sock", mounter, WithBindAllowedPaths([]string{allowed}))
+	stubMountInfo(t, "")
+
+	if _, err := n.NodeStageVolume(context.Background(), stageRequest(stagingPath, "bind", map[string]string{
+		"source": allowed,
+	})); err != nil {
+		t.Fatalf("NodeStageVolume() error = %v", err)
+	}
+	if _, err := n.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{
+		VolumeId:          "test-volume",
+		StagingTargetPath: stagingPath,
+		TargetPath:        targetPath,
+		VolumeCapability: &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+		},
+	}); err != nil {
+		t.Fatalf("NodePublishVolume() error = %v", err)
+	}
+
+	hostMount := "1 0 0:50 / " + allowed + " rw - nfs4 server:/export rw\n"
+	stubMountInfo(t, hostMount+
+		"2 0 0:50 / "+stagingPath+" rw - nfs4 server:/export rw\n"+
+		"3 0 0:50 / "+targetPath+" rw - nfs4 server:/export rw\n")
+	unstage := &csi.NodeUnstageVolumeRequest{VolumeId: "test-volume", StagingTargetPath: stagingPath}
+	if _, err := n.NodeUnstageVolume(context.Background(), unstage); status.Code(err) != codes.FailedPrecondition ||
+		strings.Contains(err.Error(), allowed) {
+		t.Fatalf("NodeUnstageVolume() while published error = %v, want FailedPrecondition naming only the target", err)
+	}
+
+	if _, err := n.NodeUnpublishVolume(context.Background(), &csi.NodeUnpublishVolumeRequest{
+		VolumeId:   "test-volume",
+		TargetPath: targetPath,
+	}); err != nil {
+		t.Fatalf("NodeUnpublishVolume() error = %v", err)
+	}
+	stubMountInfo(t, hostMount+"2 0 0:50 / "+stagingPath+" rw - nfs4 server:/export rw\n")
+	if _, err := n.NodeUnstageVolume(context.Background(), unstage); err != nil {
+		t.Fatalf("NodeUnstageVolume() error = %v", err)
+	}
+	if len(mounter.unmounts) != 2 || mounter.unmounts[0] != targetPath || mounter.unmounts[1] != stagingPath {
+		t.Fatalf("unmounts = %v, want only %s and %s", mounter.unmounts, targetPath, stagingPath)
+	}
+}
+
+func TestUnmountDependentMountsKeepsBindHostMount(t *testing.T) {
+	allowed, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	stagingPath := filepath.Join(t.TempDir(), "stage")
+	mounter := &recordingMounter{mounted: map[string]bool{allowed: true, stagingPath: true}}
+	n := NewNodeWithMounter("node-id", "/tmp/test-csi.sock", mounter)
+	if err := n.state.putStage(volumeRecord{
+		VolumeID:          "test-volume",
+		StagingTargetPath: stagingPath,
+		Source:            allowed,
+		FsType:            bindFsType,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	stubMountInfo(t, "1 0 0:50 / "+allowed+" rw - nfs4 server:/export rw\n"+
+		"2 0 0:50 / "+stagingPath+" rw - nfs4 server:/export rw\n")
+
+	if err := n.unmountDependentMounts(context.Background(), stagingPath); err != nil {
+		t.Fatalf("unmountDependentMounts() error = %v", err)
+	}
+	if len(mounter.unmounts) != 0 {
+		t.Fatalf("unmountDependentMounts() unmounted %v, want the host mount left alone", mounter.unmounts)
+	}
+}
@@ -0,0 +1,156 @@
+package node
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// checkBlockDevice returns an error unless path is a block device node.
+var checkBlockDevice = func(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeDevice == 0 || info.Mode()&os.ModeCharDevice != 0 {
+		return fmt.Errorf("%s is not a block device", path)
+	}
+	return nil
+}
+
+// nodeStageBlockVolume stages a raw block volume. There is no filesystem to
+// mount, so staging only validates the device and records it for publish.
+func (n *Node) nodeStageBlockVolume(ctx context.Context, req *csi.NodeStageVolumeRequest) (*csi.NodeStageVolumeResponse, error) {
+	source := req.GetVolumeContext()["source"]
+	if source == "" {
+		Logger(ctx).Error("NodeStageVolume invalid argument: source is required")
+		return nil, status.Error(codes.InvalidArgument, "source is a required parameter in VolumeContext")
+	}
+	if err := checkBlockDevice(source); err != nil {
+		Logger(ctx).Error("NodeStageVolume invalid argument: source is not a block device",
+			zap.String("source", source),
+			zap.Error(err),
+		)
+		return nil, status.Errorf(codes.InvalidArgument, "source is not a block device: %v", err)
+	}
+	if err := refusedByPolicy(ctx, n.policy.checkBlock(source), blockPolicyFsType, source, ""); err != nil {
+		return nil, err
+	}
+
+	release, err := n.acquireOperation(ctx, "NodeStageVolume", req.GetVolumeId(), req.GetStagingTargetPath())
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	if err := os.MkdirAll(req.GetStagingTargetPath(), 0755); err != nil {
+		Logger(ctx).Error("NodeStageVolume failed to create staging path", zap.Error(err))
+		return nil, status.Errorf(codes.Internal, "failed to create staging path: %v", err)
+	}
+	if err := n.saveStageState(ctx, volumeRecord{
+		VolumeID:          req.GetVolumeId(),
+		StagingTargetPath: filepath.Clean(req.GetStagingTargetPath()),
+		Source:            source,
+		Block:             true,
+		VolumeContext:     req.GetVolumeContext(),
+	}); err != nil {
+		return nil, err
+	}
+
+	Logger(ctx).Info("NodeStageVolume complete: block device staged", zap.String("source", source))
+	return &csi.NodeStageVolumeResponse{}, nil
+}
+
+// nodePublishBlockVolume bind-mounts the staged block device onto a file at
+// the target path.
+func (n *Node) nodePublishBlockVolume(ctx context.Context, req *csi.NodePublishVolumeRequest) (*csi.NodePublishVolumeResponse, error) {
+	rec, ok := n.state.get(req.GetVolumeId())
+	if !ok || !rec.Block {
+		Logger(ctx).Error("NodePublishVolume block volume is not staged")
+		return nil, status.Error(codes.FailedPrecondition, "block volume is not staged")
+	}
+	device := rec.Source
+	if err := checkBlockDevice(device); err != nil {
+		Logger(ctx).Error("NodePublishVolume staged block device is not usable",
+			zap.String("source", device),
+			zap.Error(err),
+		)
+		return nil, status.Errorf(codes.FailedPrecondition, "staged block device is not usable: %v", err)
+	}
+	var flags uintptr
+	if publishReadonly(req) {
+		flags = syscall.MS_RDONLY
+	}
+
+	release, err := n.acquireOperation(ctx, "NodePublishVolume", req.GetVolumeId(), req.GetTargetPath())
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	targetPath := req.GetTargetPath()
+	isMounted, err := n.mounter.IsMountPoint(targetPath)
+	if err != nil && !os.IsNotExist(err) {
+		Logger(ctx).Error("NodePublishVolume failed to check target mountpoint", zap.Error(err))
+		return nil, status.Errorf(codes.Internal, "failed to verify target path mountpoint: %v", err)
+	}
+	if isMounted {
+		if err := n.checkPublishedReadonly(ctx, req, flags != 0); err != nil {
+			return nil, err
+		}
+		if err := n.savePublishState(ctx, req, flags); err != nil {
+			return nil, err
+		}
+		Logger(ctx).Info("NodePublishVolume complete: block device already published")
+		return &csi.NodePublishVolumeResponse{}, nil
+	}
+
+	if err := createBlockTarget(targetPath); err != nil {
+		Logger(ctx).Error("NodePublishVolume failed to create block target file", zap.Error(err))
+		return nil, status.Errorf(codes.Internal, "failed to create target file: %v", err)
+	}
+	if err := n.bindMount(ctx, device, targetPath, flags); err != nil {
+		Logger(ctx).Error("failed to bind-mount block device",
+			zap.String("source", device),
+			zap.String("target_path", targetPath),
+			zap.Error(err),
+		)
+		return nil, status.Errorf(codes.Internal, "failed to bind-mount block device: %v", err)
+	}
+	if err := n.savePublishState(ctx, req, flags); err != nil {
+		return nil, err
+	}
+
+	Logger(ctx).Info("NodePublishVolume complete: block device published")
+	return &csi.NodePublishVolumeResponse{}, nil
+}
+
+// createBlockTarget creates the empty file a block device is bound onto. A
+// directory at the target path is refused rather than replaced.
+func createBlockTarget(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		return err
+	}
+	info, err := os.Lstat(path)
+	if err == nil {
+		if !info.Mode().IsRegular() {
+			return fmt.Errorf("%s exists and is not a regular file", path)
+		}
+		return nil
+	}
+	if !os.IsNotExist(err) {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0660)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,132 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func blockCapability() *csi.VolumeCapability {
+	return &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Block{Block: &csi.VolumeCapability_BlockVolume{}},
+		AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+	}
+}
+
+func stubBlockDevices(t *testing.T, devices ...string) {
+	t.Helper()
+	orig := checkBlockDevice
+	checkBlockDevice = func(path string) error {
+		for _, device := range devices {
+			if path == device {
+				return nil
+			}
+		}
+		return errors.New("not a block device")
+	}
+	t.Cleanup(func() { checkBlockDevice = orig })
+}
+
+func TestBlockVolumeLifecycle(t *testing.T) {
+	const device = "/dev/test-block"
+	stubBlockDevices(t, device)
+	stubMountInfo(t, "")
+
+	stagingPath := filepath.Join(t.TempDir(), "stage")
+	targetPath := filepath.Join(t.TempDir(), "publish", "vol")
+	mounter := &recordingMounter{mounted: map[string]bool{}}
+	n := NewNodeWithMounter("node-id", "/tmp/test-csi.sock", mounter)
+	ctx := context.Background()
+
+	if _, err := n.NodeStageVolume(ctx, &csi.NodeStageVolumeRequest{
+		VolumeId:          "block-volume",
+		StagingTargetPath: stagingPath,
+		VolumeCapability:  blockCapability(),
+		VolumeContext:     map[string]string{"source": device},
+	}); err != nil {
+		t.Fatalf("NodeStageVolume() error = %v", err)
+	}
+	if len(mounter.mounts) != 0 {
+		t.Fatalf("NodeStageVolume() mounts = %v, want none", mounter.mounts)
+	}
+
+	if _, err := n.NodePublishVolume(ctx, &csi.NodePublishVolumeRequest{
+		VolumeId:          "block-volume",
+		StagingTargetPath: stagingPath,
+		TargetPath:        targetPath,
+		VolumeCapability:  blockCapability(),
+	}); err != nil {
+		t.Fatalf("NodePublishVolume() error = %v", err)
+	}
+	info, err := os.Stat(targetPath)
+	if err != nil || !info.Mode().IsRegular() {
+		t.Fatalf("publish target = %v, %v, want a regular file", info, err)
+	}
+	if len(mounter.mounts) != 1 || mounter.sources[0] != device || mounter.mounts[0] != targetPath {
+		t.Fatalf("NodePublishVolume() mounts = %v from %v, want %s from %s", mounter.mounts, mounter.sources, targetPath, device)
+	}
+
+	if _, err := n.NodeUnpublishVolume(ctx, &csi.NodeUnpublishVolumeRequest{
+		VolumeId:   "block-volume",
+		TargetPath: targetPath,
+	}); err != nil {
+		t.Fatalf("NodeUnpublishVolume() error = %v", err)
+	}
+	if _, err := os.Stat(targetPath); !os.IsNotExist(err) {
+		t.Fatalf("publish target after unpublish: stat error = %v, want not exist", err)
+	}
+
+	if _, err := n.NodeUnstageVolume(ctx, &csi.NodeUnstageVolumeRequest{
+		VolumeId:          "block-volume",
+		StagingTargetPath: stagingPath,
+	}); err != nil {
+		t.Fatalf("NodeUnstageVolume() error = %v", err)
+	}
+	if len(mounter.unmounts) != 1 || mounter.unmounts[0] != targetPath {
+		t.Fatalf("unmounts = %v, want only the publish target", mounter.unmounts)
+	}
+	if _, ok := n.state.get("block-volume"); ok {
+		t.Fatalf("stage state still present after unstage")
+	}
+}
+
+func TestNodeStageBlockVolumeRejectsNonBlockSource(t *testing.T) {
+	stubBlockDevices(t)
+	n := NewNodeWithMounter("node-id", "/tmp/test-csi.sock", &recordingMounter{mounted: map[string]bool{}})
+
+	_, err := n.NodeStageVolume(context.Background(), &csi.NodeStageVolumeRequest{
+		VolumeId:          "block-volume",
+		StagingTargetPath: t.TempDir(),
+		VolumeCapability:  blockCapability(),
+		VolumeContext:     map[string]string{"source": "/etc/hostname"},
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("NodeStageVolume() code = %v, want %v", status.Code(err), codes.InvalidArgument)
+	}
+}
+
+func TestNodePublishBlockVolumeRequiresStage(t *testing.T) {
+	n := NewNodeWithMounter("node-id", "/tmp/test-csi.sock", &recordingMounter{mounted: map[string]bool{}})
+
+	_, err := n.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{
+		VolumeId:          "block-volume",
+		StagingTargetPath: t.TempDir(),
+		TargetPath:        filepath.Join(t.TempDir(), "vol"),
+		VolumeCapability:  blockCapability(),
+	})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("NodePublishVolume() code = %v, want %v", status.Code(err), codes.FailedPrecondition)
+	}
+}
+
+func TestCreateBlockTargetRefusesDirectory(t *testing.T) {
+	if err := createBlockTarget(t.TempDir()); err == nil {
+		t.Fatalf("createBlockTarget(dir) error = nil, want error")
+	}
+}
@@ -0,0 +1,175 @@
+package node
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// blkidTimeout bounds the signature probe of a device and mkfsTimeout the
+// formatting of a blank one, so a hung device cannot hold staging forever.
+const (
+	blkidTimeout = time.Minute
+	mkfsTimeout  = 10 * time.Minute
+)
+
+// formatOptions describes the formatIfBlank and mkfsOptions attributes.
+type formatOptions struct {
+	enabled bool
+	args    []string
+}
+
+// parseFormatOptions validates the format-on-first-use attributes in
+// volumeContext.
+func parseFormatOptions(volumeContext map[string]string) (formatOptions, error) {
+	var opts formatOptions
+	if v, ok := volumeContext["formatIfBlank"]; ok {
+		enabled, err := strconv.ParseBool(v)
+		if err != nil {
+			return opts, fmt.Errorf("invalid formatIfBlank %q: %w", v, err)
+		}
+		opts.enabled = enabled
+	}
+	if v := volumeContext["mkfsOptions"]; v != "" {
+		if !opts.enabled {
+			return opts, errors.New("mkfsOptions requires formatIfBlank")
+		}
+		opts.args = strings.Fields(v)
+	}
+	return opts, nil
+}
+
+// deviceSignature is what blkid found on a device.
+type deviceSignature struct {
+	// fsType is the filesystem type, or "" when there is none.
+	fsType string
+	// partitionTable is the partition table type, or "" when there is none.
+	partitionTable string
+}
+
+func (s deviceSignature) blank() bool {
+	return s.fsType == "" && s.partitionTable == ""
+}
+
+// probeDeviceSignature runs a low-level blkid probe of device. blkid exits 2
+// when it finds no signature at all.
+var probeDeviceSignature = func(ctx context.Context, device string) (deviceSignature, error) {
+	out, err := newProcessGroupCommand(ctx, "blkid", "-p", "-o", "export", device).Output()
+	if ctx.Err() != nil {
+		return deviceSignature{}, ctx.Err()
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 2 {
+		return deviceSignature{}, nil
+	}
+	if err != nil {
+		return deviceSignature{}, fmt.Errorf("blkid %s: %w", device, err)
+	}
+	var sig deviceSignature
+	scanner := bufio.NewScanner(strings.NewReader(string(out)))
+	for scanner.Scan() {
+		key, value, _ := strings.Cut(scanner.Text(), "=")
+		switch key {
+		case "TYPE":
+			sig.fsType = value
+		case "PTTYPE":
+			sig.partitionTable = value
+		}
+	}
+	return sig, nil
+}
+
+// runMkfs creates an fsType filesystem on device with mkfs.<fsType>.
+var runMkfs = func(ctx context.Context, fsType, device string, args []string) (string, error) {
+	cmd := newProcessGroupCommand(ctx, "mkfs."+fsType, append(append([]string(nil), args...), device)...)
+	out, err := cmd.CombinedOutput()
+	if ctx.Err() != nil {
+		return strings.TrimSpace(string(out)), ctx.Err()
+	}
+	return strings.TrimSpace(string(out)), err
+}
+
+// formatIfBlank creates rec.FsType on device when the volume opted in and the
+// device carries no signature at all. A device that already holds rec.FsType
+// is left alone, and one holding anything else is refused.
+func (n *Node) formatIfBlank(ctx context.Context, rec volumeRecord, device string) error {
+	opts, err := parseFormatOptions(rec.VolumeContext)
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+	if !opts.enabled {
+		return nil
+	}
+
+	probeCtx, cancel := context.WithTimeout(ctx, blkidTimeout)
+	sig, err := probeDeviceSignature(probeCtx, device)
+	cancel()
+	if err != nil {
+		Logger(ctx).Error("failed to probe device signature", zap.String("device", device), zap.Error(err))
+		switch {
+		case errors.Is(err, context.DeadlineExceeded):
+			return status.Errorf(codes.DeadlineExceeded, "blkid %s did not finish within %s", device, blkidTimeout)
+		case errors.Is(err, context.Canceled):
+			return status.Errorf(codes.Canceled, "blkid %s was canceled", device)
+		}
+		return status.Errorf(codes.Internal, "failed to probe %s for a filesystem: %v", device, err)
+	}
+	switch {
+	case sig.fsType == rec.FsType:
+		return nil
+	case sig.fsType != "":
+		Logger(ctx).Error("refusing to format device holding another filesystem",
+			zap.String("device", device),
+			zap.String("found_fs_type", sig.fsType),
+			zap.String("fs_type", rec.FsType),
+		)
+		return status.Errorf(codes.FailedPrecondition, "%s holds a %s filesystem, not %s", device, sig.fsType, rec.FsType)
+	case !sig.blank():
+		Logger(ctx).Error("refusing to format partitioned device",
+			zap.String("device", device),
+			zap.String("partition_table", sig.partitionTable),
+		)
+		return status.Errorf(codes.FailedPrecondition, "%s holds a %s partition table", device, sig.partitionTable)
+	}
+	if rec.MountFlags&syscall.MS_RDONLY != 0 {
+		return status.Errorf(codes.FailedPrecondition, "%s is blank and cannot be formatted for a read-only volume", device)
+	}
+
+	Logger(ctx).Info("formatting blank device",
+		zap.String("device", device),
+		zap.String("fs_type", rec.FsType),
+		zap.Strings("mkfs_options", opts.args),
+	)
+	mkfsCtx, cancel := context.WithTimeout(ctx, mkfsTimeout)
+	defer cancel()
+	out, err := runMkfs(mkfsCtx, rec.FsType, device, opts.args)
+	if err != nil {
+		Logger(ctx).Error("mkfs failed",
+			zap.String("device", device),
+			zap.String("fs_type", rec.FsType),
+			zap.String("output", out),
+			zap.Error(err),
+		)
+		switch {
+		case errors.Is(err, context.DeadlineExceeded):
+			return status.Errorf(codes.DeadlineExceeded, "mkfs.%s %s did not finish within %s", rec.FsType, device, mkfsTimeout)
+		case errors.Is(err, context.Canceled):
+			return status.Errorf(codes.Canceled, "mkfs.%s %s was canceled", rec.FsType, device)
+		case errors.Is(err, exec.ErrNotFound):
+			return status.Errorf(codes.FailedPrecondition, "mkfs.%s is not installed in the node image: %v", rec.FsType, err)
+		}
+		return status.Errorf(codes.Internal, "failed to format %s as %s: %v: %s", device, rec.FsType, err, out)
+	}
+	Logger(ctx).Info("formatted blank device", zap.String("device", device), zap.String("output", out))
+	return nil
+}
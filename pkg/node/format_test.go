@@ -0,0 +1,180 @@
+package node
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestNodeStageVolumeFormatIfBlank(t *testing.T) {
+	const device = "/dev/test-disk"
+
+	tests := []struct {
+		name       string
+		source     string
+		context    map[string]string
+		signature  deviceSignature
+		probeErr   error
+		mkfsErr    error
+		wantCode   codes.Code
+		wantProbe  bool
+		wantFormat []string
+		imageFile  bool
+	}{
+		{
+			name:       "blank device is formatted",
+			context:    map[string]string{"formatIfBlank": "true", "mkfsOptions": "-L data -m 0"},
+			wantProbe:  true,
+			wantFormat: []string{"-L", "data", "-m", "0"},
+		},
+		{
+			name:      "matching filesystem is kept",
+			context:   map[string]string{"formatIfBlank": "true"},
+			signature: deviceSignature{fsType: "ext4"},
+			wantProbe: true,
+		},
+		{
+			name:      "different filesystem is refused",
+			context:   map[string]string{"formatIfBlank": "true"},
+			signature: deviceSignature{fsType: "xfs"},
+			wantCode:  codes.FailedPrecondition,
+			wantProbe: true,
+		},
+		{
+			name:      "partitioned device is refused",
+			context:   map[string]string{"formatIfBlank": "true"},
+			signature: deviceSignature{partitionTable: "gpt"},
+			wantCode:  codes.FailedPrecondition,
+			wantProbe: true,
+		},
+		{
+			name:      "read-only blank device is refused",
+			context:   map[string]string{"formatIfBlank": "true", "mountOptions": "ro"},
+			wantCode:  codes.FailedPrecondition,
+			wantProbe: true,
+		},
+		{
+			name:      "hung blkid times out",
+			context:   map[string]string{"formatIfBlank": "true"},
+			probeErr:  context.DeadlineExceeded,
+			wantCode:  codes.DeadlineExceeded,
+			wantProbe: true,
+		},
+		{
+			name:       "hung mkfs times out",
+			context:    map[string]string{"formatIfBlank": "true"},
+			mkfsErr:    context.DeadlineExceeded,
+			wantCode:   codes.DeadlineExceeded,
+			wantProbe:  true,
+			wantFormat: []string{},
+		},
+		{
+			name:    "not opted in",
+			context: map[string]string{},
+		},
+		{
+			name:     "mkfsOptions without formatIfBlank",
+			context:  map[string]string{"mkfsOptions": "-L data"},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name:     "invalid formatIfBlank",
+			context:  map[string]string{"formatIfBlank": "sometimes"},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name:      "image file is refused",
+			context:   map[string]string{"formatIfBlank": "true"},
+			imageFile: true,
+			wantCode:  codes.InvalidArgument,
+		},
+		{
+			name:     "remote source",
+			source:   "gluster:media",
+			context:  map[string]string{"formatIfBlank": "true"},
+			wantCode: codes.InvalidArgument,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stubBlockDevices(t, device)
+			probed := false
+			origProbe := probeDeviceSignature
+			probeDeviceSignature = func(ctx context.Context, path string) (deviceSignature, error) {
+				if path != device {
+					t.Errorf("probed %q, want %q", path, device)
+				}
+				if _, ok := ctx.Deadline(); !ok {
+					t.Error("blkid ran without a deadline")
+				}
+				probed = true
+				return tt.signature, tt.probeErr
+			}
+			var formatted []string
+			origMkfs := runMkfs
+			runMkfs = func(ctx context.Context, fsType, path string, args []string) (string, error) {
+				if fsType != "ext4" || path != device {
+					t.Errorf("mkfs fsType = %q device = %q, want ext4 %s", fsType, path, device)
+				}
+				if _, ok := ctx.Deadline(); !ok {
+					t.Error("mkfs ran without a deadline")
+				}
+				formatted = append([]string{}, args...)
+				return "", tt.mkfsErr
+			}
+			t.Cleanup(func() {
+				probeDeviceSignature = origProbe
+				runMkfs = origMkfs
+			})
+
+			source := tt.source
+			if source == "" {
+				source = device
+			}
+			if tt.imageFile {
+				source = filepath.Join(t.TempDir(), "data.db")
+				if err := os.WriteFile(source, make([]byte, 4096), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			volumeContext := map[string]string{"source": source}
+			for k, v := range tt.context {
+				volumeContext[k] = v
+			}
+			mounter := &recordingMounter{mounted: map[string]bool{}}
+			n := NewNodeWithMounter("node-id", "/tmp/test-csi.sock", mounter)
+			_, err := n.NodeStageVolume(context.Background(), &csi.NodeStageVolumeRequest{
+				VolumeId:          "vol-1",
+				StagingTargetPath: filepath.Join(t.TempDir(), "stage"),
+				VolumeCapability: &csi.VolumeCapability{
+					AccessType: &csi.VolumeCapability_Mount{
+						Mount: &csi.VolumeCapability_MountVolume{FsType: "ext4"},
+					},
+				},
+				VolumeContext: volumeContext,
+			})
+			if status.Code(err) != tt.wantCode {
+				t.Fatalf("NodeStageVolume() error = %v, want code %v", err, tt.wantCode)
+			}
+			if probed != tt.wantProbe {
+				t.Fatalf("device probed = %v, want %v", probed, tt.wantProbe)
+			}
+			if (formatted != nil) != (tt.wantFormat != nil) || len(formatted) != len(tt.wantFormat) {
+				t.Fatalf("mkfs args = %v, want %v", formatted, tt.wantFormat)
+			}
+			for i := range tt.wantFormat {
+				if formatted[i] != tt.wantFormat[i] {
+					t.Fatalf("mkfs args = %v, want %v", formatted, tt.wantFormat)
+				}
+			}
+			if tt.wantCode != codes.OK && len(mounter.mounts) != 0 {
+				t.Fatalf("NodeStageVolume() mounted %v after refusing to format", mounter.mounts)
+			}
+		})
+	}
+}
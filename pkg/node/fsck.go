@@ -0,0 +1,172 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// defaultFsckTimeout bounds a pre-mount filesystem check when WithFsckTimeout
+// is not given.
+const defaultFsckTimeout = 10 * time.Minute
+
+// fsck(8) exit status bits.
+const (
+	fsckCorrected        = 1
+	fsckCorrectedReboot  = 2
+	fsckUncorrected      = 4
+	fsckOperationalError = 8
+)
+
+// xfsRepairCorrupt is the xfs_repair -n exit status for a filesystem with
+// problems.
+const xfsRepairCorrupt = 1
+
+// xfsDirtyLogAlert is part of the alert xfs_repair -n prints when it ignores a
+// dirty log. It then exits with xfsRepairCorrupt, as the unreplayed log makes
+// the metadata look inconsistent.
+const xfsDirtyLogAlert = "metadata changes in a log which is being ignored"
+
+// parseFsckBeforeMount validates the fsckBeforeMount attribute for fsType.
+func parseFsckBeforeMount(volumeContext map[string]string, fsType string) (bool, error) {
+	v, ok := volumeContext["fsckBeforeMount"]
+	if !ok {
+		return false, nil
+	}
+	enabled, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("invalid fsckBeforeMount %q: %w", v, err)
+	}
+	if enabled {
+		if _, _, ok := fsckCommand(fsType, "", false); !ok {
+			return false, fmt.Errorf("fsckBeforeMount supports ext2, ext3, ext4 and xfs, not %q", fsType)
+		}
+	}
+	return enabled, nil
+}
+
+// fsckCommand returns the non-interactive check for fsType. ext filesystems
+// are preened, fixing only what is safe without a human, or only checked when
+// the volume is read-only. xfs is only checked: its log is replayed by the
+// mount itself and repairs need xfs_repair run by hand.
+func fsckCommand(fsType, device string, readonly bool) (string, []string, bool) {
+	switch fsType {
+	case "ext2", "ext3", "ext4":
+		mode := "-p"
+		if readonly {
+			mode = "-n"
+		}
+		return "fsck." + fsType, []string{mode, device}, true
+	case "xfs":
+		return "xfs_repair", []string{"-n", device}, true
+	}
+	return "", nil, false
+}
+
+// runFsck runs a filesystem check and returns its output and exit status. A
+// non-nil error means the check did not run to completion.
+var runFsck = func(ctx context.Context, name string, args []string) (string, int, error) {
+	cmd := newProcessGroupCommand(ctx, name, args...)
+	out, err := cmd.CombinedOutput()
+	output := strings.TrimSpace(string(out))
+	if ctx.Err() != nil {
+		return output, -1, ctx.Err()
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return output, exitErr.ExitCode(), nil
+	}
+	return output, 0, err
+}
+
+// checkFilesystem runs the pre-mount check for rec on device when the volume
+// opted in. It proceeds for a clean or repaired filesystem, reporting repairs
+// as an event, and fails staging when the filesystem needs manual repair.
+func (n *Node) checkFilesystem(ctx context.Context, rec volumeRecord, device string) error {
+	enabled, err := parseFsckBeforeMount(rec.VolumeContext, rec.FsType)
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+	if !enabled {
+		return nil
+	}
+	name, args, _ := fsckCommand(rec.FsType, device, rec.MountFlags&syscall.MS_RDONLY != 0)
+
+	timeout := n.fsckTimeout
+	if timeout <= 0 {
+		timeout = defaultFsckTimeout
+	}
+	checkCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	Logger(ctx).Info("checking filesystem before mount", zap.String("command", name), zap.Strings("args", args))
+	start := time.Now()
+	out, code, err := runFsck(checkCtx, name, args)
+	fields := []zap.Field{
+		zap.String("device", device),
+		zap.String("command", name),
+		zap.Int("exit_code", code),
+		zap.Duration("elapsed", time.Since(start)),
+		zap.String("output", out),
+	}
+	if err != nil {
+		Logger(ctx).Error("filesystem check did not complete", append(fields, zap.Error(err))...)
+		switch {
+		case errors.Is(err, context.DeadlineExceeded):
+			return status.Errorf(codes.DeadlineExceeded, "%s %s did not finish within %s", name, device, timeout)
+		case errors.Is(err, context.Canceled):
+			return status.Errorf(codes.Canceled, "%s %s was canceled", name, device)
+		case errors.Is(err, exec.ErrNotFound):
+			return status.Errorf(codes.FailedPrecondition, "%s is not installed in the node image: %v", name, err)
+		}
+		return status.Errorf(codes.Internal, "failed to run %s on %s: %v", name, device, err)
+	}
+
+	if name == "xfs_repair" {
+		switch code {
+		case 0:
+			Logger(ctx).Info("filesystem is clean", fields...)
+			return nil
+		case xfsRepairCorrupt:
+			// After an unclean shutdown the log still holds the last
+			// transactions. Only the mount replays it, and a fresh
+			// xfs_repair -n could not tell real corruption from its absence.
+			if strings.Contains(out, xfsDirtyLogAlert) {
+				Logger(ctx).Info("filesystem log needs replay; mounting to replay it", fields...)
+				return nil
+			}
+			Logger(ctx).Error("filesystem needs manual repair", fields...)
+			return status.Errorf(codes.FailedPrecondition,
+				"xfs filesystem on %s is corrupt and needs manual repair with xfs_repair: %s", device, out)
+		}
+		Logger(ctx).Error("filesystem check failed", fields...)
+		return status.Errorf(codes.Internal, "xfs_repair -n on %s exited with status %d: %s", device, code, out)
+	}
+
+	switch {
+	case code == 0:
+		Logger(ctx).Info("filesystem is clean", fields...)
+		return nil
+	case code&^(fsckCorrected|fsckCorrectedReboot) == 0:
+		Logger(ctx).Warn("filesystem errors were corrected", fields...)
+		n.reportVolumeEvent(ctx, rec.VolumeID, corev1.EventTypeWarning, "JustmountFilesystemRepaired",
+			fmt.Sprintf("%s corrected errors on %s before mounting on node %s", name, device, n.nodeID))
+		return nil
+	case code&fsckUncorrected != 0 && code&fsckOperationalError == 0:
+		Logger(ctx).Error("filesystem needs manual repair", fields...)
+		return status.Errorf(codes.FailedPrecondition,
+			"%s filesystem on %s has errors that need manual repair with fsck: %s", rec.FsType, device, out)
+	}
+	Logger(ctx).Error("filesystem check failed", fields...)
+	return status.Errorf(codes.Internal, "%s on %s exited with status %d: %s", name, device, code, out)
+}
@@ -0,0 +1,141 @@
+package node
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestNodeStageVolumeFsckBeforeMount(t *testing.T) {
+	const device = "/dev/test-disk"
+
+	tests := []struct {
+		name      string
+		fsType    string
+		context   map[string]string
+		exitCode  int
+		output    string
+		runErr    error
+		wantCode  codes.Code
+		wantCmd   []string
+		wantEvent bool
+	}{
+		{
+			name:    "clean ext4",
+			fsType:  "ext4",
+			wantCmd: []string{"fsck.ext4", "-p", device},
+		},
+		{
+			name:      "repaired ext4 is reported",
+			fsType:    "ext4",
+			exitCode:  fsckCorrected,
+			wantCmd:   []string{"fsck.ext4", "-p", device},
+			wantEvent: true,
+		},
+		{
+			name:     "uncorrected ext4 needs manual repair",
+			fsType:   "ext4",
+			exitCode: fsckUncorrected,
+			wantCode: codes.FailedPrecondition,
+			wantCmd:  []string{"fsck.ext4", "-p", device},
+		},
+		{
+			name:     "fsck operational error",
+			fsType:   "ext4",
+			exitCode: fsckOperationalError,
+			wantCode: codes.Internal,
+			wantCmd:  []string{"fsck.ext4", "-p", device},
+		},
+		{
+			name:     "read-only ext4 is only checked",
+			fsType:   "ext4",
+			context:  map[string]string{"mountOptions": "ro"},
+			wantCmd:  []string{"fsck.ext4", "-n", device},
+			exitCode: 0,
+		},
+		{
+			name:    "clean xfs",
+			fsType:  "xfs",
+			wantCmd: []string{"xfs_repair", "-n", device},
+		},
+		{
+			name:     "xfs dirty log is replayed by mount",
+			fsType:   "xfs",
+			exitCode: xfsRepairCorrupt,
+			output: "ALERT: The filesystem has valuable metadata changes in a log which is being ignored because the -n option was used.\n" +
+				"Expect spurious inconsistencies which may be resolved by first mounting the filesystem to replay the log.",
+			wantCmd: []string{"xfs_repair", "-n", device},
+		},
+		{
+			name:     "corrupt xfs needs manual repair",
+			fsType:   "xfs",
+			exitCode: xfsRepairCorrupt,
+			wantCode: codes.FailedPrecondition,
+			wantCmd:  []string{"xfs_repair", "-n", device},
+		},
+		{
+			name:     "check times out",
+			fsType:   "ext4",
+			runErr:   context.DeadlineExceeded,
+			wantCode: codes.DeadlineExceeded,
+			wantCmd:  []string{"fsck.ext4", "-p", device},
+		},
+		{
+			name:     "unsupported fsType",
+			fsType:   "btrfs",
+			wantCode: codes.InvalidArgument,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stubBlockDevices(t, device)
+			var gotCmd []string
+			origRunFsck := runFsck
+			runFsck = func(_ context.Context, name string, args []string) (string, int, error) {
+				gotCmd = append([]string{name}, args...)
+				return tt.output, tt.exitCode, tt.runErr
+			}
+			t.Cleanup(func() { runFsck = origRunFsck })
+
+			volumeContext := map[string]string{"source": device, "fsckBeforeMount": "true"}
+			for k, v := range tt.context {
+				volumeContext[k] = v
+			}
+			mounter := &recordingMounter{mounted: map[string]bool{}}
+			reporter := &recordingPVCReporter{}
+			n := NewNodeWithMounter("node-id", "/tmp/test-csi.sock", mounter)
+			n.pvcReporter = reporter
+			_, err := n.NodeStageVolume(context.Background(), &csi.NodeStageVolumeRequest{
+				VolumeId:          "vol-1",
+				StagingTargetPath: filepath.Join(t.TempDir(), "stage"),
+				VolumeCapability: &csi.VolumeCapability{
+					AccessType: &csi.VolumeCapability_Mount{
+						Mount: &csi.VolumeCapability_MountVolume{FsType: tt.fsType},
+					},
+				},
+				VolumeContext: volumeContext,
+			})
+			if status.Code(err) != tt.wantCode {
+				t.Fatalf("NodeStageVolume() error = %v, want code %v", err, tt.wantCode)
+			}
+			if len(gotCmd) != len(tt.wantCmd) {
+				t.Fatalf("fsck command = %v, want %v", gotCmd, tt.wantCmd)
+			}
+			for i := range tt.wantCmd {
+				if gotCmd[i] != tt.wantCmd[i] {
+					t.Fatalf("fsck command = %v, want %v", gotCmd, tt.wantCmd)
+				}
+			}
+			if gotEvent := len(reporter.events) == 1 && reporter.events[0] == "JustmountFilesystemRepaired"; gotEvent != tt.wantEvent {
+				t.Fatalf("events = %v, want repaired event %v", reporter.events, tt.wantEvent)
+			}
+			if (tt.wantCode == codes.OK) != (len(mounter.mounts) == 1) {
+				t.Fatalf("mounts = %v with error code %v", mounter.mounts, tt.wantCode)
+			}
+		})
+	}
+}
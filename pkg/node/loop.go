@@ -0,0 +1,178 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"go.uber.org/zap"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	loopControlPath = "/dev/loop-control"
+	// loFlagsReadOnly is LO_FLAGS_READ_ONLY, which x/sys/unix does not export.
+	loFlagsReadOnly = 1
+	// loopAttachAttempts bounds retries when another process claims the free
+	// loop device between LOOP_CTL_GET_FREE and LOOP_CONFIGURE.
+	loopAttachAttempts = 5
+)
+
+// loopFsTypes lists the kernel filesystems that read from a block device and
+// so get an image file through a loop device. FUSE filesystems and mount
+// helpers, such as squashfuse or fuse2fs, open the image themselves.
+var loopFsTypes = []string{
+	"ext2", "ext3", "ext4", "xfs", "btrfs", "f2fs", "jfs", "nilfs2",
+	"squashfs", "erofs", "cramfs", "romfs", "iso9660", "udf",
+	"vfat", "msdos", "exfat", "ntfs3", "hfsplus",
+}
+
+// isLoopImage reports whether source names a regular file to be attached to a
+// loop device rather than a device or remote source mounted directly. Only
+// sources of one of the loopFsTypes are.
+func isLoopImage(source, fsType string) (bool, error) {
+	if !filepath.IsAbs(source) || !matchFsType(loopFsTypes, fsType) {
+		return false, nil
+	}
+	info, err := os.Stat(source)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return info.Mode().IsRegular(), nil
+}
+
+// attachLoopDevice attaches image to a free loop device with a single
+// LOOP_CONFIGURE and returns the device path.
+var attachLoopDevice = func(image string, readonly bool) (string, error) {
+	flags := os.O_RDWR
+	if readonly {
+		flags = os.O_RDONLY
+	}
+	img, err := os.OpenFile(image, flags|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return "", err
+	}
+	defer img.Close()
+	ctl, err := os.OpenFile(loopControlPath, os.O_RDWR|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return "", err
+	}
+	defer ctl.Close()
+
+	config := unix.LoopConfig{Fd: uint32(img.Fd())}
+	if readonly {
+		config.Info.Flags |= loFlagsReadOnly
+	}
+	copy(config.Info.File_name[:len(config.Info.File_name)-1], image)
+
+	for attempt := 0; ; attempt++ {
+		index, err := unix.IoctlRetInt(int(ctl.Fd()), unix.LOOP_CTL_GET_FREE)
+		if err != nil {
+			return "", fmt.Errorf("find free loop device: %w", err)
+		}
+		device := fmt.Sprintf("/dev/loop%d", index)
+		dev, err := os.OpenFile(device, flags|syscall.O_CLOEXEC, 0)
+		if err != nil {
+			return "", err
+		}
+		err = unix.IoctlLoopConfigure(int(dev.Fd()), &config)
+		_ = dev.Close()
+		if err == nil {
+			return device, nil
+		}
+		if !errors.Is(err, unix.EBUSY) || attempt+1 >= loopAttachAttempts {
+			return "", fmt.Errorf("configure %s: %w", device, err)
+		}
+	}
+}
+
+// loopDeviceBackedBy reports whether device is still attached to image. Loop
+// device numbers are reused, so a recorded device is checked before it is
+// mounted or detached.
+var loopDeviceBackedBy = func(device, image string) (bool, error) {
+	var st syscall.Stat_t
+	if err := syscall.Stat(image, &st); err != nil {
+		return false, err
+	}
+	dev, err := os.OpenFile(device, os.O_RDONLY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	defer dev.Close()
+	info, err := unix.IoctlLoopGetStatus64(int(dev.Fd()))
+	if errors.Is(err, unix.ENXIO) {
+		// Not attached to anything.
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return info.Device == uint64(st.Dev) && info.Inode == st.Ino, nil
+}
+
+// detachLoopDevice releases device with LOOP_CLR_FD.
+var detachLoopDevice = func(device string) error {
+	dev, err := os.OpenFile(device, os.O_RDONLY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return err
+	}
+	defer dev.Close()
+	if err := unix.IoctlSetInt(int(dev.Fd()), unix.LOOP_CLR_FD, 0); err != nil && !errors.Is(err, unix.ENXIO) {
+		return err
+	}
+	return nil
+}
+
+// ensureLoopDevice makes rec.LoopDevice a loop device attached to the image at
+// rec.Source, reusing the recorded device when it still is one.
+func ensureLoopDevice(ctx context.Context, rec *volumeRecord) error {
+	if rec.LoopDevice != "" {
+		attached, err := loopDeviceBackedBy(rec.LoopDevice, rec.Source)
+		if err != nil {
+			return fmt.Errorf("check loop device %s: %w", rec.LoopDevice, err)
+		}
+		if attached {
+			return nil
+		}
+	}
+	readonly := rec.MountFlags&syscall.MS_RDONLY != 0
+	device, err := attachLoopDevice(rec.Source, readonly)
+	if err != nil {
+		return fmt.Errorf("attach loop device for %s: %w", rec.Source, err)
+	}
+	Logger(ctx).Info("attached loop device",
+		zap.String("image", rec.Source),
+		zap.String("loop_device", device),
+		zap.Bool("readonly", readonly),
+	)
+	rec.LoopDevice = device
+	return nil
+}
+
+// releaseLoopDevice detaches the loop device recorded for rec, if it is still
+// attached to rec's image.
+func releaseLoopDevice(ctx context.Context, rec *volumeRecord) error {
+	if rec.LoopDevice == "" {
+		return nil
+	}
+	attached, err := loopDeviceBackedBy(rec.LoopDevice, rec.Source)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("check loop device %s: %w", rec.LoopDevice, err)
+	}
+	if attached {
+		if err := detachLoopDevice(rec.LoopDevice); err != nil {
+			return fmt.Errorf("detach loop device %s: %w", rec.LoopDevice, err)
+		}
+		Logger(ctx).Info("detached loop device", zap.String("loop_device", rec.LoopDevice))
+	}
+	rec.LoopDevice = ""
+	return nil
+}
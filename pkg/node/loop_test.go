@@ -0,0 +1,134 @@
+package node
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+)
+
+// stubLoopDevices replaces the loop device seams with an in-memory table of
+// device to image and returns it with the list of detached devices.
+func stubLoopDevices(t *testing.T) (map[string]string, *[]string, *bool) {
+	t.Helper()
+	attached := map[string]string{}
+	var detached []string
+	var readonly bool
+	origAttach, origBacked, origDetach := attachLoopDevice, loopDeviceBackedBy, detachLoopDevice
+	attachLoopDevice = func(image string, ro bool) (string, error) {
+		readonly = ro
+		attached["/dev/loop7"] = image
+		return "/dev/loop7", nil
+	}
+	loopDeviceBackedBy = func(device, image string) (bool, error) {
+		return attached[device] == image, nil
+	}
+	detachLoopDevice = func(device string) error {
+		delete(attached, device)
+		detached = append(detached, device)
+		return nil
+	}
+	t.Cleanup(func() {
+		attachLoopDevice, loopDeviceBackedBy, detachLoopDevice = origAttach, origBacked, origDetach
+	})
+	return attached, &detached, &readonly
+}
+
+func loopStageRequest(t *testing.T, mode csi.VolumeCapability_AccessMode_Mode) *csi.NodeStageVolumeRequest {
+	t.Helper()
+	image := filepath.Join(t.TempDir(), "disk.img")
+	if err := os.WriteFile(image, nil, 0600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	req := stageRequest(filepath.Join(t.TempDir(), "stage"), "ext4", map[string]string{"source": image})
+	req.VolumeCapability.AccessMode = &csi.VolumeCapability_AccessMode{Mode: mode}
+	return req
+}
+
+func TestNodeStageVolumeLoopImage(t *testing.T) {
+	attached, detached, readonly := stubLoopDevices(t)
+	mounter := &recordingMounter{mounted: map[string]bool{}}
+	n := NewNodeWithMounter("node-id", "/tmp/test-csi.sock", mounter)
+	req := loopStageRequest(t, csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER)
+
+	if _, err := n.NodeStageVolume(context.Background(), req); err != nil {
+		t.Fatalf("NodeStageVolume() error = %v", err)
+	}
+	if len(mounter.sources) != 1 || mounter.sources[0] != "/dev/loop7" {
+		t.Fatalf("mount sources = %v, want [/dev/loop7]", mounter.sources)
+	}
+	if *readonly || mounter.flags[0]&syscall.MS_RDONLY != 0 {
+		t.Fatalf("writable volume attached readonly = %v, mount flags = %#x", *readonly, mounter.flags[0])
+	}
+	rec, _ := n.state.get("test-volume")
+	if !rec.Loop || rec.LoopDevice != "/dev/loop7" || rec.Source != req.GetVolumeContext()["source"] {
+		t.Fatalf("stage record loop = %v device = %q source = %q", rec.Loop, rec.LoopDevice, rec.Source)
+	}
+
+	if _, err := n.NodeUnstageVolume(context.Background(), &csi.NodeUnstageVolumeRequest{
+		VolumeId:          "test-volume",
+		StagingTargetPath: req.GetStagingTargetPath(),
+	}); err != nil {
+		t.Fatalf("NodeUnstageVolume() error = %v", err)
+	}
+	if len(*detached) != 1 || len(attached) != 0 {
+		t.Fatalf("detached = %v, still attached = %v, want /dev/loop7 detached", *detached, attached)
+	}
+}
+
+func TestNodeStageVolumeLoopImageReaderOnly(t *testing.T) {
+	_, _, readonly := stubLoopDevices(t)
+	mounter := &recordingMounter{mounted: map[string]bool{}}
+	n := NewNodeWithMounter("node-id", "/tmp/test-csi.sock", mounter)
+	req := loopStageRequest(t, csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY)
+
+	if _, err := n.NodeStageVolume(context.Background(), req); err != nil {
+		t.Fatalf("NodeStageVolume() error = %v", err)
+	}
+	if !*readonly {
+		t.Fatalf("reader-only volume attached writable")
+	}
+	if mounter.flags[0]&syscall.MS_RDONLY == 0 {
+		t.Fatalf("mount flags = %#x, want MS_RDONLY", mounter.flags[0])
+	}
+}
+
+func TestNodeStageVolumeImageForFuseFilesystem(t *testing.T) {
+	attached, _, _ := stubLoopDevices(t)
+	mounter := &recordingMounter{mounted: map[string]bool{}}
+	n := NewNodeWithMounter("node-id", "/tmp/test-csi.sock", mounter)
+	req := loopStageRequest(t, csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER)
+	req.GetVolumeCapability().GetMount().FsType = "fuse.squashfuse"
+
+	if _, err := n.NodeStageVolume(context.Background(), req); err != nil {
+		t.Fatalf("NodeStageVolume() error = %v", err)
+	}
+	if len(attached) != 0 {
+		t.Fatalf("NodeStageVolume() attached %v for a FUSE filesystem", attached)
+	}
+	if want := req.GetVolumeContext()["source"]; len(mounter.sources) != 1 || mounter.sources[0] != want {
+		t.Fatalf("mount sources = %v, want the image %s", mounter.sources, want)
+	}
+	if rec, _ := n.state.get("test-volume"); rec.Loop {
+		t.Fatalf("stage record loop = true for a FUSE filesystem")
+	}
+}
+
+func TestReleaseLoopDeviceSkipsReusedDevice(t *testing.T) {
+	attached, detached, _ := stubLoopDevices(t)
+	attached["/dev/loop7"] = "/images/other.img"
+	rec := volumeRecord{Source: "/images/disk.img", Loop: true, LoopDevice: "/dev/loop7"}
+
+	if err := releaseLoopDevice(context.Background(), &rec); err != nil {
+		t.Fatalf("releaseLoopDevice() error = %v", err)
+	}
+	if len(*detached) != 0 {
+		t.Fatalf("releaseLoopDevice() detached %v, which now backs another image", *detached)
+	}
+	if rec.LoopDevice != "" {
+		t.Fatalf("releaseLoopDevice() left LoopDevice = %q", rec.LoopDevice)
+	}
+}
@@ -0,0 +1,132 @@
+package node
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// mountGroupOptions maps filesystems that can assign a group at mount time to
+// the option that does it. Other filesystems get a chgrp and setgid pass over
+// the mounted tree instead.
+var mountGroupOptions = map[string]string{
+	"cifs":       "gid",
+	"smb3":       "gid",
+	"vfat":       "gid",
+	"msdos":      "gid",
+	"exfat":      "gid",
+	"ntfs":       "gid",
+	"ntfs3":      "gid",
+	"iso9660":    "gid",
+	"udf":        "gid",
+	"hfsplus":    "gid",
+	"fuse.sshfs": "gid",
+	"sshfs":      "gid",
+}
+
+// mountGroupRootOnlyFsTypes lists shared network filesystems, using the
+// patterns of UnmountConfig. Their tree belongs to every client of the export
+// and can be arbitrarily large, so only the staging root is given the group.
+var mountGroupRootOnlyFsTypes = []string{"nfs", "nfs4", "ceph", "glusterfs", "lustre", "9p", "fuse", "fuse.*"}
+
+// chgrpPath changes the group of path without following a final symlink.
+var chgrpPath = func(path string, gid int) error {
+	return os.Lchown(path, -1, gid)
+}
+
+// parseMountGroup validates the VolumeMountGroup kubelet sends for fsGroup.
+func parseMountGroup(group string) (int, error) {
+	gid, err := strconv.Atoi(group)
+	if err != nil || gid < 0 {
+		return 0, fmt.Errorf("invalid volume_mount_group %q: must be a numeric group ID", group)
+	}
+	return gid, nil
+}
+
+// mountGroupConflict refuses a request for a volume that is already staged
+// with another volume mount group. Kubelet stages a volume once per node, so a
+// second pod with a different fsGroup would otherwise get the first pod's.
+func mountGroupConflict(ctx context.Context, method, staged, requested string) error {
+	if staged == requested {
+		return nil
+	}
+	Logger(ctx).Error(method+" already staged with a different volume mount group",
+		zap.String("staged_group", staged),
+		zap.String("requested_group", requested),
+	)
+	return status.Errorf(codes.AlreadyExists, "volume is already staged with volume_mount_group %q", staged)
+}
+
+// mountGroupOption returns the mount option that applies group to fsType, or
+// "" when fsType needs the chgrp pass.
+func mountGroupOption(fsType, group string) string {
+	key, ok := mountGroupOptions[fsType]
+	if !ok || group == "" {
+		return ""
+	}
+	return key + "=" + group
+}
+
+// applyMountGroup gives the staged tree to rec.MountGroup when the filesystem
+// could not do so at mount time: every entry is chgrp'd and directories get
+// the setgid bit so new files inherit the group. Symlinks are not followed and
+// other mounts below the staging path are left alone. On shared network
+// filesystems only the staging root is changed.
+func (n *Node) applyMountGroup(ctx context.Context, rec volumeRecord) error {
+	if rec.MountGroup == "" || mountGroupOption(rec.FsType, rec.MountGroup) != "" {
+		return nil
+	}
+	if rec.MountFlags&syscall.MS_RDONLY != 0 {
+		Logger(ctx).Info("skipping volume mount group on read-only staging mount")
+		return nil
+	}
+	gid, err := parseMountGroup(rec.MountGroup)
+	if err != nil {
+		return err
+	}
+
+	root := rec.StagingTargetPath
+	var rootStat syscall.Stat_t
+	if err := syscall.Stat(root, &rootStat); err != nil {
+		return err
+	}
+	rootOnly := matchFsType(mountGroupRootOnlyFsTypes, rec.FsType)
+	Logger(ctx).Info("applying volume mount group", zap.Int("gid", gid), zap.Bool("root_only", rootOnly))
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		var st syscall.Stat_t
+		if err := syscall.Lstat(path, &st); err != nil {
+			return err
+		}
+		if st.Dev != rootStat.Dev {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if int(st.Gid) != gid {
+			if err := chgrpPath(path, gid); err != nil {
+				return err
+			}
+		}
+		if d.IsDir() && st.Mode&syscall.S_ISGID == 0 {
+			if err := syscall.Chmod(path, st.Mode&0o7777|syscall.S_ISGID); err != nil {
+				return err
+			}
+		}
+		if rootOnly && path == root {
+			return fs.SkipDir
+		}
+		return nil
+	})
+}
@@ -0,0 +1,190 @@
+package node
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestMountGroupOption(t *testing.T) {
+	tests := []struct {
+		fsType string
+		want   string
+	}{
+		{fsType: "cifs", want: "gid=2000"},
+		{fsType: "fuse.sshfs", want: "gid=2000"},
+		{fsType: "fuse", want: ""},
+		{fsType: "tmpfs", want: ""},
+		{fsType: "glusterfs", want: ""},
+		{fsType: "nfs", want: ""},
+	}
+	for _, tc := range tests {
+		if got := mountGroupOption(tc.fsType, "2000"); got != tc.want {
+			t.Errorf("mountGroupOption(%q) = %q, want %q", tc.fsType, got, tc.want)
+		}
+	}
+}
+
+func TestApplyMountGroup(t *testing.T) {
+	gid := os.Getegid()
+	if os.Geteuid() == 0 {
+		gid = 4242
+	}
+	tests := []struct {
+		fsType   string
+		rootOnly bool
+	}{
+		{fsType: "tmpfs"},
+		{fsType: "nfs4", rootOnly: true},
+		{fsType: "fuse.s3fs", rootOnly: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.fsType, func(t *testing.T) {
+			root := t.TempDir()
+			nested := filepath.Join(root, "a", "b")
+			if err := os.MkdirAll(nested, 0755); err != nil {
+				t.Fatalf("create dirs: %v", err)
+			}
+			file := filepath.Join(nested, "file")
+			if err := os.WriteFile(file, []byte("x"), 0644); err != nil {
+				t.Fatalf("write file: %v", err)
+			}
+			var before syscall.Stat_t
+			if err := syscall.Stat(file, &before); err != nil {
+				t.Fatalf("stat %s: %v", file, err)
+			}
+
+			n := NewNodeWithMounter("node-id", "/tmp/test-csi.sock", &recordingMounter{mounted: map[string]bool{}})
+			rec := volumeRecord{StagingTargetPath: root, FsType: tc.fsType, MountGroup: strconv.Itoa(gid)}
+			if err := n.applyMountGroup(context.Background(), rec); err != nil {
+				t.Fatalf("applyMountGroup() error = %v", err)
+			}
+			for _, path := range []string{root, nested, file} {
+				var st syscall.Stat_t
+				if err := syscall.Stat(path, &st); err != nil {
+					t.Fatalf("stat %s: %v", path, err)
+				}
+				changed := path == root || !tc.rootOnly
+				if changed && int(st.Gid) != gid {
+					t.Errorf("%s gid = %d, want %d", path, st.Gid, gid)
+				}
+				if path == file && !changed && st.Gid != before.Gid {
+					t.Errorf("%s gid = %d, want it left at %d", path, st.Gid, before.Gid)
+				}
+				if isDir := path != file; changed && isDir != (st.Mode&syscall.S_ISGID != 0) {
+					t.Errorf("%s setgid = %v, want %v", path, st.Mode&syscall.S_ISGID != 0, isDir)
+				}
+			}
+		})
+	}
+}
+
+func TestNodeStageVolumeMountGroup(t *testing.T) {
+	stagingPath := t.TempDir()
+	mounter := &recordingMounter{mounted: map[string]bool{}}
+	n := NewNodeWithMounter("node-id", "/tmp/test-csi.sock", mounter)
+	newReq := func(group string) *csi.NodeStageVolumeRequest {
+		return &csi.NodeStageVolumeRequest{
+			VolumeId:          "test-volume",
+			StagingTargetPath: stagingPath,
+			VolumeCapability: &csi.VolumeCapability{
+				AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{
+					FsType:           "cifs",
+					VolumeMountGroup: group,
+				}},
+			},
+			VolumeContext: map[string]string{
+				"source":       "//server/share",
+				"fileMode":     "0755",
+				"mountOptions": "gid=1,vers=3.0",
+			},
+		}
+	}
+
+	if _, err := n.NodeStageVolume(context.Background(), newReq("2000")); err != nil {
+		t.Fatalf("NodeStageVolume() error = %v", err)
+	}
+	if len(mounter.data) != 1 || mounter.data[0] != "vers=3.0,gid=2000" {
+		t.Fatalf("NodeStageVolume() mount data = %q, want %q", mounter.data, "vers=3.0,gid=2000")
+	}
+	if _, err := n.NodeStageVolume(context.Background(), newReq("2000")); err != nil {
+		t.Fatalf("NodeStageVolume() with same group error = %v", err)
+	}
+	if _, err := n.NodeStageVolume(context.Background(), newReq("3000")); status.Code(err) != codes.AlreadyExists {
+		t.Fatalf("NodeStageVolume() with different group code = %v, want %v", status.Code(err), codes.AlreadyExists)
+	}
+	if _, err := n.NodeStageVolume(context.Background(), newReq("staff")); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("NodeStageVolume() with non-numeric group code = %v, want %v", status.Code(err), codes.InvalidArgument)
+	}
+}
+
+func TestNodePublishVolumeMountGroup(t *testing.T) {
+	stagingPath := t.TempDir()
+	mounter := &recordingMounter{mounted: map[string]bool{stagingPath: true}}
+	n := NewNodeWithMounter("node-id", "/tmp/test-csi.sock", mounter)
+	if err := n.state.putStage(volumeRecord{
+		VolumeID:          "test-volume",
+		StagingTargetPath: stagingPath,
+		Source:            "//server/share",
+		FsType:            "cifs",
+		MountGroup:        "2000",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	newReq := func(group string) *csi.NodePublishVolumeRequest {
+		return &csi.NodePublishVolumeRequest{
+			VolumeId:          "test-volume",
+			StagingTargetPath: stagingPath,
+			TargetPath:        filepath.Join(t.TempDir(), "target"),
+			VolumeCapability: &csi.VolumeCapability{
+				AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{
+					VolumeMountGroup: group,
+				}},
+			},
+		}
+	}
+
+	if _, err := n.NodePublishVolume(context.Background(), newReq("3000")); status.Code(err) != codes.AlreadyExists {
+		t.Fatalf("NodePublishVolume() with different group code = %v, want %v", status.Code(err), codes.AlreadyExists)
+	}
+	if len(mounter.mounts) != 0 {
+		t.Fatalf("NodePublishVolume() with different group mounted %v", mounter.mounts)
+	}
+	for _, group := range []string{"2000", ""} {
+		if _, err := n.NodePublishVolume(context.Background(), newReq(group)); err != nil {
+			t.Fatalf("NodePublishVolume() with group %q error = %v", group, err)
+		}
+	}
+}
+
+func TestNodeStageVolumeMountGroupRefused(t *testing.T) {
+	orig := chgrpPath
+	chgrpPath = func(path string, gid int) error {
+		return &os.PathError{Op: "lchown", Path: path, Err: syscall.EPERM}
+	}
+	t.Cleanup(func() { chgrpPath = orig })
+
+	stagingPath := filepath.Join(t.TempDir(), "stage")
+	mounter := &recordingMounter{mounted: map[string]bool{}}
+	n := NewNodeWithMounter("node-id", "/tmp/test-csi.sock", mounter)
+	req := stageRequest(stagingPath, "tmpfs", map[string]string{"source": "tmpfs"})
+	req.VolumeCapability.GetMount().VolumeMountGroup = "2000"
+
+	_, err := n.NodeStageVolume(context.Background(), req)
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("NodeStageVolume() error = %v, want code %v", err, codes.InvalidArgument)
+	}
+	if mounter.mounted[stagingPath] {
+		t.Fatalf("NodeStageVolume() left %s mounted after the group was refused", stagingPath)
+	}
+	if _, ok := n.state.get("test-volume"); ok {
+		t.Fatal("NodeStageVolume() recorded a volume whose group was refused")
+	}
+}
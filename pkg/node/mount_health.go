@@ -1,10 +1,29 @@
 package node
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"os"
 	"strings"
 	"syscall"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// repairModeContextKey selects how a disconnected staging mount is repaired.
+const repairModeContextKey = "repairMode"
+
+const (
+	// repairModeUnstage unmounts the staging mount and its bind mounts and
+	// leaves re-staging to kubelet. This is the default.
+	repairModeUnstage = "unstage"
+	// repairModeRemount re-mounts the staging path in place and re-binds its
+	// publish targets so pods keep running.
+	repairModeRemount = "remount"
 )
 
 var probeMountPath = func(path string) error {
@@ -27,3 +46,75 @@ func isDisconnectedMountError(err error) bool {
 		strings.Contains(message, "input/output error") ||
 		strings.Contains(message, "stale file handle")
 }
+
+// unstageDisconnectedMount unmounts a disconnected staging mount and every bind
+// mount that still references it, reporting progress against each publish request.
+func (n *Node) unstageDisconnectedMount(ctx context.Context, path string, reqs []*csi.NodePublishVolumeRequest) error {
+	for _, req := range reqs {
+		n.reportRepairStarted(ctx, req, "JustmountStagingMountDisconnected",
+			"Disconnected justmount staging mount detected; unmounting dependent bind mounts and staging target")
+	}
+	if err := n.unmountDependentMounts(ctx, path); err != nil {
+		return status.Errorf(codes.Internal, "failed to unmount dependent bind mounts: %v", err)
+	}
+	if err := n.unmountAllAtPath(ctx, path); err != nil {
+		return status.Errorf(codes.Internal, "failed to unmount disconnected staging target path: %v", err)
+	}
+	for _, req := range reqs {
+		n.reportRepairCompleted(ctx, req, "JustmountStagingMountUnstaged",
+			"Disconnected justmount staging mount and dependent bind mounts were unmounted successfully")
+	}
+	return nil
+}
+
+// repairModeFor returns the repair mode from the first volume context that sets one.
+func repairModeFor(volumeContexts ...map[string]string) (string, error) {
+	for _, volumeContext := range volumeContexts {
+		mode := strings.TrimSpace(volumeContext[repairModeContextKey])
+		switch mode {
+		case "":
+			continue
+		case repairModeUnstage, repairModeRemount:
+			return mode, nil
+		default:
+			return "", fmt.Errorf("invalid %s %q: must be %q or %q", repairModeContextKey, mode, repairModeUnstage, repairModeRemount)
+		}
+	}
+	return repairModeUnstage, nil
+}
+
+// repairDisconnectedStaging repairs a disconnected staging mount according to
+// the volume's repair mode. It reports whether the mount was re-established in
+// place; otherwise the staging mount has been unstaged.
+func (n *Node) repairDisconnectedStaging(
+	ctx context.Context,
+	path string,
+	volumeContext map[string]string,
+	reqs []*csi.NodePublishVolumeRequest,
+) (bool, error) {
+	rec, ok := n.state.getByStagingPath(path)
+	mode, err := repairModeFor(volumeContext, rec.VolumeContext)
+	if err != nil {
+		return false, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if mode == repairModeRemount {
+		if ok && rec.Source != "" && rec.FsType != "" {
+			for _, req := range reqs {
+				n.reportRepairStarted(ctx, req, "JustmountStagingMountDisconnected",
+					"Disconnected justmount staging mount detected; remounting staging target and re-binding publish targets in place")
+			}
+			if err := n.remountStaging(ctx, rec, true); err != nil {
+				return false, status.Errorf(codes.Internal, "failed to remount disconnected staging target path: %v", err)
+			}
+			for _, req := range reqs {
+				n.reportRepairCompleted(ctx, req, "JustmountStagingMountRemounted",
+					"Disconnected justmount staging mount was remounted and publish targets were re-bound in place")
+			}
+			return true, nil
+		}
+		Logger(ctx).Warn("no recorded stage parameters for in-place repair; unstaging instead",
+			zap.String("staging_target_path", path),
+		)
+	}
+	return false, n.unstageDisconnectedMount(ctx, path, reqs)
+}
@@ -1,6 +1,7 @@
 package node
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"os"
@@ -8,16 +9,12 @@ import (
 	"sort"
 	"strings"
 
+	"github.com/joejulian/csi-justmount/pkg/mountinfo"
 	"go.uber.org/zap"
 )
 
-type mountInfoEntry struct {
-	mountPoint string
-	device     string
-}
-
 var readMountInfo = func() ([]byte, error) {
-	return os.ReadFile("/proc/self/mountinfo")
+	return os.ReadFile(mountinfo.SelfPath)
 }
 
 func (n *Node) unmountDependentMounts(ctx context.Context, stagingPath string) error {
@@ -29,11 +26,12 @@ func (n *Node) unmountDependentMounts(ctx context.Context, stagingPath string) e
 		return nil
 	}
 
-	dependents, err := dependentMounts(stagingEntry)
+	dependents, err := n.dependentMounts(stagingEntry)
 	if err != nil {
 		return fmt.Errorf("read dependent mounts: %w", err)
 	}
-	for i, target := range dependents {
+	for i, dependent := range dependents {
+		target := dependent.MountPoint
 		Logger(ctx).Warn("unmounting dependent bind mount for disconnected staging mount",
 			zap.String("staging_target_path", stagingPath),
 			zap.String("target_path", target),
@@ -45,62 +43,157 @@ func (n *Node) unmountDependentMounts(ctx context.Context, stagingPath string) e
 	return nil
 }
 
-func mountInfoEntryForPath(path string) (mountInfoEntry, bool, error) {
-	cleaned := filepath.Clean(path)
+// liveDependentMounts returns the other mount points of the staging device
+// that still answer. When the staging mount itself is disconnected every bind
+// of it is dead as well and none are returned.
+func (n *Node) liveDependentMounts(stagingPath string) ([]string, error) {
+	stagingEntry, ok, err := mountInfoEntryForPath(stagingPath)
+	if err != nil {
+		return nil, fmt.Errorf("read mountinfo: %w", err)
+	}
+	if !ok {
+		return nil, nil
+	}
+	if isDisconnectedMountError(probeMountPath(stagingPath)) {
+		return nil, nil
+	}
+	dependents, err := n.dependentMounts(stagingEntry)
+	if err != nil {
+		return nil, fmt.Errorf("read dependent mounts: %w", err)
+	}
+	var live []string
+	for _, dependent := range dependents {
+		if isDisconnectedMountError(probeMountPath(dependent.MountPoint)) {
+			continue
+		}
+		live = append(live, dependent.MountPoint)
+	}
+	return live, nil
+}
+
+func mountInfoEntryForPath(path string) (mountinfo.Entry, bool, error) {
 	entries, err := mountInfoEntries()
 	if err != nil {
-		return mountInfoEntry{}, false, err
+		return mountinfo.Entry{}, false, err
 	}
-	for _, entry := range entries {
-		if filepath.Clean(entry.mountPoint) == cleaned {
-			return entry, true, nil
+	entry, ok := mountinfo.FindByMountPoint(entries, path)
+	return entry, ok, nil
+}
+
+// dependentMounts returns the mounts that depend on the staging mount, deepest
+// mount point first so nested mounts are released before their parents. A bind
+// volume shares its device with the host mount it was taken from, and when its
+// source is that mount's root nothing in mountinfo tells the two apart, so only
+// its recorded publish targets count.
+func (n *Node) dependentMounts(stagingEntry mountinfo.Entry) ([]mountinfo.Entry, error) {
+	mounts, err := deviceMounts(stagingEntry)
+	if err != nil {
+		return nil, err
+	}
+	rec, ok := n.state.getByStagingPath(stagingEntry.MountPoint)
+	if !ok || rec.FsType != bindFsType {
+		return mounts, nil
+	}
+	published := map[string]bool{}
+	for target := range rec.Publishes {
+		published[filepath.Clean(target)] = true
+	}
+	var dependents []mountinfo.Entry
+	for _, mount := range mounts {
+		if published[mount.MountPoint] {
+			dependents = append(dependents, mount)
 		}
 	}
-	return mountInfoEntry{}, false, nil
+	return dependents, nil
 }
 
-func dependentMounts(stagingEntry mountInfoEntry) ([]string, error) {
+// deviceMounts returns the other mounts of the staging device below the root
+// of the staging mount, deepest mount point first.
+func deviceMounts(stagingEntry mountinfo.Entry) ([]mountinfo.Entry, error) {
 	entries, err := mountInfoEntries()
 	if err != nil {
 		return nil, err
 	}
 
-	cleanedStagingPath := filepath.Clean(stagingEntry.mountPoint)
-	var mounts []string
-	for _, entry := range entries {
-		cleanedMountPoint := filepath.Clean(entry.mountPoint)
-		if entry.device != stagingEntry.device || cleanedMountPoint == cleanedStagingPath {
+	// A bind of the staging mount can only expose paths below its root. Other
+	// mounts of the device, such as the host mount a bind volume was taken
+	// from, are not dependents.
+	cleanedStagingPath := filepath.Clean(stagingEntry.MountPoint)
+	stagingRoot := filepath.Clean("/" + stagingEntry.Root)
+	var mounts []mountinfo.Entry
+	for _, entry := range mountinfo.FindByDevice(entries, stagingEntry.Major, stagingEntry.Minor) {
+		entry.MountPoint = filepath.Clean(entry.MountPoint)
+		if entry.MountPoint == cleanedStagingPath {
 			continue
 		}
-		mounts = append(mounts, cleanedMountPoint)
+		if root := filepath.Clean("/" + entry.Root); root != stagingRoot && stagingRoot != "/" && !strings.HasPrefix(root, stagingRoot+"/") {
+			continue
+		}
+		mounts = append(mounts, entry)
 	}
 
 	sort.Slice(mounts, func(i, j int) bool {
-		return len(mounts[i]) > len(mounts[j])
+		return len(mounts[i].MountPoint) > len(mounts[j].MountPoint)
 	})
 	return mounts, nil
 }
 
-func mountInfoEntries() ([]mountInfoEntry, error) {
-	data, err := readMountInfo()
+// unmountSubmounts unmounts every mount below path, deepest first, leaving the
+// mount at path itself in place.
+func (n *Node) unmountSubmounts(ctx context.Context, path string) error {
+	entries, err := mountInfoEntries()
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("read mountinfo: %w", err)
 	}
-
-	var entries []mountInfoEntry
-	for _, line := range strings.Split(string(data), "\n") {
-		if strings.TrimSpace(line) == "" {
-			continue
+	prefix := filepath.Clean(path) + "/"
+	var submounts []string
+	for _, entry := range entries {
+		if mountPoint := filepath.Clean(entry.MountPoint); strings.HasPrefix(mountPoint, prefix) {
+			submounts = append(submounts, mountPoint)
 		}
-		parts := strings.SplitN(line, " - ", 2)
-		fields := strings.Fields(parts[0])
-		if len(fields) < 5 {
-			continue
+	}
+	sort.Slice(submounts, func(i, j int) bool {
+		return len(submounts[i]) > len(submounts[j])
+	})
+	for _, submount := range submounts {
+		Logger(ctx).Info("unmounting submount", zap.String("path", path), zap.String("submount", submount))
+		if err := n.unmountAllAtPath(ctx, submount); err != nil {
+			return err
 		}
-		entries = append(entries, mountInfoEntry{
-			mountPoint: unescapeMountPath(fields[4]),
-			device:     fields[2],
-		})
+	}
+	return nil
+}
+
+// bindSubDir returns the directory below the staging mount that a dependent
+// bind mount exposes, derived from the mountinfo root of both mounts, or ""
+// for the whole staging mount.
+func bindSubDir(stagingEntry, dependent mountinfo.Entry) string {
+	rel, err := filepath.Rel(filepath.Clean("/"+stagingEntry.Root), filepath.Clean("/"+dependent.Root))
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, "../") {
+		return ""
+	}
+	return rel
+}
+
+func mountInfoEntries() ([]mountinfo.Entry, error) {
+	data, err := readMountInfo()
+	if err != nil {
+		return nil, err
+	}
+	entries, warnings, err := mountinfo.Parse(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	for _, warning := range warnings {
+		BaseLogger().Warn("skipping malformed mountinfo line", zap.Error(warning))
 	}
 	return entries, nil
 }
+
+func findMountInfoLine(path string) (string, bool) {
+	entry, ok, err := mountInfoEntryForPath(path)
+	if err != nil || !ok {
+		return "", false
+	}
+	return entry.Line, true
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"net"
 	"os"
+	"time"
 
 	csi "github.com/container-storage-interface/spec/lib/go/csi"
 	"go.uber.org/zap"
@@ -20,32 +21,162 @@ type Node struct {
 	server      *grpc.Server
 	mounter     Mounter
 	pvcReporter PVCReporter
+	watchdog    WatchdogConfig
+	state       *stateStore
+	secretsDir  string
+
+	scratchTemplateDir string
+	bindAllowedPaths   []string
+	overlayUpperRoot   string
+	policy             *Policy
+
+	mountReadyTimeout  time.Duration
+	mountHelperTimeout time.Duration
+	fsckTimeout        time.Duration
+	unmount            UnmountConfig
+	locks              *operationLocks
+	probes             probeSet
+
+	cancel context.CancelFunc
 
 	csi.UnimplementedNodeServer
 	csi.UnimplementedIdentityServer
 }
 
+// Option configures optional Node behavior.
+type Option func(*Node)
+
+// WithWatchdog enables the background mount health watchdog.
+func WithWatchdog(cfg WatchdogConfig) Option {
+	return func(n *Node) {
+		n.watchdog = cfg
+	}
+}
+
+// WithStateDir persists per-volume stage state as JSON records under dir.
+func WithStateDir(dir string) Option {
+	return func(n *Node) {
+		n.state = newStateStore(dir)
+	}
+}
+
+// WithSecretsDir writes secret files referenced by mountOptions to a private
+// tmpfs mounted at dir.
+func WithSecretsDir(dir string) Option {
+	return func(n *Node) {
+		n.secretsDir = dir
+	}
+}
+
+// WithScratchTemplateDir lets tmpfs and ramfs volumes be seeded from template
+// directories below dir.
+func WithScratchTemplateDir(dir string) Option {
+	return func(n *Node) {
+		n.scratchTemplateDir = dir
+	}
+}
+
+// WithBindAllowedPaths lets bind volumes use host directories at or below the
+// given absolute paths. Without it bind volumes are refused.
+func WithBindAllowedPaths(paths []string) Option {
+	return func(n *Node) {
+		n.bindAllowedPaths = paths
+	}
+}
+
+// WithOverlayUpperRoot lets writable overlay volumes keep their upper layers
+// below dir. Without it upperDir is refused.
+func WithOverlayUpperRoot(dir string) Option {
+	return func(n *Node) {
+		n.overlayUpperRoot = dir
+	}
+}
+
+// WithPolicy checks every volume against p before NodeStageVolume mounts it.
+// A nil policy allows everything.
+func WithPolicy(p *Policy) Option {
+	return func(n *Node) {
+		n.policy = p
+	}
+}
+
+// WithFsckTimeout bounds a pre-mount filesystem check requested with the
+// fsckBeforeMount attribute.
+func WithFsckTimeout(timeout time.Duration) Option {
+	return func(n *Node) {
+		n.fsckTimeout = timeout
+	}
+}
+
+// WithUnmount sets the unmount escalation ladder.
+func WithUnmount(cfg UnmountConfig) Option {
+	return func(n *Node) {
+		n.unmount = cfg
+	}
+}
+
+// WithMountReadyTimeout bounds how long a new staging mount may take to become
+// usable. Request deadlines still apply when they are shorter.
+func WithMountReadyTimeout(timeout time.Duration) Option {
+	return func(n *Node) {
+		n.mountReadyTimeout = timeout
+	}
+}
+
+// WithMountHelperTimeout bounds how long the mount helper may run before its
+// process group is killed. Zero leaves only the request deadline.
+func WithMountHelperTimeout(timeout time.Duration) Option {
+	return func(n *Node) {
+		n.mountHelperTimeout = timeout
+	}
+}
+
 // NewNode creates a new Node service
-func NewNode(nodeID, endpoint string) *Node {
+func NewNode(nodeID, endpoint string, opts ...Option) *Node {
 	reporter, err := NewKubernetesPVCReporter(nodeID, driverName)
 	if err != nil {
 		BaseLogger().Warn("PVC condition reporting disabled", zap.Error(err))
 	}
-	return &Node{
-		nodeID:      nodeID,
-		endpoint:    endpoint,
-		mounter:     SyscallMounter{},
-		pvcReporter: reporter,
+	n := &Node{
+		nodeID:   nodeID,
+		endpoint: endpoint,
+		mounter:  SyscallMounter{},
+		state:    newStateStore(""),
+		locks:    newOperationLocks(),
+
+		mountReadyTimeout:  defaultMountReadyTimeout,
+		mountHelperTimeout: defaultMountHelperTimeout,
+		fsckTimeout:        defaultFsckTimeout,
+		unmount:            DefaultUnmountConfig(),
+	}
+	if reporter != nil {
+		// Assigning a nil *KubernetesPVCReporter would produce a non-nil interface.
+		n.pvcReporter = reporter
+	}
+	for _, opt := range opts {
+		opt(n)
 	}
+	return n
 }
 
 // NewNodeWithMounter creates a new Node service with a custom mounter (for tests).
-func NewNodeWithMounter(nodeID, endpoint string, mounter Mounter) *Node {
-	return &Node{
+func NewNodeWithMounter(nodeID, endpoint string, mounter Mounter, opts ...Option) *Node {
+	n := &Node{
 		nodeID:   nodeID,
 		endpoint: endpoint,
 		mounter:  mounter,
+		state:    newStateStore(""),
+		locks:    newOperationLocks(),
+
+		mountReadyTimeout:  defaultMountReadyTimeout,
+		mountHelperTimeout: defaultMountHelperTimeout,
+		fsckTimeout:        defaultFsckTimeout,
+		unmount:            DefaultUnmountConfig(),
+	}
+	for _, opt := range opts {
+		opt(n)
 	}
+	return n
 }
 
 func (n *Node) Run() error {
@@ -54,6 +185,18 @@ func (n *Node) Run() error {
 		return err
 	}
 
+	ctx, cancel := context.WithCancel(context.Background())
+	n.cancel = cancel
+	defer cancel()
+
+	if err := n.loadState(); err != nil {
+		return err
+	}
+	// Restore in the background: one hung NFS or FUSE server must not keep the
+	// plugin from registering. Each volume is locked while it is restored, so
+	// requests for it fail with Aborted until then and kubelet retries them.
+	go n.restoreVolumes(ctx)
+
 	// Create the gRPC server and listen on the specified endpoint
 	listener, err := net.Listen("unix", n.endpoint)
 	if err != nil {
@@ -62,6 +205,10 @@ func (n *Node) Run() error {
 
 	n.server = grpc.NewServer(grpc.UnaryInterceptor(unaryLoggingInterceptor(n.nodeID)))
 
+	if n.watchdog.Interval > 0 {
+		go n.runWatchdog(ctx)
+	}
+
 	// Register the Node service
 	csi.RegisterNodeServer(n.server, n)
 	csi.RegisterIdentityServer(n.server, n)
@@ -76,7 +223,25 @@ func (n *Node) Run() error {
 	return nil
 }
 
+func (n *Node) loadState() error {
+	warnings, err := n.state.load()
+	if err != nil {
+		return err
+	}
+	for _, warning := range warnings {
+		BaseLogger().Warn("skipping unreadable volume state record", zap.Error(warning))
+	}
+	BaseLogger().Info("loaded volume state",
+		zap.String("state_dir", n.state.dir),
+		zap.Int("volumes", len(n.state.list())),
+	)
+	return nil
+}
+
 func (n *Node) Stop() {
+	if n.cancel != nil {
+		n.cancel()
+	}
 	if n.server != nil {
 		n.server.Stop()
 	}
@@ -101,6 +266,13 @@ func (n *Node) NodeGetCapabilities(ctx context.Context, req *csi.NodeGetCapabili
 					},
 				},
 			},
+			{
+				Type: &csi.NodeServiceCapability_Rpc{
+					Rpc: &csi.NodeServiceCapability_RPC{
+						Type: csi.NodeServiceCapability_RPC_VOLUME_MOUNT_GROUP,
+					},
+				},
+			},
 		},
 	}
 	Logger(ctx).Info("node capabilities", zap.Any("capabilities", resp.Capabilities))
@@ -0,0 +1,76 @@
+package node
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// operationLocks tracks in-flight operations by volume ID and path so that
+// check-then-act mount sequences never interleave for the same volume.
+type operationLocks struct {
+	mu   sync.Mutex
+	held map[string]string
+}
+
+func newOperationLocks() *operationLocks {
+	return &operationLocks{held: map[string]string{}}
+}
+
+// tryAcquire claims every key for op, or none of them. On conflict it returns
+// the operation currently holding one of the keys.
+func (l *operationLocks) tryAcquire(op string, keys ...string) (func(), string, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, key := range keys {
+		if holder, ok := l.held[key]; ok {
+			return nil, holder, false
+		}
+	}
+	for _, key := range keys {
+		l.held[key] = op
+	}
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			for _, key := range keys {
+				delete(l.held, key)
+			}
+		})
+	}, "", true
+}
+
+func volumeLockKey(volumeID string) string {
+	return "volume:" + volumeID
+}
+
+func pathLockKey(path string) string {
+	return "path:" + filepath.Clean(path)
+}
+
+// acquireOperation locks volumeID and paths for op. When another operation
+// holds any of them it returns codes.Aborted, as the CSI spec recommends, so
+// the caller retries later.
+func (n *Node) acquireOperation(ctx context.Context, op, volumeID string, paths ...string) (func(), error) {
+	keys := []string{volumeLockKey(volumeID)}
+	for _, path := range paths {
+		keys = append(keys, pathLockKey(path))
+	}
+	release, holder, ok := n.locks.tryAcquire(op, keys...)
+	if !ok {
+		Logger(ctx).Warn("operation already in progress",
+			zap.String("operation", op),
+			zap.String("volume_id", volumeID),
+			zap.Strings("paths", paths),
+			zap.String("in_progress", holder),
+		)
+		return nil, status.Errorf(codes.Aborted, "an operation (%s) for volume %q is already in progress", holder, volumeID)
+	}
+	return release, nil
+}
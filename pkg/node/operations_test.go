@@ -0,0 +1,112 @@
+package node
+
+import (
+	"context"
+	"syscall"
+	"testing"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestOperationLocksAcquireAllOrNothing(t *testing.T) {
+	locks := newOperationLocks()
+
+	release, _, ok := locks.tryAcquire("first", volumeLockKey("vol-a"), pathLockKey("/stage/a/"))
+	if !ok {
+		t.Fatalf("tryAcquire(first) ok = false, want true")
+	}
+	if _, holder, ok := locks.tryAcquire("second", volumeLockKey("vol-b"), pathLockKey("/stage/a")); ok || holder != "first" {
+		t.Fatalf("tryAcquire(second) = ok %v holder %q, want conflict with first", ok, holder)
+	}
+	// The failed attempt must not have claimed vol-b.
+	releaseB, _, ok := locks.tryAcquire("third", volumeLockKey("vol-b"))
+	if !ok {
+		t.Fatalf("tryAcquire(third) ok = false, want true")
+	}
+	releaseB()
+
+	release()
+	release()
+	if _, _, ok := locks.tryAcquire("fourth", volumeLockKey("vol-a"), pathLockKey("/stage/a")); !ok {
+		t.Fatalf("tryAcquire(fourth) after release ok = false, want true")
+	}
+}
+
+func TestNodeOperationsReturnAbortedWhileVolumeBusy(t *testing.T) {
+	stagingPath := t.TempDir()
+	targetPath := t.TempDir()
+	n := NewNodeWithMounter("node-id", "/tmp/test-csi.sock", &recordingMounter{mounted: map[string]bool{}})
+	ctx := context.Background()
+
+	release, err := n.acquireOperation(ctx, "NodeStageVolume", "test-volume", stagingPath)
+	if err != nil {
+		t.Fatalf("acquireOperation() error = %v", err)
+	}
+	defer release()
+
+	capability := &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Mount{
+			Mount: &csi.VolumeCapability_MountVolume{FsType: "tmpfs"},
+		},
+	}
+	_, err = n.NodeStageVolume(ctx, &csi.NodeStageVolumeRequest{
+		VolumeId:          "test-volume",
+		StagingTargetPath: stagingPath,
+		VolumeCapability:  capability,
+		VolumeContext:     map[string]string{"fileMode": "0755", "source": "tmpfs"},
+	})
+	if status.Code(err) != codes.Aborted {
+		t.Errorf("NodeStageVolume() code = %v, want %v", status.Code(err), codes.Aborted)
+	}
+	_, err = n.NodePublishVolume(ctx, &csi.NodePublishVolumeRequest{
+		VolumeId:          "test-volume",
+		StagingTargetPath: stagingPath,
+		TargetPath:        targetPath,
+		VolumeCapability:  capability,
+	})
+	if status.Code(err) != codes.Aborted {
+		t.Errorf("NodePublishVolume() code = %v, want %v", status.Code(err), codes.Aborted)
+	}
+	_, err = n.NodeUnpublishVolume(ctx, &csi.NodeUnpublishVolumeRequest{
+		VolumeId:   "test-volume",
+		TargetPath: targetPath,
+	})
+	if status.Code(err) != codes.Aborted {
+		t.Errorf("NodeUnpublishVolume() code = %v, want %v", status.Code(err), codes.Aborted)
+	}
+	_, err = n.NodeUnstageVolume(ctx, &csi.NodeUnstageVolumeRequest{
+		VolumeId:          "other-volume",
+		StagingTargetPath: stagingPath,
+	})
+	if status.Code(err) != codes.Aborted {
+		t.Errorf("NodeUnstageVolume() for busy path code = %v, want %v", status.Code(err), codes.Aborted)
+	}
+}
+
+func TestWatchdogSkipsVolumeWithOperationInProgress(t *testing.T) {
+	stagingPath := t.TempDir()
+	mounter := &recordingMounter{mounted: map[string]bool{stagingPath: true}}
+	n := NewNodeWithMounter("node-id", "/tmp/test-csi.sock", mounter)
+	if err := n.state.putStage(volumeRecord{VolumeID: "test-volume", StagingTargetPath: stagingPath}); err != nil {
+		t.Fatalf("putStage() error = %v", err)
+	}
+
+	origProbeMountPath := probeMountPath
+	probeMountPath = func(path string) error {
+		return syscall.ENOTCONN
+	}
+	t.Cleanup(func() { probeMountPath = origProbeMountPath })
+
+	release, err := n.acquireOperation(context.Background(), "NodePublishVolume", "test-volume")
+	if err != nil {
+		t.Fatalf("acquireOperation() error = %v", err)
+	}
+	defer release()
+
+	n.checkStagedVolumes(context.Background())
+	if len(mounter.unmounts) != 0 {
+		t.Fatalf("checkStagedVolumes() unmounts = %v, want none while volume is busy", mounter.unmounts)
+	}
+}
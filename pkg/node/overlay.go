@@ -0,0 +1,431 @@
+package node
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/joejulian/csi-justmount/pkg/mountopts"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// overlayFsType selects an overlay volume assembled from lower layers listed
+// in volumeContext.
+const overlayFsType = "overlay"
+
+// overlayLayerPrefix starts the lower.<n>.source, lower.<n>.fsType and
+// lower.<n>.mountOptions attributes. lower.0 is the topmost layer.
+const overlayLayerPrefix = "lower."
+
+// parseOverlayLayers builds a stage record for each lower layer in
+// volumeContext. Layers are always mounted read-only below
+// stagingPath/lower/<n>.
+func parseOverlayLayers(stagingPath string, volumeContext map[string]string) ([]volumeRecord, error) {
+	var layers []volumeRecord
+	for i := 0; ; i++ {
+		prefix := overlayLayerPrefix + strconv.Itoa(i) + "."
+		source := volumeContext[prefix+"source"]
+		if source == "" {
+			break
+		}
+		fsType := volumeContext[prefix+"fsType"]
+		if fsType == "" || fsType == overlayFsType {
+			return nil, fmt.Errorf("%sfsType must name the filesystem of layer %d", prefix, i)
+		}
+		opts := volumeContext[prefix+"mountOptions"]
+		if hasSecretRefs(opts) {
+			return nil, fmt.Errorf("%smountOptions must not reference secrets", prefix)
+		}
+		parsed, err := mountopts.Parse(opts)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %smountOptions: %w", prefix, err)
+		}
+		if err := checkMountOperation(fsType, parsed.Flags); err != nil {
+			return nil, fmt.Errorf("%smountOptions %w", prefix, err)
+		}
+		if fsType == bindFsType && parsed.Data != "" {
+			return nil, fmt.Errorf("%sfsType bind only takes mount flags, not %q", prefix, parsed.Data)
+		}
+		loop, err := isLoopImage(source, fsType)
+		if err != nil {
+			return nil, fmt.Errorf("inspect %ssource: %w", prefix, err)
+		}
+		path := filepath.Join(stagingPath, "lower", strconv.Itoa(i))
+		if strings.ContainsAny(path, ":,") {
+			return nil, fmt.Errorf("layer path %q cannot be passed to overlayfs", path)
+		}
+		layers = append(layers, volumeRecord{
+			StagingTargetPath: path,
+			Source:            source,
+			FsType:            fsType,
+			Loop:              loop,
+			MountOptions:      opts,
+			MountFlags:        parsed.Flags | syscall.MS_RDONLY,
+			MountPropagation:  parsed.Propagation,
+			MountData:         parsed.Data,
+		})
+	}
+	if len(layers) == 0 {
+		return nil, fmt.Errorf("overlay volumes need at least %s0.source", overlayLayerPrefix)
+	}
+	for key := range volumeContext {
+		if !strings.HasPrefix(key, overlayLayerPrefix) {
+			continue
+		}
+		index, _, _ := strings.Cut(strings.TrimPrefix(key, overlayLayerPrefix), ".")
+		if n, err := strconv.Atoi(index); err != nil || n >= len(layers) {
+			return nil, fmt.Errorf("unexpected overlay attribute %q; layers must be numbered from 0 without gaps", key)
+		}
+	}
+	if upper := volumeContext["upperDir"]; upper != "" {
+		if filepath.IsAbs(upper) {
+			return nil, fmt.Errorf("upperDir %q must be relative to the overlay upper root", upper)
+		}
+		for _, part := range strings.Split(upper, "/") {
+			if part == ".." {
+				return nil, fmt.Errorf("upperDir %q must not contain '..'", upper)
+			}
+		}
+		if strings.ContainsAny(upper, ":,") {
+			return nil, fmt.Errorf("upperDir %q cannot be passed to overlayfs", upper)
+		}
+	}
+	return layers, nil
+}
+
+// nodeStageOverlayVolume mounts every lower layer of an overlay volume. The
+// overlay itself is assembled per publish so each target gets its own
+// writable layer.
+func (n *Node) nodeStageOverlayVolume(ctx context.Context, req *csi.NodeStageVolumeRequest) (*csi.NodeStageVolumeResponse, error) {
+	stagingPath := filepath.Clean(req.GetStagingTargetPath())
+	layers, err := parseOverlayLayers(stagingPath, req.GetVolumeContext())
+	if err != nil {
+		Logger(ctx).Error("NodeStageVolume invalid argument: invalid overlay layers", zap.Error(err))
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if req.GetVolumeContext()["upperDir"] != "" && n.overlayUpperRoot == "" {
+		Logger(ctx).Error("NodeStageVolume upperDir requested without an overlay upper root")
+		return nil, status.Error(codes.FailedPrecondition, "upperDir requires the node plugin to run with --overlay-upper-root")
+	}
+	// The overlay itself has no source or options until it is published, so
+	// only its fsType is checked; each layer is a staging mount of its own and
+	// has to pass the whole policy.
+	if err := refusedByPolicy(ctx, n.policy.checkFsType(overlayFsType), overlayFsType, "", ""); err != nil {
+		return nil, err
+	}
+	for _, layer := range layers {
+		// As for a bind volume, the policy sees the host directory a bind
+		// layer resolves to, so a symlink cannot hide it.
+		policySource := layer.Source
+		if layer.FsType == bindFsType {
+			resolved, err := n.resolveBindSource(layer.Source)
+			if err != nil {
+				Logger(ctx).Error("NodeStageVolume refusing bind layer source", zap.String("source", layer.Source), zap.Error(err))
+				return nil, bindSourceError(err)
+			}
+			policySource = resolved
+		}
+		if err := n.checkPolicy(ctx, layer.FsType, policySource, layer.MountOptions); err != nil {
+			return nil, err
+		}
+	}
+
+	release, err := n.acquireOperation(ctx, "NodeStageVolume", req.GetVolumeId(), req.GetStagingTargetPath())
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	rec := volumeRecord{
+		VolumeID:          req.GetVolumeId(),
+		StagingTargetPath: stagingPath,
+		FsType:            overlayFsType,
+		Layers:            layers,
+		VolumeContext:     req.GetVolumeContext(),
+	}
+	if existing, ok := n.state.get(req.GetVolumeId()); ok {
+		// Keep the loop devices of layers staged by an earlier call.
+		for i := range rec.Layers {
+			if i < len(existing.Layers) && existing.Layers[i].Source == rec.Layers[i].Source {
+				rec.Layers[i].LoopDevice = existing.Layers[i].LoopDevice
+			}
+		}
+	}
+	for i := range rec.Layers {
+		rec.Layers[i].VolumeID = rec.VolumeID
+	}
+	if err := os.MkdirAll(stagingPath, 0755); err != nil {
+		Logger(ctx).Error("NodeStageVolume failed to create staging path", zap.Error(err))
+		return nil, status.Errorf(codes.Internal, "failed to create staging path: %v", err)
+	}
+	if err := n.mountOverlayLayers(ctx, &rec); err != nil {
+		if teardownErr := n.unmountOverlayLayers(ctx, &rec); teardownErr != nil {
+			Logger(ctx).Error("NodeStageVolume failed to release overlay layers", zap.Error(teardownErr))
+		}
+		return nil, err
+	}
+	if err := n.saveStageState(ctx, rec); err != nil {
+		return nil, err
+	}
+
+	Logger(ctx).Info("NodeStageVolume complete: overlay layers staged", zap.Int("layers", len(rec.Layers)))
+	return &csi.NodeStageVolumeResponse{}, nil
+}
+
+// mountOverlayLayers mounts each layer of rec that is not already mounted and
+// usable, replacing disconnected ones.
+func (n *Node) mountOverlayLayers(ctx context.Context, rec *volumeRecord) error {
+	for i := range rec.Layers {
+		layer := &rec.Layers[i]
+		path := layer.StagingTargetPath
+		if err := os.MkdirAll(path, 0755); err != nil {
+			return status.Errorf(codes.Internal, "failed to create overlay layer path: %v", err)
+		}
+		mounted, err := n.mounter.IsMountPoint(path)
+		if err != nil {
+			return status.Errorf(codes.Internal, "failed to verify overlay layer mountpoint: %v", err)
+		}
+		if mounted {
+			probeErr := probeMountPath(path)
+			if probeErr == nil {
+				continue
+			}
+			if !isDisconnectedMountError(probeErr) {
+				return status.Errorf(codes.Internal, "overlay layer %d is mounted but not usable: %v", i, probeErr)
+			}
+			Logger(ctx).Warn("replacing disconnected overlay layer", zap.Int("layer", i), zap.Error(probeErr))
+			if err := n.unmountAllAtPath(ctx, path); err != nil {
+				return status.Errorf(codes.Internal, "failed to unmount disconnected overlay layer: %v", err)
+			}
+		}
+		Logger(ctx).Info("mounting overlay layer",
+			zap.Int("layer", i),
+			zap.String("source", layer.Source),
+			zap.String("fs_type", layer.FsType),
+		)
+		if err := n.mountStaging(ctx, layer); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unmountOverlayLayers unmounts the layers of rec bottom first, detaching any
+// loop devices and removing the layer directories.
+func (n *Node) unmountOverlayLayers(ctx context.Context, rec *volumeRecord) error {
+	for i := len(rec.Layers) - 1; i >= 0; i-- {
+		layer := &rec.Layers[i]
+		if err := n.unmountAllAtPath(ctx, layer.StagingTargetPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("unmount overlay layer %d: %w", i, err)
+		}
+		if err := releaseLoopDevice(ctx, layer); err != nil {
+			return fmt.Errorf("release overlay layer %d: %w", i, err)
+		}
+		if err := os.Remove(layer.StagingTargetPath); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("remove overlay layer path: %w", err)
+		}
+	}
+	if err := os.Remove(filepath.Join(rec.StagingTargetPath, "lower")); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("remove overlay layer directory: %w", err)
+	}
+	return nil
+}
+
+// unstageOverlayVolume releases the layers of an overlay volume once no
+// publish target still has the overlay mounted.
+func (n *Node) unstageOverlayVolume(ctx context.Context, rec volumeRecord) error {
+	var live []string
+	for target := range rec.Publishes {
+		mounted, err := n.mounter.IsMountPoint(target)
+		if err != nil && !os.IsNotExist(err) {
+			return status.Errorf(codes.Internal, "failed to verify overlay target mountpoint: %v", err)
+		}
+		if mounted && !isDisconnectedMountError(probeMountPath(target)) {
+			live = append(live, target)
+		}
+	}
+	if len(live) > 0 {
+		Logger(ctx).Error("NodeUnstageVolume overlay is still published", zap.Strings("target_paths", live))
+		return status.Errorf(codes.FailedPrecondition, "overlay is still mounted at %s", strings.Join(live, ", "))
+	}
+	if err := n.unmountOverlayLayers(ctx, &rec); err != nil {
+		Logger(ctx).Error("NodeUnstageVolume failed to release overlay layers", zap.Error(err))
+		if saveErr := n.state.putStage(rec); saveErr != nil {
+			Logger(ctx).Warn("failed to record released overlay layers", zap.Error(saveErr))
+		}
+		return status.Errorf(codes.Internal, "failed to release overlay layers: %v", err)
+	}
+	return nil
+}
+
+// overlayUpperDir returns the per-publish directory, relative to the overlay
+// upper root, that holds the upper and work directories for targetPath. The
+// volume ID and target path are hashed so neither can name another directory.
+func overlayUpperDir(upperDir, volumeID, targetPath string) string {
+	volumeSum := sha256.Sum256([]byte(volumeID))
+	targetSum := sha256.Sum256([]byte(filepath.Clean(targetPath)))
+	return filepath.Join(filepath.Clean(upperDir), hex.EncodeToString(volumeSum[:8]), hex.EncodeToString(targetSum[:8]))
+}
+
+// createOverlayUpperDirs creates the upper and work directories of rel below
+// the overlay upper root without following symlinks.
+func (n *Node) createOverlayUpperDirs(rel string) error {
+	for _, name := range []string{"upper", "work"} {
+		dir, err := openSubDir(n.overlayUpperRoot, subDirOptions{
+			path:   filepath.Join(rel, name),
+			create: true,
+			mode:   0755,
+			uid:    -1,
+			gid:    -1,
+		})
+		if err != nil {
+			return err
+		}
+		_ = dir.Close()
+	}
+	return nil
+}
+
+// nodePublishOverlayVolume mounts an overlay of the staged layers at the
+// target path. With upperDir set, a writable publish gets its own upper and
+// work directories, which are discarded on unpublish; otherwise the overlay
+// is read-only.
+func (n *Node) nodePublishOverlayVolume(ctx context.Context, req *csi.NodePublishVolumeRequest, rec volumeRecord) (*csi.NodePublishVolumeResponse, error) {
+	if req.GetVolumeContext()["subDir"] != "" {
+		Logger(ctx).Error("NodePublishVolume invalid argument: subDir is not supported for overlay volumes")
+		return nil, status.Error(codes.InvalidArgument, "subDir is not supported for overlay volumes")
+	}
+	flags, err := publishMountFlags(req)
+	if err != nil {
+		Logger(ctx).Error("NodePublishVolume invalid argument: invalid mount options", zap.Error(err))
+		return nil, status.Errorf(codes.InvalidArgument, "invalid mount options: %v", err)
+	}
+	upperDir := rec.VolumeContext["upperDir"]
+	if upperDir == "" {
+		flags |= syscall.MS_RDONLY
+	}
+	if upperDir != "" && n.overlayUpperRoot == "" {
+		Logger(ctx).Error("NodePublishVolume upperDir requested without an overlay upper root")
+		return nil, status.Error(codes.FailedPrecondition, "upperDir requires the node plugin to run with --overlay-upper-root")
+	}
+
+	release, err := n.acquireOperation(ctx, "NodePublishVolume", req.GetVolumeId(), req.GetTargetPath())
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	targetPath := filepath.Clean(req.GetTargetPath())
+	if err := os.MkdirAll(targetPath, 0755); err != nil {
+		Logger(ctx).Error("NodePublishVolume failed to create target path", zap.Error(err))
+		return nil, status.Errorf(codes.Internal, "failed to create target path: %v", err)
+	}
+	pub := publishRecord{
+		TargetPath:    targetPath,
+		Readonly:      flags&syscall.MS_RDONLY != 0,
+		MountFlags:    flags &^ syscall.MS_RDONLY,
+		VolumeContext: req.GetVolumeContext(),
+	}
+	if existing, ok := rec.Publishes[targetPath]; ok {
+		pub.UpperDir = existing.UpperDir
+	}
+
+	mounted, err := n.mounter.IsMountPoint(targetPath)
+	if err != nil {
+		Logger(ctx).Error("NodePublishVolume failed to check target mountpoint", zap.Error(err))
+		return nil, status.Errorf(codes.Internal, "failed to verify target path mountpoint: %v", err)
+	}
+	if mounted {
+		if err := n.checkPublishedReadonly(ctx, req, pub.Readonly); err != nil {
+			return nil, err
+		}
+		if err := n.putOverlayPublish(ctx, req, pub); err != nil {
+			return nil, err
+		}
+		Logger(ctx).Info("NodePublishVolume complete: overlay already mounted")
+		return &csi.NodePublishVolumeResponse{}, nil
+	}
+
+	lowers := make([]string, len(rec.Layers))
+	for i, layer := range rec.Layers {
+		lowers[i] = layer.StagingTargetPath
+	}
+	switch {
+	case !pub.Readonly:
+		rel := overlayUpperDir(upperDir, req.GetVolumeId(), targetPath)
+		if err := n.createOverlayUpperDirs(rel); err != nil {
+			Logger(ctx).Error("NodePublishVolume failed to create overlay upper directory", zap.Error(err))
+			if errors.Is(err, errSubDirSymlink) {
+				return nil, status.Errorf(codes.FailedPrecondition, "failed to create overlay upper directory: %v", err)
+			}
+			return nil, status.Errorf(codes.Internal, "failed to create overlay upper directory: %v", err)
+		}
+		pub.UpperDir = filepath.Join(n.overlayUpperRoot, rel)
+		upper, work := filepath.Join(pub.UpperDir, "upper"), filepath.Join(pub.UpperDir, "work")
+		// Record the upper directory before mounting so a failed publish
+		// still has it cleaned up by unpublish.
+		if err := n.putOverlayPublish(ctx, req, pub); err != nil {
+			return nil, err
+		}
+		data := fmt.Sprintf("lowerdir=%s,upperdir=%s,workdir=%s", strings.Join(lowers, ":"), upper, work)
+		err = n.mounter.Mount(overlayFsType, targetPath, overlayFsType, flags, data)
+	case len(lowers) == 1:
+		// overlayfs needs two lower layers without an upper one; a single
+		// read-only layer is simply bound.
+		err = n.bindMount(ctx, lowers[0], targetPath, flags)
+	default:
+		err = n.mounter.Mount(overlayFsType, targetPath, overlayFsType, flags, "lowerdir="+strings.Join(lowers, ":"))
+	}
+	if err != nil {
+		Logger(ctx).Error("failed to mount overlay",
+			zap.Strings("lower_dirs", lowers),
+			zap.String("upper_dir", pub.UpperDir),
+			zap.String("target_path", targetPath),
+			zap.Error(err),
+		)
+		return nil, status.Errorf(codes.Internal, "failed to mount overlay: %v", err)
+	}
+	if err := n.putOverlayPublish(ctx, req, pub); err != nil {
+		return nil, err
+	}
+
+	Logger(ctx).Info("NodePublishVolume complete: overlay mounted",
+		zap.Int("layers", len(lowers)),
+		zap.String("upper_dir", pub.UpperDir),
+	)
+	return &csi.NodePublishVolumeResponse{}, nil
+}
+
+func (n *Node) putOverlayPublish(ctx context.Context, req *csi.NodePublishVolumeRequest, pub publishRecord) error {
+	if err := n.state.putPublish(req.GetVolumeId(), req.GetStagingTargetPath(), pub); err != nil {
+		Logger(ctx).Error("NodePublishVolume failed to persist publish state", zap.Error(err))
+		return status.Errorf(codes.Internal, "failed to persist publish state: %v", err)
+	}
+	return nil
+}
+
+// removeOverlayUpperDir discards the writable layer of an unpublished overlay
+// target and the volume directory above it once that is empty.
+func removeOverlayUpperDir(ctx context.Context, upperDir string) error {
+	if upperDir == "" {
+		return nil
+	}
+	if err := os.RemoveAll(upperDir); err != nil {
+		return err
+	}
+	Logger(ctx).Info("removed overlay upper directory", zap.String("upper_dir", upperDir))
+	if err := os.Remove(filepath.Dir(upperDir)); err != nil && !os.IsNotExist(err) && !errors.Is(err, syscall.ENOTEMPTY) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,303 @@
+package node
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestOverlayVolumeLifecycle(t *testing.T) {
+	stagingPath := filepath.Join(t.TempDir(), "staging")
+	targetPath := filepath.Join(t.TempDir(), "target")
+	upperRoot := t.TempDir()
+	mounter := &recordingMounter{mounted: map[string]bool{}}
+	n := NewNodeWithMounter("node-id", "/tmp/test-csi.sock", mounter, WithOverlayUpperRoot(upperRoot))
+	stubMountInfo(t, "")
+
+	_, err := n.NodeStageVolume(context.Background(), stageRequest(stagingPath, overlayFsType, map[string]string{
+		"lower.0.source":       "gluster:patches",
+		"lower.0.fsType":       "glusterfs",
+		"lower.1.source":       "host:/dataset",
+		"lower.1.fsType":       "fuse.sshfs",
+		"lower.1.mountOptions": "nosuid,reconnect",
+		"upperDir":             "scratch",
+	}))
+	if err != nil {
+		t.Fatalf("NodeStageVolume() error = %v", err)
+	}
+	lower0 := filepath.Join(stagingPath, "lower", "0")
+	lower1 := filepath.Join(stagingPath, "lower", "1")
+	if len(mounter.mounts) != 2 || mounter.mounts[0] != lower0 || mounter.mounts[1] != lower1 {
+		t.Fatalf("NodeStageVolume() mounts = %v, want %v", mounter.mounts, []string{lower0, lower1})
+	}
+	for i, flags := range mounter.flags {
+		if flags&syscall.MS_RDONLY == 0 {
+			t.Fatalf("layer %d mounted with flags %#x, want MS_RDONLY", i, flags)
+		}
+	}
+	if mounter.flags[1]&syscall.MS_NOSUID == 0 || mounter.data[1] != "reconnect" {
+		t.Fatalf("layer 1 flags = %#x data = %q, want its own mount options", mounter.flags[1], mounter.data[1])
+	}
+
+	if _, err := n.NodePublishVolume(context.Background(), publishRequest(stagingPath, targetPath, false)); err != nil {
+		t.Fatalf("NodePublishVolume() error = %v", err)
+	}
+	base := filepath.Join(upperRoot, overlayUpperDir("scratch", "test-volume", targetPath))
+	wantData := "lowerdir=" + lower0 + ":" + lower1 +
+		",upperdir=" + filepath.Join(base, "upper") +
+		",workdir=" + filepath.Join(base, "work")
+	last := len(mounter.mounts) - 1
+	if mounter.mounts[last] != targetPath || mounter.sources[last] != overlayFsType || mounter.data[last] != wantData {
+		t.Fatalf("NodePublishVolume() mounted %q from %q with %q, want overlay with %q",
+			mounter.mounts[last], mounter.sources[last], mounter.data[last], wantData)
+	}
+	for _, dir := range []string{"upper", "work"} {
+		if _, err := os.Stat(filepath.Join(base, dir)); err != nil {
+			t.Fatalf("overlay %s directory: %v", dir, err)
+		}
+	}
+
+	_, err = n.NodeUnstageVolume(context.Background(), &csi.NodeUnstageVolumeRequest{
+		VolumeId:          "test-volume",
+		StagingTargetPath: stagingPath,
+	})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("NodeUnstageVolume() while published error = %v, want FailedPrecondition", err)
+	}
+	if len(mounter.unmounts) != 0 {
+		t.Fatalf("NodeUnstageVolume() unmounted %v while published", mounter.unmounts)
+	}
+
+	if _, err := n.NodeUnpublishVolume(context.Background(), &csi.NodeUnpublishVolumeRequest{
+		VolumeId:   "test-volume",
+		TargetPath: targetPath,
+	}); err != nil {
+		t.Fatalf("NodeUnpublishVolume() error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Dir(base)); !os.IsNotExist(err) {
+		t.Fatalf("overlay upper directory still present after unpublish: %v", err)
+	}
+
+	if _, err := n.NodeUnstageVolume(context.Background(), &csi.NodeUnstageVolumeRequest{
+		VolumeId:          "test-volume",
+		StagingTargetPath: stagingPath,
+	}); err != nil {
+		t.Fatalf("NodeUnstageVolume() error = %v", err)
+	}
+	wantUnmounts := []string{targetPath, lower1, lower0}
+	if len(mounter.unmounts) != len(wantUnmounts) {
+		t.Fatalf("unmounts = %v, want %v", mounter.unmounts, wantUnmounts)
+	}
+	for i := range wantUnmounts {
+		if mounter.unmounts[i] != wantUnmounts[i] {
+			t.Fatalf("unmounts = %v, want %v", mounter.unmounts, wantUnmounts)
+		}
+	}
+	if _, err := os.Stat(stagingPath); !os.IsNotExist(err) {
+		t.Fatalf("staging path still present after unstage: %v", err)
+	}
+	if _, ok := n.state.get("test-volume"); ok {
+		t.Fatal("stage state still recorded after unstage")
+	}
+}
+
+func TestOverlayVolumeReadonlyPublish(t *testing.T) {
+	tests := []struct {
+		name       string
+		layers     map[string]string
+		wantSource func(stagingPath string) string
+		wantData   func(stagingPath string) string
+	}{
+		{
+			name: "single layer is bound",
+			layers: map[string]string{
+				"lower.0.source": "host:/dataset",
+				"lower.0.fsType": "fuse.sshfs",
+			},
+			wantSource: func(stagingPath string) string { return filepath.Join(stagingPath, "lower", "0") },
+			wantData:   func(string) string { return "" },
+		},
+		{
+			name: "layers without upperDir",
+			layers: map[string]string{
+				"lower.0.source": "host:/patches",
+				"lower.0.fsType": "fuse.sshfs",
+				"lower.1.source": "host:/dataset",
+				"lower.1.fsType": "fuse.sshfs",
+			},
+			wantSource: func(string) string { return overlayFsType },
+			wantData: func(stagingPath string) string {
+				return "lowerdir=" + filepath.Join(stagingPath, "lower", "0") + ":" + filepath.Join(stagingPath, "lower", "1")
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stagingPath := filepath.Join(t.TempDir(), "staging")
+			targetPath := filepath.Join(t.TempDir(), "target")
+			mounter := &recordingMounter{mounted: map[string]bool{}}
+			n := NewNodeWithMounter("node-id", "/tmp/test-csi.sock", mounter)
+			stubMountInfo(t, "")
+
+			if _, err := n.NodeStageVolume(context.Background(), stageRequest(stagingPath, overlayFsType, tt.layers)); err != nil {
+				t.Fatalf("NodeStageVolume() error = %v", err)
+			}
+			staged := len(mounter.mounts)
+			if _, err := n.NodePublishVolume(context.Background(), publishRequest(stagingPath, targetPath, false)); err != nil {
+				t.Fatalf("NodePublishVolume() error = %v", err)
+			}
+			if len(mounter.mounts) <= staged || mounter.mounts[staged] != targetPath {
+				t.Fatalf("NodePublishVolume() mounts = %v, want %s", mounter.mounts[staged:], targetPath)
+			}
+			if mounter.sources[staged] != tt.wantSource(stagingPath) || mounter.data[staged] != tt.wantData(stagingPath) {
+				t.Fatalf("NodePublishVolume() mounted from %q with %q, want %q with %q",
+					mounter.sources[staged], mounter.data[staged], tt.wantSource(stagingPath), tt.wantData(stagingPath))
+			}
+			var flags uintptr
+			for _, f := range mounter.flags[staged:] {
+				flags |= f
+			}
+			if flags&syscall.MS_RDONLY == 0 {
+				t.Fatalf("NodePublishVolume() flags = %v, want read-only", mounter.flags[staged:])
+			}
+		})
+	}
+}
+
+func TestNodeStageVolumeOverlayInvalidLayers(t *testing.T) {
+	tests := []struct {
+		name    string
+		context map[string]string
+	}{
+		{
+			name:    "no layers",
+			context: map[string]string{},
+		},
+		{
+			name: "layer without fsType",
+			context: map[string]string{
+				"lower.0.source": "host:/dataset",
+			},
+		},
+		{
+			name: "gap in layer numbers",
+			context: map[string]string{
+				"lower.0.source": "host:/patches",
+				"lower.0.fsType": "fuse.sshfs",
+				"lower.2.source": "host:/dataset",
+				"lower.2.fsType": "fuse.sshfs",
+			},
+		},
+		{
+			name: "secret reference in layer options",
+			context: map[string]string{
+				"lower.0.source":       "host:/dataset",
+				"lower.0.fsType":       "fuse.sshfs",
+				"lower.0.mountOptions": "password=${secret.password}",
+			},
+		},
+		{
+			name: "bind option in a layer of another fsType",
+			context: map[string]string{
+				"lower.0.source":       "/etc",
+				"lower.0.fsType":       "ext4",
+				"lower.0.mountOptions": "bind",
+			},
+		},
+		{
+			name: "rbind option in a layer of another fsType",
+			context: map[string]string{
+				"lower.0.source":       "/",
+				"lower.0.fsType":       "fuse.sshfs",
+				"lower.0.mountOptions": "rbind,ro",
+			},
+		},
+		{
+			name: "filesystem options in a bind layer",
+			context: map[string]string{
+				"lower.0.source":       "/srv/dataset",
+				"lower.0.fsType":       "bind",
+				"lower.0.mountOptions": "ro,size=1G",
+			},
+		},
+		{
+			name: "absolute upperDir",
+			context: map[string]string{
+				"lower.0.source": "host:/dataset",
+				"lower.0.fsType": "fuse.sshfs",
+				"upperDir":       "/var/lib/kubelet",
+			},
+		},
+		{
+			name: "upperDir outside the upper root",
+			context: map[string]string{
+				"lower.0.source": "host:/dataset",
+				"lower.0.fsType": "fuse.sshfs",
+				"upperDir":       "../plugins",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mounter := &recordingMounter{mounted: map[string]bool{}}
+			n := NewNodeWithMounter("node-id", "/tmp/test-csi.sock", mounter, WithOverlayUpperRoot(t.TempDir()))
+			_, err := n.NodeStageVolume(context.Background(), stageRequest(filepath.Join(t.TempDir(), "staging"), overlayFsType, tt.context))
+			if status.Code(err) != codes.InvalidArgument {
+				t.Fatalf("NodeStageVolume() error = %v, want InvalidArgument", err)
+			}
+			if len(mounter.mounts) != 0 {
+				t.Fatalf("NodeStageVolume() mounted %v for invalid layers", mounter.mounts)
+			}
+		})
+	}
+}
+
+func TestOverlayUpperDirStaysBelowUpperRoot(t *testing.T) {
+	layers := map[string]string{
+		"lower.0.source": "gluster:patches",
+		"lower.0.fsType": "glusterfs",
+		"upperDir":       "scratch",
+	}
+
+	t.Run("no upper root", func(t *testing.T) {
+		mounter := &recordingMounter{mounted: map[string]bool{}}
+		n := NewNodeWithMounter("node-id", "/tmp/test-csi.sock", mounter)
+		_, err := n.NodeStageVolume(context.Background(), stageRequest(filepath.Join(t.TempDir(), "staging"), overlayFsType, layers))
+		if status.Code(err) != codes.FailedPrecondition {
+			t.Fatalf("NodeStageVolume() error = %v, want FailedPrecondition", err)
+		}
+		if len(mounter.mounts) != 0 {
+			t.Fatalf("NodeStageVolume() mounted %v without an upper root", mounter.mounts)
+		}
+	})
+
+	t.Run("symlink in upperDir", func(t *testing.T) {
+		upperRoot := t.TempDir()
+		outside := t.TempDir()
+		if err := os.Symlink(outside, filepath.Join(upperRoot, "scratch")); err != nil {
+			t.Fatal(err)
+		}
+		stagingPath := filepath.Join(t.TempDir(), "staging")
+		mounter := &recordingMounter{mounted: map[string]bool{}}
+		n := NewNodeWithMounter("node-id", "/tmp/test-csi.sock", mounter, WithOverlayUpperRoot(upperRoot))
+		stubMountInfo(t, "")
+		if _, err := n.NodeStageVolume(context.Background(), stageRequest(stagingPath, overlayFsType, layers)); err != nil {
+			t.Fatalf("NodeStageVolume() error = %v", err)
+		}
+
+		_, err := n.NodePublishVolume(context.Background(),
+			publishRequest(stagingPath, filepath.Join(t.TempDir(), "target"), false))
+		if status.Code(err) != codes.FailedPrecondition {
+			t.Fatalf("NodePublishVolume() error = %v, want FailedPrecondition", err)
+		}
+		if entries, _ := os.ReadDir(outside); len(entries) != 0 {
+			t.Fatalf("NodePublishVolume() created %v outside the upper root", entries)
+		}
+	})
+}
@@ -0,0 +1,71 @@
+package node
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"syscall"
+	"testing"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestApplyStagingPermissions(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("chown requires root")
+	}
+	dir := t.TempDir()
+	rec := volumeRecord{StagingTargetPath: dir, FileMode: "2770", UID: "1234", GID: "5678"}
+	if err := applyStagingPermissions(context.Background(), rec); err != nil {
+		t.Fatalf("applyStagingPermissions() error = %v", err)
+	}
+	var st syscall.Stat_t
+	if err := syscall.Stat(dir, &st); err != nil {
+		t.Fatalf("stat: %v", err)
+	}
+	if st.Uid != 1234 || st.Gid != 5678 || st.Mode&0o7777 != 0o2770 {
+		t.Fatalf("staging root = uid %d gid %d mode %o, want 1234 5678 2770", st.Uid, st.Gid, st.Mode&0o7777)
+	}
+
+	// Read-only mounts are left alone.
+	rec = volumeRecord{StagingTargetPath: dir, FileMode: "0700", UID: "1", MountFlags: syscall.MS_RDONLY}
+	if err := applyStagingPermissions(context.Background(), rec); err != nil {
+		t.Fatalf("applyStagingPermissions(ro) error = %v", err)
+	}
+	if err := syscall.Stat(dir, &st); err != nil {
+		t.Fatalf("stat: %v", err)
+	}
+	if st.Uid != 1234 || st.Mode&0o7777 != 0o2770 {
+		t.Fatalf("read-only staging root changed to uid %d mode %o", st.Uid, st.Mode&0o7777)
+	}
+}
+
+func TestIsPermissionChangeRefused(t *testing.T) {
+	for _, err := range []error{syscall.EPERM, syscall.EROFS, syscall.EOPNOTSUPP, &os.PathError{Op: "chmod", Err: syscall.EPERM}} {
+		if !isPermissionChangeRefused(err) {
+			t.Errorf("isPermissionChangeRefused(%v) = false, want true", err)
+		}
+	}
+	if isPermissionChangeRefused(fmt.Errorf("disk on fire")) {
+		t.Errorf("isPermissionChangeRefused(other) = true, want false")
+	}
+}
+
+func TestNodeStageVolumeRejectsInvalidOwner(t *testing.T) {
+	n := NewNodeWithMounter("node-id", "/tmp/test-csi.sock", &recordingMounter{mounted: map[string]bool{}})
+	for _, attr := range []string{"uid", "gid"} {
+		_, err := n.NodeStageVolume(context.Background(), &csi.NodeStageVolumeRequest{
+			VolumeId:          "test-volume",
+			StagingTargetPath: t.TempDir(),
+			VolumeCapability: &csi.VolumeCapability{
+				AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{FsType: "tmpfs"}},
+			},
+			VolumeContext: map[string]string{"source": "tmpfs", attr: "nobody"},
+		})
+		if status.Code(err) != codes.InvalidArgument {
+			t.Fatalf("NodeStageVolume() with %s=nobody code = %v, want %v", attr, status.Code(err), codes.InvalidArgument)
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package node
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/joejulian/csi-justmount/pkg/mountopts"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"sigs.k8s.io/yaml"
+)
+
+// blockPolicyFsType is the fsType raw block volumes are checked as, since
+// they carry none of their own.
+const blockPolicyFsType = "block"
+
+// Policy restricts what NodeStageVolume may mount on this node. Anyone who can
+// create a PV decides its source, fsType and mount options, so the operator
+// uses a policy to keep the privileged plugin within bounds. A nil Policy
+// allows everything.
+type Policy struct {
+	// FsTypes limits the filesystem types of staged volumes. Raw block volumes
+	// are checked as fsType "block".
+	FsTypes PolicyRules `json:"fsTypes"`
+	// Sources limits the sources of staged volumes.
+	Sources PolicyRules `json:"sources"`
+	// MountOptions lists options that are refused and options every staging
+	// mount has to carry.
+	MountOptions MountOptionRules `json:"mountOptions"`
+}
+
+// PolicyRules is an allow list and a deny list of patterns. A value is allowed
+// when it matches no deny pattern and either the allow list is empty or it
+// matches an allow pattern. "*" in a pattern matches any run of characters,
+// including "/".
+type PolicyRules struct {
+	Allow []string `json:"allow"`
+	Deny  []string `json:"deny"`
+}
+
+// MountOptionRules lists forbidden and required mount options. A forbidden
+// option without "=" refuses the option with any value, so "uid" refuses
+// uid=0 as well as uid=1000; one with "=" only refuses that exact value.
+// Required options must appear verbatim.
+type MountOptionRules struct {
+	Forbidden []string `json:"forbidden"`
+	Required  []string `json:"required"`
+}
+
+// LoadPolicy reads a YAML policy file. Unknown fields are refused so a typo
+// cannot silently leave a rule out.
+func LoadPolicy(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var p Policy
+	if err := yaml.UnmarshalStrict(data, &p); err != nil {
+		return nil, fmt.Errorf("parse policy %s: %w", path, err)
+	}
+	if err := p.validate(); err != nil {
+		return nil, fmt.Errorf("invalid policy %s: %w", path, err)
+	}
+	return &p, nil
+}
+
+func (p *Policy) validate() error {
+	for name, patterns := range map[string][]string{
+		"fsTypes.allow": p.FsTypes.Allow,
+		"fsTypes.deny":  p.FsTypes.Deny,
+		"sources.allow": p.Sources.Allow,
+		"sources.deny":  p.Sources.Deny,
+	} {
+		for _, pattern := range patterns {
+			if pattern == "" {
+				return fmt.Errorf("%s must not contain empty patterns", name)
+			}
+		}
+	}
+	for name, options := range map[string][]string{
+		"mountOptions.forbidden": p.MountOptions.Forbidden,
+		"mountOptions.required":  p.MountOptions.Required,
+	} {
+		for _, option := range options {
+			if option == "" || strings.Contains(option, ",") {
+				return fmt.Errorf("%s entries must each be a single mount option, not %q", name, option)
+			}
+		}
+	}
+	for _, option := range p.MountOptions.Required {
+		if _, ok := optionForbidden(p.MountOptions.Forbidden, option); ok {
+			return fmt.Errorf("mount option %q is both required and forbidden", option)
+		}
+	}
+	return nil
+}
+
+// check returns an error explaining the first rule a staging mount of source
+// with fsType and opts breaks.
+func (p *Policy) check(fsType, source, opts string) error {
+	if p == nil {
+		return nil
+	}
+	if err := p.checkFsType(fsType); err != nil {
+		return err
+	}
+	if err := p.Sources.check("source", source); err != nil {
+		return err
+	}
+	split := mountopts.Split(opts)
+	for _, opt := range split {
+		// The rule is reported rather than the option, which may carry a
+		// secret value.
+		if rule, ok := optionForbidden(p.MountOptions.Forbidden, opt); ok {
+			return fmt.Errorf("mount option %q is forbidden", rule)
+		}
+	}
+	var missing []string
+	for _, required := range p.MountOptions.Required {
+		if !containsOption(split, required) {
+			missing = append(missing, required)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("mountOptions must include %s", strings.Join(missing, ","))
+	}
+	return nil
+}
+
+// checkFsType applies only the fsType rules.
+func (p *Policy) checkFsType(fsType string) error {
+	if p == nil {
+		return nil
+	}
+	return p.FsTypes.check("fsType", fsType)
+}
+
+// checkBlock applies the fsType and source rules to a raw block volume. It is
+// never mounted, so the mount option rules do not apply.
+func (p *Policy) checkBlock(source string) error {
+	if err := p.checkFsType(blockPolicyFsType); err != nil || p == nil {
+		return err
+	}
+	return p.Sources.check("source", source)
+}
+
+func (r PolicyRules) check(name, value string) error {
+	for _, pattern := range r.Deny {
+		if matchGlob(pattern, value) {
+			return fmt.Errorf("%s %q is denied by pattern %q", name, value, pattern)
+		}
+	}
+	if len(r.Allow) == 0 {
+		return nil
+	}
+	for _, pattern := range r.Allow {
+		if matchGlob(pattern, value) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s %q matches none of the allowed patterns %s", name, value, strings.Join(r.Allow, ", "))
+}
+
+// optionForbidden returns the forbidden entry that opt matches.
+func optionForbidden(forbidden []string, opt string) (string, bool) {
+	key, _, _ := strings.Cut(opt, "=")
+	for _, f := range forbidden {
+		if f == opt || (!strings.Contains(f, "=") && f == key) {
+			return f, true
+		}
+	}
+	return "", false
+}
+
+func containsOption(opts []string, want string) bool {
+	for _, opt := range opts {
+		if opt == want {
+			return true
+		}
+	}
+	return false
+}
+
+// matchGlob reports whether value matches pattern, where "*" matches any run
+// of characters and everything else matches literally.
+func matchGlob(pattern, value string) bool {
+	parts := strings.Split(pattern, "*")
+	for i := range parts {
+		parts[i] = regexp.QuoteMeta(parts[i])
+	}
+	re, err := regexp.Compile("^" + strings.Join(parts, ".*") + "$")
+	if err != nil {
+		return false
+	}
+	return re.MatchString(value)
+}
+
+// checkPolicy refuses a staging mount that breaks the node policy with
+// PermissionDenied.
+func (n *Node) checkPolicy(ctx context.Context, fsType, source, opts string) error {
+	return refusedByPolicy(ctx, n.policy.check(fsType, source, opts), fsType, source, opts)
+}
+
+// refusedByPolicy logs a policy violation and turns it into PermissionDenied.
+// It returns nil when err is nil.
+func refusedByPolicy(ctx context.Context, err error, fsType, source, opts string) error {
+	if err == nil {
+		return nil
+	}
+	Logger(ctx).Error("NodeStageVolume refused by node policy",
+		zap.String("fs_type", fsType),
+		zap.String("source", source),
+		zap.String("opts", opts),
+		zap.Error(err),
+	)
+	return status.Errorf(codes.PermissionDenied, "refused by node policy: %v", err)
+}
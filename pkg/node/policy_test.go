@@ -0,0 +1,278 @@
+package node
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestLoadPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{
+			name: "valid policy",
+			content: `fsTypes:
+  allow: [nfs4, "fuse.*"]
+sources:
+  deny: ["*:/etc*"]
+mountOptions:
+  forbidden: [suid, dev]
+  required: [nosuid, nodev]
+`,
+		},
+		{name: "empty policy"},
+		{
+			name:    "unknown field",
+			content: "fsType:\n  allow: [nfs4]\n",
+			wantErr: "unknown field",
+		},
+		{
+			name:    "option list in one entry",
+			content: "mountOptions:\n  required: [\"nosuid,nodev\"]\n",
+			wantErr: "single mount option",
+		},
+		{
+			name:    "required option is forbidden",
+			content: "mountOptions:\n  forbidden: [uid]\n  required: [uid=1000]\n",
+			wantErr: "both required and forbidden",
+		},
+		{
+			name:    "empty pattern",
+			content: "sources:\n  allow: [\"\"]\n",
+			wantErr: "empty patterns",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "policy.yaml")
+			if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			p, err := LoadPolicy(path)
+			if tt.wantErr == "" {
+				if err != nil || p == nil {
+					t.Fatalf("LoadPolicy() = %v, %v", p, err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("LoadPolicy() error = %v, want it to mention %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNodeStageVolumePolicy(t *testing.T) {
+	allowed, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(allowed, "export"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(allowed, "private"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(allowed, "private"), filepath.Join(allowed, "public")); err != nil {
+		t.Fatal(err)
+	}
+	const device = "/dev/test-block"
+	stubBlockDevices(t, device, "/dev/sda")
+	stubMountInfo(t, "")
+
+	policy := &Policy{
+		FsTypes: PolicyRules{
+			Allow: []string{"nfs4", "fuse.*", "bind", "overlay", "block"},
+			Deny:  []string{"fuse.sshfs"},
+		},
+		Sources: PolicyRules{
+			Allow: []string{"storage:/exports/*", allowed + "/*", "/dev/test-*"},
+			Deny:  []string{"*/private*"},
+		},
+		MountOptions: MountOptionRules{
+			Forbidden: []string{"suid", "exec", "uid=0"},
+			Required:  []string{"nosuid", "nodev"},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		req      *csi.NodeStageVolumeRequest
+		wantCode codes.Code
+		wantMsg  string
+	}{
+		{
+			name: "allowed volume",
+			req: stageRequest("", "nfs4", map[string]string{
+				"source":       "storage:/exports/media",
+				"mountOptions": "nosuid,nodev,uid=1000",
+			}),
+		},
+		{
+			name: "fsType outside the allow list",
+			req: stageRequest("", "ext4", map[string]string{
+				"source":       "storage:/exports/media",
+				"mountOptions": "nosuid,nodev",
+			}),
+			wantCode: codes.PermissionDenied,
+			wantMsg:  `fsType "ext4" matches none of the allowed patterns`,
+		},
+		{
+			name: "denied fsType wins over an allowed pattern",
+			req: stageRequest("", "fuse.sshfs", map[string]string{
+				"source":       "storage:/exports/media",
+				"mountOptions": "nosuid,nodev",
+			}),
+			wantCode: codes.PermissionDenied,
+			wantMsg:  `fsType "fuse.sshfs" is denied by pattern "fuse.sshfs"`,
+		},
+		{
+			name: "source outside the allow list",
+			req: stageRequest("", "nfs4", map[string]string{
+				"source":       "storage:/etc",
+				"mountOptions": "nosuid,nodev",
+			}),
+			wantCode: codes.PermissionDenied,
+			wantMsg:  `source "storage:/etc"`,
+		},
+		{
+			name: "forbidden option",
+			req: stageRequest("", "nfs4", map[string]string{
+				"source":       "storage:/exports/media",
+				"mountOptions": "nosuid,nodev,exec",
+			}),
+			wantCode: codes.PermissionDenied,
+			wantMsg:  `mount option "exec" is forbidden`,
+		},
+		{
+			name: "forbidden option value",
+			req: stageRequest("", "nfs4", map[string]string{
+				"source":       "storage:/exports/media",
+				"mountOptions": "nosuid,nodev,uid=0",
+			}),
+			wantCode: codes.PermissionDenied,
+			wantMsg:  `mount option "uid=0" is forbidden`,
+		},
+		{
+			name: "missing required options",
+			req: stageRequest("", "nfs4", map[string]string{
+				"source": "storage:/exports/media",
+			}),
+			wantCode: codes.PermissionDenied,
+			wantMsg:  "mountOptions must include nosuid,nodev",
+		},
+		{
+			name: "bind source is checked after resolving symlinks",
+			req: stageRequest("", "bind", map[string]string{
+				"source":       filepath.Join(allowed, "public"),
+				"mountOptions": "nosuid,nodev",
+			}),
+			wantCode: codes.PermissionDenied,
+			wantMsg:  "is denied by pattern",
+		},
+		{
+			name: "allowed bind volume",
+			req: stageRequest("", "bind", map[string]string{
+				"source":       filepath.Join(allowed, "export"),
+				"mountOptions": "nosuid,nodev",
+			}),
+		},
+		{
+			name: "overlay layer breaking the policy",
+			req: stageRequest("", "overlay", map[string]string{
+				"lower.0.source":       "storage:/exports/patches",
+				"lower.0.fsType":       "nfs4",
+				"lower.0.mountOptions": "nosuid,nodev",
+				"lower.1.source":       "storage:/exports/base",
+				"lower.1.fsType":       "nfs4",
+			}),
+			wantCode: codes.PermissionDenied,
+			wantMsg:  "mountOptions must include nosuid,nodev",
+		},
+		{
+			name: "bind layer source is checked after resolving symlinks",
+			req: stageRequest("", "overlay", map[string]string{
+				"lower.0.source":       filepath.Join(allowed, "public"),
+				"lower.0.fsType":       "bind",
+				"lower.0.mountOptions": "nosuid,nodev",
+			}),
+			wantCode: codes.PermissionDenied,
+			wantMsg:  "is denied by pattern",
+		},
+		{
+			name: "block volume",
+			req: &csi.NodeStageVolumeRequest{
+				VolumeId: "test-volume",
+				VolumeCapability: &csi.VolumeCapability{
+					AccessType: &csi.VolumeCapability_Block{Block: &csi.VolumeCapability_BlockVolume{}},
+				},
+				VolumeContext: map[string]string{"source": device},
+			},
+		},
+		{
+			name: "block device outside the allow list",
+			req: &csi.NodeStageVolumeRequest{
+				VolumeId: "test-volume",
+				VolumeCapability: &csi.VolumeCapability{
+					AccessType: &csi.VolumeCapability_Block{Block: &csi.VolumeCapability_BlockVolume{}},
+				},
+				VolumeContext: map[string]string{"source": "/dev/sda"},
+			},
+			wantCode: codes.PermissionDenied,
+			wantMsg:  `source "/dev/sda"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mounter := &recordingMounter{mounted: map[string]bool{}}
+			n := NewNodeWithMounter("node-id", "/tmp/test-csi.sock", mounter,
+				WithPolicy(policy),
+				WithBindAllowedPaths([]string{allowed}),
+			)
+			tt.req.StagingTargetPath = filepath.Join(t.TempDir(), "stage")
+
+			_, err := n.NodeStageVolume(context.Background(), tt.req)
+			if status.Code(err) != tt.wantCode {
+				t.Fatalf("NodeStageVolume() error = %v, want code %v", err, tt.wantCode)
+			}
+			if tt.wantCode == codes.OK {
+				return
+			}
+			if !strings.Contains(status.Convert(err).Message(), tt.wantMsg) {
+				t.Fatalf("NodeStageVolume() error = %v, want it to mention %q", err, tt.wantMsg)
+			}
+			if len(mounter.mounts) != 0 {
+				t.Fatalf("NodeStageVolume() mounted %v despite the policy", mounter.mounts)
+			}
+		})
+	}
+}
+
+func TestNodeStageVolumePolicyChecksExpandedSecrets(t *testing.T) {
+	mounter := &recordingMounter{mounted: map[string]bool{}}
+	n := NewNodeWithMounter("node-id", "/tmp/test-csi.sock", mounter,
+		WithSecretsDir(t.TempDir()),
+		WithPolicy(&Policy{MountOptions: MountOptionRules{Forbidden: []string{"password"}}}),
+	)
+
+	req := stageSecretsRequest(t.TempDir(), "${secret.options}", map[string]string{"options": "password=hunter2"})
+	_, err := n.NodeStageVolume(context.Background(), req)
+	if status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("NodeStageVolume() error = %v, want code %v", err, codes.PermissionDenied)
+	}
+	if strings.Contains(err.Error(), "hunter2") {
+		t.Fatalf("NodeStageVolume() error = %v reveals the secret value", err)
+	}
+	if len(mounter.mounts) != 0 {
+		t.Fatalf("NodeStageVolume() mounted %v despite the policy", mounter.mounts)
+	}
+}
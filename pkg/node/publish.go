@@ -6,11 +6,12 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
-	"strings"
+	"slices"
 	"syscall"
 	"time"
 
 	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/joejulian/csi-justmount/pkg/mountopts"
 	"go.uber.org/zap"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
@@ -45,6 +46,42 @@ func (n *Node) NodePublishVolume(ctx context.Context, req *csi.NodePublishVolume
 		Logger(ctx).Error("NodePublishVolume invalid argument: staging_target_path is required")
 		return nil, status.Error(codes.InvalidArgument, "staging_target_path is required")
 	}
+	if req.GetVolumeCapability().GetBlock() != nil {
+		return n.nodePublishBlockVolume(ctx, req)
+	}
+	// A pod that asks for an fsGroup must get the one the volume was staged
+	// with.
+	if group := req.GetVolumeCapability().GetMount().GetVolumeMountGroup(); group != "" {
+		if rec, ok := n.state.get(req.GetVolumeId()); ok {
+			if err := mountGroupConflict(ctx, "NodePublishVolume", rec.MountGroup, group); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if rec, ok := n.state.get(req.GetVolumeId()); ok && len(rec.Layers) > 0 {
+		return n.nodePublishOverlayVolume(ctx, req, rec)
+	}
+
+	flags, err := publishMountFlags(req)
+	if err != nil {
+		Logger(ctx).Error("NodePublishVolume invalid argument: invalid mount options", zap.Error(err))
+		return nil, status.Errorf(codes.InvalidArgument, "invalid mount options: %v", err)
+	}
+	// Recursive bind volumes carry their submounts through to the target.
+	if rec, ok := n.state.get(req.GetVolumeId()); ok && rec.FsType == bindFsType && rec.MountFlags&syscall.MS_REC != 0 {
+		flags |= syscall.MS_REC
+	}
+	subDir, err := parseSubDirOptions(req.GetVolumeContext())
+	if err != nil {
+		Logger(ctx).Error("NodePublishVolume invalid argument: invalid subDir", zap.Error(err))
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	release, err := n.acquireOperation(ctx, "NodePublishVolume", req.GetVolumeId(), req.GetTargetPath())
+	if err != nil {
+		return nil, err
+	}
+	defer release()
 
 	// Ensure the target path exists
 	if err := os.MkdirAll(req.GetTargetPath(), 0755); err != nil {
@@ -72,18 +109,42 @@ func (n *Node) NodePublishVolume(ctx context.Context, req *csi.NodePublishVolume
 		}
 	}
 
-	if published, err := n.preparePublishTarget(ctx, req); err != nil {
+	if published, err := n.preparePublishTarget(ctx, req, flags); err != nil {
 		return nil, err
 	} else if published {
+		if err := n.savePublishState(ctx, req, flags); err != nil {
+			return nil, err
+		}
 		Logger(ctx).Info("NodePublishVolume complete: target path already mounted and usable")
 		return &csi.NodePublishVolumeResponse{}, nil
 	}
 
-	// Perform a bind mount from the staging path to the target path
-	err = n.mounter.Mount(req.GetStagingTargetPath(), req.GetTargetPath(), "", syscall.MS_BIND, "")
+	// Perform a bind mount from the staging path, or the requested
+	// subdirectory of it, to the target path
+	source := req.GetStagingTargetPath()
+	if subDir.path != "" {
+		dir, err := openSubDir(source, subDir)
+		if err != nil {
+			Logger(ctx).Error("NodePublishVolume failed to open subDir",
+				zap.String("sub_dir", subDir.path),
+				zap.Error(err),
+			)
+			switch {
+			case errors.Is(err, errSubDirSymlink):
+				return nil, status.Error(codes.InvalidArgument, err.Error())
+			case os.IsNotExist(err):
+				return nil, status.Errorf(codes.FailedPrecondition, "subDir does not exist; set subDirCreate to create it: %v", err)
+			}
+			return nil, status.Errorf(codes.Internal, "failed to open subDir: %v", err)
+		}
+		defer func() { _ = dir.Close() }()
+		source = subDirBindSource(dir)
+	}
+	err = n.bindMount(ctx, source, req.GetTargetPath(), flags)
 	if err != nil {
 		Logger(ctx).Error("failed to bind-mount volume",
 			zap.String("staging_target_path", req.GetStagingTargetPath()),
+			zap.String("sub_dir", subDir.path),
 			zap.String("target_path", req.GetTargetPath()),
 			zap.Error(err),
 		)
@@ -91,10 +152,28 @@ func (n *Node) NodePublishVolume(ctx context.Context, req *csi.NodePublishVolume
 	}
 
 	// Return success response
+	if err := n.savePublishState(ctx, req, flags); err != nil {
+		return nil, err
+	}
+
 	Logger(ctx).Info("NodePublishVolume complete")
 	return &csi.NodePublishVolumeResponse{}, nil
 }
 
+func (n *Node) savePublishState(ctx context.Context, req *csi.NodePublishVolumeRequest, flags uintptr) error {
+	err := n.state.putPublish(req.GetVolumeId(), req.GetStagingTargetPath(), publishRecord{
+		TargetPath:    req.GetTargetPath(),
+		Readonly:      flags&syscall.MS_RDONLY != 0,
+		MountFlags:    flags &^ syscall.MS_RDONLY,
+		VolumeContext: req.GetVolumeContext(),
+	})
+	if err != nil {
+		Logger(ctx).Error("NodePublishVolume failed to persist publish state", zap.Error(err))
+		return status.Errorf(codes.Internal, "failed to persist publish state: %v", err)
+	}
+	return nil
+}
+
 func (n *Node) waitForMountReady(ctx context.Context, req *csi.NodePublishVolumeRequest) (bool, error) {
 	path := req.GetStagingTargetPath()
 	isMounted, err := n.mounter.IsMountPoint(path)
@@ -123,26 +202,23 @@ func (n *Node) waitForMountReady(ctx context.Context, req *csi.NodePublishVolume
 			return false, status.Errorf(codes.FailedPrecondition, "mount point is not usable: %v", err)
 		}
 
-		Logger(ctx).Warn("NodePublishVolume unstaging disconnected staging mount",
+		Logger(ctx).Warn("NodePublishVolume repairing disconnected staging mount",
 			zap.String("staging_target_path", path),
 			zap.Error(err),
 		)
-		n.reportRepairStarted(ctx, req, "JustmountStagingMountDisconnected",
-			"Disconnected justmount staging mount detected; unmounting dependent bind mounts and staging target")
-		if err := n.unmountDependentMounts(ctx, path); err != nil {
-			return false, status.Errorf(codes.Internal, "failed to unmount dependent bind mounts: %v", err)
+		remounted, err := n.repairDisconnectedStaging(ctx, path, req.GetVolumeContext(), []*csi.NodePublishVolumeRequest{req})
+		if err != nil {
+			return false, err
 		}
-		if err := n.unmountAllAtPath(ctx, path); err != nil {
-			return false, status.Errorf(codes.Internal, "failed to unmount disconnected staging target path: %v", err)
+		if remounted {
+			return false, nil
 		}
-		n.reportRepairCompleted(ctx, req, "JustmountStagingMountUnstaged",
-			"Disconnected justmount staging mount and dependent bind mounts were unmounted successfully")
 		return true, status.Error(codes.FailedPrecondition, "staging mount was disconnected and has been unstaged; retry after staging")
 	}
 	return false, nil
 }
 
-func (n *Node) preparePublishTarget(ctx context.Context, req *csi.NodePublishVolumeRequest) (bool, error) {
+func (n *Node) preparePublishTarget(ctx context.Context, req *csi.NodePublishVolumeRequest, flags uintptr) (bool, error) {
 	targetPath := req.GetTargetPath()
 	isMounted, err := n.mounter.IsMountPoint(targetPath)
 	if err != nil {
@@ -157,6 +233,9 @@ func (n *Node) preparePublishTarget(ctx context.Context, req *csi.NodePublishVol
 	}
 
 	if err := probeMountPath(targetPath); err == nil {
+		if err := n.checkPublishedReadonly(ctx, req, flags&syscall.MS_RDONLY != 0); err != nil {
+			return false, err
+		}
 		Logger(ctx).Info("NodePublishVolume target path already mounted and usable",
 			zap.String("target_path", targetPath),
 		)
@@ -182,6 +261,123 @@ func (n *Node) preparePublishTarget(ctx context.Context, req *csi.NodePublishVol
 	return false, nil
 }
 
+// checkPublishedReadonly returns AlreadyExists when the target is already
+// published with a different readonly setting than want.
+func (n *Node) checkPublishedReadonly(ctx context.Context, req *csi.NodePublishVolumeRequest, want bool) error {
+	targetPath := req.GetTargetPath()
+	readonly, ok, err := mountReadonly(targetPath)
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to read mountinfo for target path: %v", err)
+	}
+	if !ok {
+		rec, found := n.state.get(req.GetVolumeId())
+		if !found {
+			return nil
+		}
+		pub, found := rec.Publishes[filepath.Clean(targetPath)]
+		if !found {
+			return nil
+		}
+		readonly = pub.Readonly
+	}
+	if readonly != want {
+		Logger(ctx).Error("NodePublishVolume target path already published with a different readonly setting",
+			zap.String("target_path", targetPath),
+			zap.Bool("readonly", readonly),
+			zap.Bool("requested_readonly", want),
+		)
+		return status.Errorf(codes.AlreadyExists, "target_path is already published with readonly=%t", readonly)
+	}
+	return nil
+}
+
+// publishReadonly reports whether req must be published read-only, either
+// because it asks for it or because its access mode only allows reading.
+func publishReadonly(req *csi.NodePublishVolumeRequest) bool {
+	return req.GetReadonly() || readerOnly(req.GetVolumeCapability())
+}
+
+// readerOnly reports whether the access mode of capability only allows reading.
+func readerOnly(capability *csi.VolumeCapability) bool {
+	switch capability.GetAccessMode().GetMode() {
+	case csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY,
+		csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY:
+		return true
+	}
+	return false
+}
+
+// publishMountFlags returns the per-mount flags for the publish bind mount of
+// req, taken from the same merged options as the staging mount, plus
+// MS_RDONLY when req must be read-only.
+func publishMountFlags(req *csi.NodePublishVolumeRequest) (uintptr, error) {
+	parsed, err := mountopts.Parse(mergedMountOptions(req.GetVolumeCapability(), req.GetVolumeContext()))
+	if err != nil {
+		return 0, err
+	}
+	flags := parsed.Flags & mountopts.PerMountFlags
+	if publishReadonly(req) {
+		flags |= syscall.MS_RDONLY
+	}
+	return flags, nil
+}
+
+// bindMount binds source onto target and applies the per-mount flags. The
+// kernel ignores flags on the initial bind, so they are set with a bind
+// remount afterwards, and a read-only result is checked in mountinfo. MS_REC
+// in flags makes the initial bind recursive.
+func (n *Node) bindMount(ctx context.Context, source, target string, flags uintptr) error {
+	recursive := flags & syscall.MS_REC
+	flags &^= syscall.MS_REC
+	if err := n.mounter.Mount(source, target, "", syscall.MS_BIND|recursive, ""); err != nil {
+		return err
+	}
+	if flags == 0 {
+		return nil
+	}
+
+	// Keep the per-mount flags inherited from the source; a remount replaces them.
+	remountFlags := syscall.MS_REMOUNT | syscall.MS_BIND | flags
+	entry, ok, err := mountInfoEntryForPath(target)
+	if err == nil && ok {
+		if parsed, err := mountopts.Parse(entry.Options); err == nil {
+			remountFlags |= parsed.Flags & mountopts.PerMountFlags
+		}
+	}
+	if err := n.mounter.Mount(source, target, "", remountFlags, ""); err != nil {
+		_ = n.mounter.Unmount(target, 0)
+		return fmt.Errorf("remount bind with mount flags: %w", err)
+	}
+	if flags&syscall.MS_RDONLY == 0 {
+		return nil
+	}
+
+	readonlyNow, ok, err := mountReadonly(target)
+	switch {
+	case err != nil:
+		_ = n.mounter.Unmount(target, 0)
+		return fmt.Errorf("verify read-only bind mount: %w", err)
+	case !ok:
+		Logger(ctx).Warn("read-only bind mount not found in mountinfo; unable to verify",
+			zap.String("target_path", target),
+		)
+	case !readonlyNow:
+		_ = n.mounter.Unmount(target, 0)
+		return fmt.Errorf("bind mount at %q is still writable after read-only remount", target)
+	}
+	return nil
+}
+
+// mountReadonly reports whether the top-most mount at path has the per-mount
+// ro option. ok is false when nothing is mounted at path.
+func mountReadonly(path string) (readonly, ok bool, err error) {
+	entry, ok, err := mountInfoEntryForPath(path)
+	if err != nil || !ok {
+		return false, ok, err
+	}
+	return slices.Contains(mountopts.Split(entry.Options), "ro"), true, nil
+}
+
 func (n *Node) reportRepairStarted(
 	ctx context.Context,
 	req *csi.NodePublishVolumeRequest,
@@ -216,37 +412,22 @@ func (n *Node) reportRepairCompleted(
 	}
 }
 
-func findMountInfoLine(path string) (string, bool) {
-	data, err := os.ReadFile("/proc/self/mountinfo")
-	if err != nil {
-		return "", false
+func (n *Node) reportVolumeEvent(
+	ctx context.Context,
+	volumeID string,
+	eventType string,
+	reason string,
+	message string,
+) {
+	if n.pvcReporter == nil {
+		return
 	}
-	cleaned := filepath.Clean(path)
-	for _, line := range strings.Split(string(data), "\n") {
-		if strings.TrimSpace(line) == "" {
-			continue
-		}
-		parts := strings.SplitN(line, " - ", 2)
-		fields := strings.Fields(parts[0])
-		if len(fields) < 5 {
-			continue
-		}
-		mountPoint := unescapeMountPath(fields[4])
-		if filepath.Clean(mountPoint) == cleaned {
-			return line, true
-		}
+	if err := n.pvcReporter.VolumeEvent(ctx, volumeID, eventType, reason, message); err != nil {
+		Logger(ctx).Warn("failed to report justmount volume event",
+			zap.String("reason", reason),
+			zap.Error(err),
+		)
 	}
-	return "", false
-}
-
-func unescapeMountPath(path string) string {
-	replacer := strings.NewReplacer(
-		"\\040", " ",
-		"\\011", "\t",
-		"\\012", "\n",
-		"\\134", "\\",
-	)
-	return replacer.Replace(path)
 }
 
 func (n *Node) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpublishVolumeRequest) (*csi.NodeUnpublishVolumeResponse, error) {
@@ -275,6 +456,19 @@ func (n *Node) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpublishVo
 		return nil, status.Error(codes.InvalidArgument, "unsafe target_path")
 	}
 
+	release, err := n.acquireOperation(ctx, "NodeUnpublishVolume", req.GetVolumeId(), targetPath)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	if pub, ok := n.state.getPublish(targetPath); ok && pub.MountFlags&syscall.MS_REC != 0 {
+		if err := n.unmountSubmounts(ctx, targetPath); err != nil {
+			Logger(ctx).Error("NodeUnpublishVolume failed to unmount submounts", zap.Error(err))
+			return nil, status.Errorf(codes.Internal, "failed to unmount submounts of target path: %v", err)
+		}
+	}
+
 	// Unmount all stacked mount layers at this path.
 	for i := 0; i < 10; i++ {
 		isMounted, err := n.mounter.IsMountPoint(targetPath)
@@ -308,11 +502,22 @@ func (n *Node) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpublishVo
 		return nil, status.Error(codes.Internal, "target_path remains mounted after unmount attempts")
 	}
 
-	// Never recurse during cleanup. target_path should be an empty directory.
+	// Never recurse during cleanup. target_path should be an empty directory,
+	// or the file a block device was bound onto.
 	if err := os.Remove(targetPath); err != nil && !os.IsNotExist(err) {
 		Logger(ctx).Error("NodeUnpublishVolume failed to remove target path", zap.Error(err))
 		return nil, status.Errorf(codes.Internal, "failed to remove target path: %v", err)
 	}
+	if pub, ok := n.state.getPublish(targetPath); ok {
+		if err := removeOverlayUpperDir(ctx, pub.UpperDir); err != nil {
+			Logger(ctx).Error("NodeUnpublishVolume failed to remove overlay upper directory", zap.Error(err))
+			return nil, status.Errorf(codes.Internal, "failed to remove overlay upper directory: %v", err)
+		}
+	}
+	if err := n.state.deletePublish(targetPath); err != nil {
+		Logger(ctx).Error("NodeUnpublishVolume failed to remove publish state", zap.Error(err))
+		return nil, status.Errorf(codes.Internal, "failed to remove publish state: %v", err)
+	}
 
 	Logger(ctx).Info("NodeUnpublishVolume complete")
 	return &csi.NodeUnpublishVolumeResponse{}, nil
@@ -341,16 +546,3 @@ func (n *Node) unmountAllAtPath(ctx context.Context, path string) error {
 	}
 	return nil
 }
-
-func (n *Node) unmountOnce(ctx context.Context, path string, attempt int) error {
-	err := n.mounter.Unmount(path, 0)
-	if err == nil || errors.Is(err, syscall.EINVAL) {
-		return nil
-	}
-	Logger(ctx).Error("failed to unmount path",
-		zap.String("path", path),
-		zap.Int("attempt", attempt),
-		zap.Error(err),
-	)
-	return err
-}
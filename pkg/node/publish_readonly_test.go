@@ -0,0 +1,138 @@
+package node
+
+import (
+	"context"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// publishRequest returns a NodePublishVolumeRequest for a mount volume.
+func publishRequest(stagingPath, targetPath string, readonly bool) *csi.NodePublishVolumeRequest {
+	return &csi.NodePublishVolumeRequest{
+		VolumeId:          "test-volume",
+		StagingTargetPath: stagingPath,
+		TargetPath:        targetPath,
+		Readonly:          readonly,
+		VolumeCapability: &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+			AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+		},
+	}
+}
+
+func stubMountInfo(t *testing.T, content string) {
+	t.Helper()
+	orig := readMountInfo
+	readMountInfo = func() ([]byte, error) { return []byte(content), nil }
+	t.Cleanup(func() { readMountInfo = orig })
+}
+
+func TestNodePublishVolumeReadonlyRemountsBind(t *testing.T) {
+	stagingPath := t.TempDir()
+	targetPath := filepath.Join(t.TempDir(), "target")
+	mounter := &recordingMounter{mounted: map[string]bool{stagingPath: true}}
+	n := NewNodeWithMounter("node-id", "/tmp/test-csi.sock", mounter)
+	stubMountInfo(t, "1 0 0:42 / "+stagingPath+" rw,nosuid - fuse.sshfs host:/ rw\n"+
+		"2 0 0:42 / "+targetPath+" ro,nosuid - fuse.sshfs host:/ rw\n")
+
+	if _, err := n.NodePublishVolume(context.Background(), publishRequest(stagingPath, targetPath, true)); err != nil {
+		t.Fatalf("NodePublishVolume() error = %v", err)
+	}
+	if len(mounter.mounts) != 2 || mounter.mounts[0] != targetPath || mounter.mounts[1] != targetPath {
+		t.Fatalf("NodePublishVolume() mounts = %v, want bind and remount of %s", mounter.mounts, targetPath)
+	}
+	want := uintptr(syscall.MS_REMOUNT | syscall.MS_BIND | syscall.MS_RDONLY | syscall.MS_NOSUID)
+	if mounter.flags[0] != syscall.MS_BIND || mounter.flags[1] != want {
+		t.Fatalf("NodePublishVolume() flags = %#x, want [%#x %#x]", mounter.flags, syscall.MS_BIND, want)
+	}
+	rec, _ := n.state.get("test-volume")
+	if !rec.Publishes[targetPath].Readonly {
+		t.Fatalf("publish record readonly = false, want true")
+	}
+}
+
+func TestNodePublishVolumeReadonlyFailsWhenStillWritable(t *testing.T) {
+	stagingPath := t.TempDir()
+	targetPath := filepath.Join(t.TempDir(), "target")
+	mounter := &recordingMounter{mounted: map[string]bool{stagingPath: true}}
+	n := NewNodeWithMounter("node-id", "/tmp/test-csi.sock", mounter)
+	stubMountInfo(t, "2 0 0:42 / "+targetPath+" rw - fuse.sshfs host:/ rw\n")
+
+	_, err := n.NodePublishVolume(context.Background(), publishRequest(stagingPath, targetPath, true))
+	if status.Code(err) != codes.Internal {
+		t.Fatalf("NodePublishVolume() code = %v, want %v", status.Code(err), codes.Internal)
+	}
+	if len(mounter.unmounts) != 1 || mounter.unmounts[0] != targetPath {
+		t.Fatalf("NodePublishVolume() unmounts = %v, want [%s]", mounter.unmounts, targetPath)
+	}
+}
+
+func TestNodePublishVolumeReadonlyMismatchIsAlreadyExists(t *testing.T) {
+	stagingPath := t.TempDir()
+	targetPath := t.TempDir()
+	mounter := &recordingMounter{mounted: map[string]bool{stagingPath: true, targetPath: true}}
+	n := NewNodeWithMounter("node-id", "/tmp/test-csi.sock", mounter)
+	stubMountInfo(t, "2 0 0:42 / "+targetPath+" rw - fuse.sshfs host:/ rw\n")
+
+	_, err := n.NodePublishVolume(context.Background(), publishRequest(stagingPath, targetPath, true))
+	if status.Code(err) != codes.AlreadyExists {
+		t.Fatalf("NodePublishVolume() code = %v, want %v", status.Code(err), codes.AlreadyExists)
+	}
+	if _, err := n.NodePublishVolume(context.Background(), publishRequest(stagingPath, targetPath, false)); err != nil {
+		t.Fatalf("NodePublishVolume() with matching readonly error = %v", err)
+	}
+	if len(mounter.mounts) != 0 {
+		t.Fatalf("NodePublishVolume() mounts = %v, want none", mounter.mounts)
+	}
+}
+
+func TestPublishReadonly(t *testing.T) {
+	tests := []struct {
+		name     string
+		readonly bool
+		mode     csi.VolumeCapability_AccessMode_Mode
+		want     bool
+	}{
+		{name: "writer", mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER},
+		{name: "readonly flag", readonly: true, mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER, want: true},
+		{name: "single node reader", mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY, want: true},
+		{name: "multi node reader", mode: csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY, want: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := publishRequest("/stage", "/target", tc.readonly)
+			req.VolumeCapability.AccessMode.Mode = tc.mode
+			if got := publishReadonly(req); got != tc.want {
+				t.Fatalf("publishReadonly() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestNodePublishVolumeAppliesPerMountFlags(t *testing.T) {
+	stagingPath := t.TempDir()
+	targetPath := filepath.Join(t.TempDir(), "target")
+	mounter := &recordingMounter{mounted: map[string]bool{stagingPath: true}}
+	n := NewNodeWithMounter("node-id", "/tmp/test-csi.sock", mounter)
+	stubMountInfo(t, "")
+
+	req := publishRequest(stagingPath, targetPath, false)
+	req.VolumeCapability.GetMount().MountFlags = []string{"nosuid,allow_other", "noexec"}
+	req.VolumeContext = map[string]string{"mountOptions": "exec,nodev"}
+	if _, err := n.NodePublishVolume(context.Background(), req); err != nil {
+		t.Fatalf("NodePublishVolume() error = %v", err)
+	}
+	want := uintptr(syscall.MS_REMOUNT | syscall.MS_BIND | syscall.MS_NOSUID | syscall.MS_NODEV)
+	if len(mounter.flags) != 2 || mounter.flags[0] != syscall.MS_BIND || mounter.flags[1] != want {
+		t.Fatalf("NodePublishVolume() flags = %#x, want [%#x %#x]", mounter.flags, syscall.MS_BIND, want)
+	}
+	rec, _ := n.state.get("test-volume")
+	if pub := rec.Publishes[targetPath]; pub.Readonly || pub.MountFlags != syscall.MS_NOSUID|syscall.MS_NODEV {
+		t.Fatalf("publish record = %+v, want writable with nosuid,nodev", pub)
+	}
+}
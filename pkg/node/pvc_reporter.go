@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"strings"
+	"sync"
 	"time"
 
 	csi "github.com/container-storage-interface/spec/lib/go/csi"
@@ -27,12 +28,23 @@ const (
 type PVCReporter interface {
 	RepairStarted(ctx context.Context, req *csi.NodePublishVolumeRequest, reason, message string) error
 	RepairCompleted(ctx context.Context, req *csi.NodePublishVolumeRequest, reason, message string) error
+	// VolumeEvent records an event on the claim bound to volumeID. It is used
+	// where no pod is known, such as during staging.
+	VolumeEvent(ctx context.Context, volumeID, eventType, reason, message string) error
+	// VolumeCapacity returns the storage capacity of the PV for volumeID, or
+	// 0 when it has none. Node requests do not carry the capacity.
+	VolumeCapacity(ctx context.Context, volumeID string) (int64, error)
 }
 
 type KubernetesPVCReporter struct {
 	client     kubernetes.Interface
 	nodeID     string
 	driverName string
+
+	// pvNames caches the PV name found for a volume handle, so a PV not named
+	// after its handle is only searched for once.
+	pvNamesMu sync.Mutex
+	pvNames   map[string]string
 }
 
 type pvcRef struct {
@@ -96,6 +108,109 @@ func (r *KubernetesPVCReporter) RepairCompleted(
 	return r.createEvent(ctx, *ref, corev1.EventTypeNormal, reason, message)
 }
 
+func (r *KubernetesPVCReporter) VolumeEvent(
+	ctx context.Context,
+	volumeID string,
+	eventType string,
+	reason string,
+	message string,
+) error {
+	ref, err := r.resolveVolumeClaim(ctx, volumeID)
+	if err != nil {
+		return err
+	}
+	if ref == nil {
+		return nil
+	}
+	return r.createEvent(ctx, *ref, eventType, reason, message)
+}
+
+func (r *KubernetesPVCReporter) VolumeCapacity(ctx context.Context, volumeID string) (int64, error) {
+	pv, err := r.findVolume(ctx, volumeID)
+	if err != nil || pv == nil {
+		return 0, err
+	}
+	capacity, ok := pv.Spec.Capacity[corev1.ResourceStorage]
+	if !ok {
+		return 0, nil
+	}
+	return capacity.Value(), nil
+}
+
+// findVolume finds the PV of this driver with volumeID as its handle. It gets
+// the PV by the name it was last found under, or by the handle itself, since
+// static PVs are often named after it, and only lists every PV when that
+// misses. Lists are served from the API server cache.
+func (r *KubernetesPVCReporter) findVolume(ctx context.Context, volumeID string) (*corev1.PersistentVolume, error) {
+	name := r.cachedPVName(volumeID)
+	candidate, err := r.client.CoreV1().PersistentVolumes().Get(ctx, name, metav1.GetOptions{})
+	switch {
+	case err == nil && r.ownsVolume(candidate, volumeID):
+		r.cachePVName(volumeID, name)
+		return candidate, nil
+	case err != nil && !apierrors.IsNotFound(err):
+		return nil, fmt.Errorf("get pv %s: %w", name, err)
+	}
+	pvs, err := r.client.CoreV1().PersistentVolumes().List(ctx, metav1.ListOptions{ResourceVersion: "0"})
+	if err != nil {
+		return nil, fmt.Errorf("list pvs: %w", err)
+	}
+	for i := range pvs.Items {
+		if r.ownsVolume(&pvs.Items[i], volumeID) {
+			r.cachePVName(volumeID, pvs.Items[i].Name)
+			return &pvs.Items[i], nil
+		}
+	}
+	r.cachePVName(volumeID, "")
+	return nil, nil
+}
+
+// cachedPVName returns the name volumeID's PV was last found under, or
+// volumeID when there is none.
+func (r *KubernetesPVCReporter) cachedPVName(volumeID string) string {
+	r.pvNamesMu.Lock()
+	defer r.pvNamesMu.Unlock()
+	if name, ok := r.pvNames[volumeID]; ok {
+		return name
+	}
+	return volumeID
+}
+
+// cachePVName records the PV name for volumeID, or forgets it when name is "".
+func (r *KubernetesPVCReporter) cachePVName(volumeID, name string) {
+	r.pvNamesMu.Lock()
+	defer r.pvNamesMu.Unlock()
+	if name == "" || name == volumeID {
+		delete(r.pvNames, volumeID)
+		return
+	}
+	if r.pvNames == nil {
+		r.pvNames = map[string]string{}
+	}
+	r.pvNames[volumeID] = name
+}
+
+// resolveVolumeClaim finds the claim bound to the PV of this driver with
+// volumeID as its handle.
+func (r *KubernetesPVCReporter) resolveVolumeClaim(ctx context.Context, volumeID string) (*pvcRef, error) {
+	pv, err := r.findVolume(ctx, volumeID)
+	if err != nil {
+		return nil, err
+	}
+	if pv == nil || pv.Spec.ClaimRef == nil {
+		return nil, nil
+	}
+	return &pvcRef{
+		namespace: pv.Spec.ClaimRef.Namespace,
+		name:      pv.Spec.ClaimRef.Name,
+		uid:       pv.Spec.ClaimRef.UID,
+	}, nil
+}
+
+func (r *KubernetesPVCReporter) ownsVolume(pv *corev1.PersistentVolume, volumeID string) bool {
+	return pv.Spec.CSI != nil && pv.Spec.CSI.Driver == r.driverName && pv.Spec.CSI.VolumeHandle == volumeID
+}
+
 func (r *KubernetesPVCReporter) resolvePVC(ctx context.Context, req *csi.NodePublishVolumeRequest) (*pvcRef, error) {
 	volumeID := req.GetVolumeId()
 	podName := strings.TrimSpace(req.GetVolumeContext()[podNameContextKey])
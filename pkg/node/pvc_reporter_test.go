@@ -6,6 +6,7 @@ import (
 
 	csi "github.com/container-storage-interface/spec/lib/go/csi"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes/fake"
@@ -213,6 +214,125 @@ func TestKubernetesPVCReporterSkipsNonJustmountVolume(t *testing.T) {
 	}
 }
 
+func TestKubernetesPVCReporterVolumeEventFindsBoundClaim(t *testing.T) {
+	ctx := context.Background()
+	client := fake.NewSimpleClientset(
+		&corev1.PersistentVolume{
+			// Not named after its handle, so the claim is found by listing.
+			ObjectMeta: metav1.ObjectMeta{Name: "app-pv"},
+			Spec: corev1.PersistentVolumeSpec{
+				PersistentVolumeSource: corev1.PersistentVolumeSource{
+					CSI: &corev1.CSIPersistentVolumeSource{
+						Driver:       driverName,
+						VolumeHandle: "volume-handle",
+					},
+				},
+				ClaimRef: &corev1.ObjectReference{
+					Namespace: "apps",
+					Name:      "app-data",
+					UID:       types.UID("pvc-uid"),
+				},
+			},
+		},
+	)
+	reporter := &KubernetesPVCReporter{
+		client:     client,
+		nodeID:     "node-a",
+		driverName: driverName,
+	}
+
+	if err := reporter.VolumeEvent(ctx, "volume-handle", corev1.EventTypeWarning, "JustmountFilesystemRepaired", "repaired"); err != nil {
+		t.Fatalf("VolumeEvent() error = %v, want nil", err)
+	}
+	events, err := client.CoreV1().Events("apps").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("list events: %v", err)
+	}
+	if len(events.Items) != 1 {
+		t.Fatalf("event count = %d, want 1", len(events.Items))
+	}
+	event := events.Items[0]
+	if event.InvolvedObject.Name != "app-data" || event.InvolvedObject.UID != "pvc-uid" || event.Reason != "JustmountFilesystemRepaired" {
+		t.Fatalf("event = %s %s/%s, want JustmountFilesystemRepaired on app-data", event.Reason, event.InvolvedObject.Kind, event.InvolvedObject.Name)
+	}
+
+	if err := reporter.VolumeEvent(ctx, "unknown-handle", corev1.EventTypeWarning, "Reason", "message"); err != nil {
+		t.Fatalf("VolumeEvent() for unknown volume error = %v, want nil", err)
+	}
+}
+
+func TestKubernetesPVCReporterVolumeCapacity(t *testing.T) {
+	ctx := context.Background()
+	client := fake.NewSimpleClientset(
+		&corev1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{Name: "scratch-volume"},
+			Spec: corev1.PersistentVolumeSpec{
+				Capacity: corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("1Gi")},
+				PersistentVolumeSource: corev1.PersistentVolumeSource{
+					CSI: &corev1.CSIPersistentVolumeSource{
+						Driver:       driverName,
+						VolumeHandle: "scratch-volume",
+					},
+				},
+			},
+		},
+	)
+	reporter := &KubernetesPVCReporter{
+		client:     client,
+		nodeID:     "node-a",
+		driverName: driverName,
+	}
+
+	capacity, err := reporter.VolumeCapacity(ctx, "scratch-volume")
+	if err != nil || capacity != 1<<30 {
+		t.Fatalf("VolumeCapacity() = %d, %v, want %d", capacity, err, 1<<30)
+	}
+	capacity, err = reporter.VolumeCapacity(ctx, "unknown-handle")
+	if err != nil || capacity != 0 {
+		t.Fatalf("VolumeCapacity() for unknown volume = %d, %v, want 0", capacity, err)
+	}
+}
+
+func TestKubernetesPVCReporterCachesPVName(t *testing.T) {
+	ctx := context.Background()
+	client := fake.NewSimpleClientset(
+		&corev1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{Name: "pv-scratch"},
+			Spec: corev1.PersistentVolumeSpec{
+				Capacity: corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("1Gi")},
+				PersistentVolumeSource: corev1.PersistentVolumeSource{
+					CSI: &corev1.CSIPersistentVolumeSource{
+						Driver:       driverName,
+						VolumeHandle: "scratch-handle",
+					},
+				},
+			},
+		},
+	)
+	reporter := &KubernetesPVCReporter{
+		client:     client,
+		nodeID:     "node-a",
+		driverName: driverName,
+	}
+
+	for i := 0; i < 2; i++ {
+		client.ClearActions()
+		capacity, err := reporter.VolumeCapacity(ctx, "scratch-handle")
+		if err != nil || capacity != 1<<30 {
+			t.Fatalf("VolumeCapacity() = %d, %v, want %d", capacity, err, 1<<30)
+		}
+		var lists int
+		for _, action := range client.Actions() {
+			if action.GetVerb() == "list" {
+				lists++
+			}
+		}
+		if want := 1 - i; lists != want {
+			t.Fatalf("VolumeCapacity() call %d listed PVs %d times, want %d", i+1, lists, want)
+		}
+	}
+}
+
 func TestSetPVCConditionPreservesTransitionTimeForUnchangedStatus(t *testing.T) {
 	oldTransition := metav1.Now()
 	newProbe := metav1.NewTime(oldTransition.Add(1))
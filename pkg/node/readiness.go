@@ -0,0 +1,85 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// defaultMountReadyTimeout bounds how long a new mount may take to appear
+// when neither the request nor WithMountReadyTimeout set a shorter deadline.
+const defaultMountReadyTimeout = 30 * time.Second
+
+const (
+	mountReadyInitialInterval = 10 * time.Millisecond
+	mountReadyMaxInterval     = 250 * time.Millisecond
+)
+
+var errMountNotReady = errors.New("mount did not become ready")
+
+// waitForMount polls until path is a mount point and, when probe is set,
+// answers a stat. It returns as soon as the mount is usable and gives up at
+// the earlier of the context deadline and the node's mount ready timeout,
+// returning DeadlineExceeded with the last observed problem.
+func (n *Node) waitForMount(ctx context.Context, path string, probe bool) error {
+	timeout := n.mountReadyTimeout
+	if timeout <= 0 {
+		timeout = defaultMountReadyTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	interval := mountReadyInitialInterval
+	for {
+		lastErr := n.checkMountReady(ctx, path, probe)
+		if lastErr == nil {
+			Logger(ctx).Info("mount is ready",
+				zap.String("path", path),
+				zap.Duration("elapsed", time.Since(start)),
+			)
+			return nil
+		}
+
+		timer := time.NewTimer(interval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			logMountInfo(ctx, path, "mount not ready")
+			return status.Errorf(codes.DeadlineExceeded, "mount at %s did not become ready after %s: %v",
+				path, time.Since(start).Round(time.Millisecond), lastErr)
+		case <-timer.C:
+		}
+		interval = min(interval*2, mountReadyMaxInterval)
+	}
+}
+
+func (n *Node) checkMountReady(ctx context.Context, path string, probe bool) error {
+	mounted, err := n.mounter.IsMountPoint(path)
+	if err != nil {
+		return err
+	}
+	if !mounted {
+		return fmt.Errorf("%w: %s is not a mount point", errMountNotReady, path)
+	}
+	if !probe {
+		return nil
+	}
+	remaining := time.Until(deadlineOf(ctx))
+	if remaining <= 0 {
+		return fmt.Errorf("%w: no time left to probe %s", errMountNotReady, path)
+	}
+	return n.probeMountPathWithTimeout(path, remaining)
+}
+
+func deadlineOf(ctx context.Context) time.Time {
+	if deadline, ok := ctx.Deadline(); ok {
+		return deadline
+	}
+	return time.Now().Add(defaultMountReadyTimeout)
+}
@@ -0,0 +1,109 @@
+package node
+
+import (
+	"context"
+	"syscall"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// delayedMounter reports path as mounted after a number of IsMountPoint calls.
+type delayedMounter struct {
+	recordingMounter
+	path  string
+	after int
+	calls int
+}
+
+func (m *delayedMounter) IsMountPoint(path string) (bool, error) {
+	if path != m.path {
+		return false, nil
+	}
+	m.calls++
+	return m.calls > m.after, nil
+}
+
+func TestWaitForMountReturnsOnceMounted(t *testing.T) {
+	path := t.TempDir()
+	mounter := &delayedMounter{path: path, after: 3}
+	n := NewNodeWithMounter("node-id", "/tmp/test-csi.sock", mounter, WithMountReadyTimeout(5*time.Second))
+
+	start := time.Now()
+	if err := n.waitForMount(context.Background(), path, true); err != nil {
+		t.Fatalf("waitForMount() error = %v, want nil", err)
+	}
+	if mounter.calls != 4 {
+		t.Fatalf("waitForMount() checked %d times, want 4", mounter.calls)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("waitForMount() took %s, want well under the timeout", elapsed)
+	}
+}
+
+func TestWaitForMountWaitsForProbe(t *testing.T) {
+	path := t.TempDir()
+	mounter := &recordingMounter{mounted: map[string]bool{path: true}}
+	n := NewNodeWithMounter("node-id", "/tmp/test-csi.sock", mounter, WithMountReadyTimeout(5*time.Second))
+
+	probes := 0
+	origProbeMountPath := probeMountPath
+	probeMountPath = func(string) error {
+		probes++
+		if probes < 3 {
+			return syscall.ENOTCONN
+		}
+		return nil
+	}
+	t.Cleanup(func() { probeMountPath = origProbeMountPath })
+
+	if err := n.waitForMount(context.Background(), path, true); err != nil {
+		t.Fatalf("waitForMount() error = %v, want nil", err)
+	}
+	if probes != 3 {
+		t.Fatalf("waitForMount() probed %d times, want 3", probes)
+	}
+}
+
+func TestWaitForMountTimesOut(t *testing.T) {
+	path := t.TempDir()
+	stubMountInfo(t, "")
+
+	tests := []struct {
+		name    string
+		timeout time.Duration
+		ctx     func() (context.Context, context.CancelFunc)
+	}{
+		{
+			name:    "node timeout",
+			timeout: 50 * time.Millisecond,
+			ctx:     func() (context.Context, context.CancelFunc) { return context.WithCancel(context.Background()) },
+		},
+		{
+			name:    "request deadline",
+			timeout: time.Minute,
+			ctx: func() (context.Context, context.CancelFunc) {
+				return context.WithTimeout(context.Background(), 50*time.Millisecond)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mounter := &recordingMounter{mounted: map[string]bool{}}
+			n := NewNodeWithMounter("node-id", "/tmp/test-csi.sock", mounter, WithMountReadyTimeout(tt.timeout))
+			ctx, cancel := tt.ctx()
+			defer cancel()
+
+			start := time.Now()
+			err := n.waitForMount(ctx, path, false)
+			if status.Code(err) != codes.DeadlineExceeded {
+				t.Fatalf("waitForMount() error = %v, want DeadlineExceeded", err)
+			}
+			if elapsed := time.Since(start); elapsed > 5*time.Second {
+				t.Fatalf("waitForMount() took %s, want it bounded by the shorter deadline", elapsed)
+			}
+		})
+	}
+}
@@ -15,12 +15,17 @@ import (
 type recordingMounter struct {
 	mounted  map[string]bool
 	mounts   []string
+	sources  []string
+	data     []string
+	flags    []uintptr
 	unmounts []string
 }
 
 type recordingPVCReporter struct {
 	started   []string
 	completed []string
+	events    []string
+	capacity  int64
 }
 
 func (r *recordingPVCReporter) RepairStarted(ctx context.Context, req *csi.NodePublishVolumeRequest, reason, message string) error {
@@ -33,9 +38,21 @@ func (r *recordingPVCReporter) RepairCompleted(ctx context.Context, req *csi.Nod
 	return nil
 }
 
+func (r *recordingPVCReporter) VolumeEvent(ctx context.Context, volumeID, eventType, reason, message string) error {
+	r.events = append(r.events, reason)
+	return nil
+}
+
+func (r *recordingPVCReporter) VolumeCapacity(ctx context.Context, volumeID string) (int64, error) {
+	return r.capacity, nil
+}
+
 func (m *recordingMounter) Mount(source, target, fstype string, flags uintptr, data string) error {
 	m.mounted[target] = true
 	m.mounts = append(m.mounts, target)
+	m.sources = append(m.sources, source)
+	m.data = append(m.data, data)
+	m.flags = append(m.flags, flags)
 	return nil
 }
 
@@ -65,7 +82,8 @@ func TestNodeStageVolumeReplacesDisconnectedStagingAndDependentBinds(t *testing.
 
 	origProbeMountPath := probeMountPath
 	probeMountPath = func(path string) error {
-		if path == stagingPath {
+		// The replacement mount answers once it is in place.
+		if path == stagingPath && len(mounter.mounts) == 0 {
 			return syscall.ENOTCONN
 		}
 		return nil
@@ -194,7 +212,8 @@ func TestNodePublishVolumeUnstagesDisconnectedStagingAndDependentBinds(t *testin
 
 	origProbeMountPath := probeMountPath
 	probeMountPath = func(path string) error {
-		if path == stagingPath {
+		// The replacement mount answers once it is in place.
+		if path == stagingPath && len(mounter.mounts) == 0 {
 			return syscall.ENOTCONN
 		}
 		return nil
@@ -298,3 +317,87 @@ func TestNodeGetVolumeStatsReportsHealthyVolumeCondition(t *testing.T) {
 		t.Fatalf("NodeGetVolumeStats() usage entries = 0, want at least one")
 	}
 }
+
+func TestNodePublishVolumeRemountsDisconnectedStagingInPlace(t *testing.T) {
+	stagingPath := t.TempDir()
+	podTarget := t.TempDir()
+	publishTarget := t.TempDir()
+
+	mounter := &recordingMounter{
+		mounted: map[string]bool{
+			stagingPath: true,
+			podTarget:   true,
+		},
+	}
+	n := NewNodeWithMounter("node-id", "/tmp/test-csi.sock", mounter)
+	reporter := &recordingPVCReporter{}
+	n.pvcReporter = reporter
+	if err := n.state.putStage(volumeRecord{
+		VolumeID:          "test-volume",
+		StagingTargetPath: stagingPath,
+		Source:            "gluster:media",
+		FsType:            "glusterfs",
+		MountOptions:      "rw",
+		VolumeContext:     map[string]string{repairModeContextKey: repairModeRemount},
+	}); err != nil {
+		t.Fatalf("putStage() error = %v", err)
+	}
+
+	origProbeMountPath := probeMountPath
+	probeMountPath = func(path string) error {
+		// The replacement mount answers once it is in place.
+		if path == stagingPath && len(mounter.mounts) == 0 {
+			return syscall.ENOTCONN
+		}
+		return nil
+	}
+	t.Cleanup(func() { probeMountPath = origProbeMountPath })
+
+	origReadMountInfo := readMountInfo
+	readMountInfo = func() ([]byte, error) {
+		return []byte(
+			"1 0 0:42 / " + stagingPath + " rw - fuse.glusterfs gluster:media rw\n" +
+				"2 0 0:42 / " + podTarget + " rw - fuse.glusterfs gluster:media rw\n",
+		), nil
+	}
+	t.Cleanup(func() { readMountInfo = origReadMountInfo })
+
+	req := &csi.NodePublishVolumeRequest{
+		VolumeId:          "test-volume",
+		StagingTargetPath: stagingPath,
+		TargetPath:        publishTarget,
+		VolumeCapability: &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Mount{
+				Mount: &csi.VolumeCapability_MountVolume{FsType: "glusterfs"},
+			},
+		},
+		VolumeContext: map[string]string{repairModeContextKey: repairModeRemount},
+	}
+
+	if _, err := n.NodePublishVolume(context.Background(), req); err != nil {
+		t.Fatalf("NodePublishVolume() error = %v, want nil", err)
+	}
+
+	wantUnmounts := []string{podTarget, stagingPath}
+	if len(mounter.unmounts) != len(wantUnmounts) || mounter.unmounts[0] != wantUnmounts[0] || mounter.unmounts[1] != wantUnmounts[1] {
+		t.Fatalf("NodePublishVolume() unmounts = %v, want %v", mounter.unmounts, wantUnmounts)
+	}
+	wantMounts := []string{stagingPath, podTarget, publishTarget}
+	if len(mounter.mounts) != len(wantMounts) {
+		t.Fatalf("NodePublishVolume() mounts = %v, want %v", mounter.mounts, wantMounts)
+	}
+	for i := range wantMounts {
+		if mounter.mounts[i] != wantMounts[i] {
+			t.Errorf("NodePublishVolume() mounts[%d] = %q, want %q", i, mounter.mounts[i], wantMounts[i])
+		}
+	}
+	if mounter.sources[0] != "gluster:media" {
+		t.Errorf("NodePublishVolume() staging remount source = %q, want gluster:media", mounter.sources[0])
+	}
+	if len(reporter.started) != 1 || reporter.started[0] != "JustmountStagingMountDisconnected" {
+		t.Fatalf("NodePublishVolume() repair start reports = %v, want [JustmountStagingMountDisconnected]", reporter.started)
+	}
+	if len(reporter.completed) != 1 || reporter.completed[0] != "JustmountStagingMountRemounted" {
+		t.Fatalf("NodePublishVolume() repair completion reports = %v, want [JustmountStagingMountRemounted]", reporter.completed)
+	}
+}
@@ -0,0 +1,287 @@
+package node
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"syscall"
+
+	"github.com/joejulian/csi-justmount/pkg/mountopts"
+	"go.uber.org/zap"
+)
+
+// restoreTarget is a bind mount that has to be re-established from a staging
+// mount after the staging mount is recreated.
+type restoreTarget struct {
+	// source is the staging path, or the device of a block volume.
+	source string
+	// subDir is the directory below source that is bound, opened without
+	// following symlinks, or "" for source itself.
+	subDir string
+	target string
+	flags  uintptr
+}
+
+// restoreVolumes re-stages recorded volumes whose staging mount was lost or
+// disconnected while the plugin was down, then re-binds their publish targets
+// so running pods regain their data without being recreated.
+func (n *Node) restoreVolumes(ctx context.Context) {
+	for _, rec := range n.state.list() {
+		l := BaseLogger().With(
+			zap.String("component", "restore"),
+			zap.String("volume_id", rec.VolumeID),
+			zap.String("staging_target_path", rec.StagingTargetPath),
+		)
+		release, holder, ok := n.locks.tryAcquire("restore", volumeLockKey(rec.VolumeID), pathLockKey(rec.StagingTargetPath))
+		if !ok {
+			l.Warn("skipping restore of volume with operation in progress", zap.String("in_progress", holder))
+			continue
+		}
+		if err := n.restoreVolume(withLogger(ctx, l), rec); err != nil {
+			l.Error("failed to restore volume", zap.Error(err))
+		}
+		release()
+	}
+}
+
+func (n *Node) restoreVolume(ctx context.Context, rec volumeRecord) error {
+	if rec.Block {
+		// Raw block publishes bind the device itself and survive independently
+		// of any staging mount.
+		return n.restorePublishes(ctx, recordedRestoreTargets(rec))
+	}
+	if len(rec.Layers) > 0 {
+		return n.restoreOverlayLayers(ctx, rec)
+	}
+	if rec.Source == "" || rec.FsType == "" {
+		Logger(ctx).Info("skipping restore for volume without recorded stage parameters")
+		return nil
+	}
+
+	stagingPath := rec.StagingTargetPath
+	isMounted, err := n.mounter.IsMountPoint(stagingPath)
+	if err != nil {
+		return fmt.Errorf("verify staging mountpoint: %w", err)
+	}
+	if isMounted {
+		probeErr := probeMountPath(stagingPath)
+		if probeErr == nil {
+			return n.restorePublishes(ctx, mergeRestoreTargets(recordedRestoreTargets(rec), nil))
+		}
+		if !isDisconnectedMountError(probeErr) {
+			return fmt.Errorf("staging path is mounted but not usable: %w", probeErr)
+		}
+		Logger(ctx).Warn("restoring disconnected staging mount", zap.Error(probeErr))
+	} else {
+		Logger(ctx).Warn("restoring missing staging mount")
+	}
+
+	reqs := rec.publishRequests()
+	for _, req := range reqs {
+		n.reportRepairStarted(ctx, req, "JustmountStagingMountRestoring",
+			"Justmount staging mount was lost across a plugin restart; re-staging and re-binding publish targets")
+	}
+	if err := n.remountStaging(ctx, rec, isMounted); err != nil {
+		return err
+	}
+	for _, req := range reqs {
+		n.reportRepairCompleted(ctx, req, "JustmountStagingMountRestored",
+			"Justmount staging mount and publish targets were restored after a plugin restart")
+	}
+	return nil
+}
+
+// restoreOverlayLayers remounts the lower layers of an overlay volume that
+// were lost while the plugin was down. Overlays published from them reference
+// the old layer mounts and are left for kubelet to republish.
+func (n *Node) restoreOverlayLayers(ctx context.Context, rec volumeRecord) error {
+	if err := n.mountOverlayLayers(ctx, &rec); err != nil {
+		return err
+	}
+	if err := n.state.putStage(rec); err != nil {
+		return fmt.Errorf("record overlay layers: %w", err)
+	}
+	return nil
+}
+
+// remountStaging replaces a lost or disconnected staging mount using the
+// recorded stage parameters and re-binds every publish target that depended on
+// it at its original path.
+func (n *Node) remountStaging(ctx context.Context, rec volumeRecord, mounted bool) error {
+	stagingPath := rec.StagingTargetPath
+	targets := recordedRestoreTargets(rec)
+	if mounted {
+		dependents, err := n.dependentRestoreTargets(stagingPath)
+		if err != nil {
+			return fmt.Errorf("read dependent mounts: %w", err)
+		}
+		targets = mergeRestoreTargets(targets, dependents)
+		if err := n.unmountDependentMounts(ctx, stagingPath); err != nil {
+			return fmt.Errorf("unmount dependent bind mounts: %w", err)
+		}
+		if err := n.unmountAllAtPath(ctx, stagingPath); err != nil {
+			return fmt.Errorf("unmount disconnected staging mount: %w", err)
+		}
+	} else {
+		targets = mergeRestoreTargets(targets, nil)
+	}
+
+	if err := os.MkdirAll(stagingPath, 0755); err != nil {
+		return fmt.Errorf("create staging path: %w", err)
+	}
+	loopDevice := rec.LoopDevice
+	if err := n.mountStaging(ctx, &rec); err != nil {
+		return err
+	}
+	if rec.LoopDevice != loopDevice {
+		if err := n.state.putStage(rec); err != nil {
+			return fmt.Errorf("record loop device: %w", err)
+		}
+	}
+	if err := applyStagingPermissions(ctx, rec); err != nil {
+		Logger(ctx).Warn("failed to re-apply ownership to remounted staging path", zap.Error(err))
+	}
+	if err := n.applyMountGroup(ctx, rec); err != nil {
+		Logger(ctx).Warn("failed to re-apply volume mount group to remounted staging path", zap.Error(err))
+	}
+	if err := n.restorePublishes(ctx, targets); err != nil {
+		return err
+	}
+	Logger(ctx).Info("remounted staging path", zap.Int("publish_targets", len(targets)))
+	return nil
+}
+
+// restorePublishes bind-mounts each target that is missing or disconnected.
+// Targets are bound parents first so nested subPath mounts land on top.
+func (n *Node) restorePublishes(ctx context.Context, targets []restoreTarget) error {
+	for _, t := range targets {
+		isMounted, err := n.mounter.IsMountPoint(t.target)
+		if err != nil {
+			if os.IsNotExist(err) {
+				Logger(ctx).Info("skipping restore of removed publish target", zap.String("target_path", t.target))
+				continue
+			}
+			return fmt.Errorf("verify publish target mountpoint %q: %w", t.target, err)
+		}
+		if isMounted {
+			probeErr := probeMountPath(t.target)
+			if probeErr == nil {
+				continue
+			}
+			if !isDisconnectedMountError(probeErr) {
+				return fmt.Errorf("publish target %q is mounted but not usable: %w", t.target, probeErr)
+			}
+			if err := n.unmountAllAtPath(ctx, t.target); err != nil {
+				return fmt.Errorf("unmount disconnected publish target %q: %w", t.target, err)
+			}
+		}
+		if _, err := os.Stat(t.target); os.IsNotExist(err) {
+			// The pod is gone; kubelet will clean up its volume.
+			Logger(ctx).Info("skipping restore of removed publish target", zap.String("target_path", t.target))
+			continue
+		}
+		if err := n.bindRestoreTarget(ctx, t); err != nil {
+			return err
+		}
+		Logger(ctx).Info("restored publish target",
+			zap.String("source", t.source),
+			zap.String("sub_dir", t.subDir),
+			zap.String("target_path", t.target),
+		)
+	}
+	return nil
+}
+
+// bindRestoreTarget binds t.source, or its subDir opened the same way
+// NodePublishVolume opens it, onto t.target.
+func (n *Node) bindRestoreTarget(ctx context.Context, t restoreTarget) error {
+	source := t.source
+	if t.subDir != "" {
+		dir, err := openSubDir(t.source, subDirOptions{path: t.subDir})
+		if err != nil {
+			return fmt.Errorf("open subDir %q for %q: %w", t.subDir, t.target, err)
+		}
+		defer func() { _ = dir.Close() }()
+		source = subDirBindSource(dir)
+	}
+	if err := n.bindMount(ctx, source, t.target, t.flags); err != nil {
+		return fmt.Errorf("bind-mount %q to %q: %w", filepath.Join(t.source, t.subDir), t.target, err)
+	}
+	return nil
+}
+
+func recordedRestoreTargets(rec volumeRecord) []restoreTarget {
+	var targets []restoreTarget
+	for _, req := range rec.publishRequests() {
+		pub := rec.Publishes[req.GetTargetPath()]
+		flags := pub.MountFlags
+		if pub.Readonly {
+			flags |= syscall.MS_RDONLY
+		}
+		t := restoreTarget{
+			source: rec.StagingTargetPath,
+			target: filepath.Clean(req.GetTargetPath()),
+			flags:  flags,
+		}
+		if rec.Block {
+			t.source = rec.Source
+		} else if subDir, err := parseSubDirOptions(pub.VolumeContext); err == nil {
+			t.subDir = subDir.path
+		}
+		targets = append(targets, t)
+	}
+	return targets
+}
+
+func (n *Node) dependentRestoreTargets(stagingPath string) ([]restoreTarget, error) {
+	stagingEntry, ok, err := mountInfoEntryForPath(stagingPath)
+	if err != nil || !ok {
+		return nil, err
+	}
+	dependents, err := n.dependentMounts(stagingEntry)
+	if err != nil {
+		return nil, err
+	}
+	targets := make([]restoreTarget, 0, len(dependents))
+	for _, dependent := range dependents {
+		var flags uintptr
+		if parsed, err := mountopts.Parse(dependent.Options); err == nil {
+			flags = parsed.Flags & mountopts.PerMountFlags
+		}
+		targets = append(targets, restoreTarget{
+			source: stagingPath,
+			subDir: bindSubDir(stagingEntry, dependent),
+			target: dependent.MountPoint,
+			flags:  flags,
+		})
+	}
+	return targets, nil
+}
+
+// mergeRestoreTargets combines recorded and discovered targets, preferring the
+// discovered bind source, ordered so parents are mounted before children.
+func mergeRestoreTargets(recorded, discovered []restoreTarget) []restoreTarget {
+	byTarget := map[string]restoreTarget{}
+	for _, t := range recorded {
+		byTarget[t.target] = t
+	}
+	for _, t := range discovered {
+		if prev, ok := byTarget[t.target]; ok {
+			t.flags |= prev.flags
+		}
+		byTarget[t.target] = t
+	}
+	merged := make([]restoreTarget, 0, len(byTarget))
+	for _, t := range byTarget {
+		merged = append(merged, t)
+	}
+	sort.Slice(merged, func(i, j int) bool {
+		if len(merged[i].target) != len(merged[j].target) {
+			return len(merged[i].target) < len(merged[j].target)
+		}
+		return merged[i].target < merged[j].target
+	})
+	return merged
+}
@@ -0,0 +1,182 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestRestoreVolumeRestagesDisconnectedStagingAndRebindsTargets(t *testing.T) {
+	stagingPath := t.TempDir()
+	podTarget := filepath.Join(t.TempDir(), "pod-target")
+	subPathTarget := filepath.Join(t.TempDir(), "volume-subpaths", "media", "app", "0")
+	for _, dir := range []string{podTarget, subPathTarget, filepath.Join(stagingPath, "projects", "2026")} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("create %s: %v", dir, err)
+		}
+	}
+
+	mounter := &recordingMounter{
+		mounted: map[string]bool{
+			stagingPath:   true,
+			podTarget:     true,
+			subPathTarget: true,
+		},
+	}
+	n := NewNodeWithMounter("node-id", "/tmp/test-csi.sock", mounter)
+	reporter := &recordingPVCReporter{}
+	n.pvcReporter = reporter
+
+	rec := volumeRecord{
+		VolumeID:          "test-volume",
+		StagingTargetPath: stagingPath,
+		Source:            "gluster:media",
+		FsType:            "glusterfs",
+		FileMode:          "0755",
+	}
+	if err := n.state.putStage(rec); err != nil {
+		t.Fatalf("putStage() error = %v", err)
+	}
+	if err := n.state.putPublish(rec.VolumeID, stagingPath, publishRecord{TargetPath: podTarget}); err != nil {
+		t.Fatalf("putPublish() error = %v", err)
+	}
+
+	origProbeMountPath := probeMountPath
+	probeMountPath = func(path string) error {
+		// The replacement mount answers once it is in place.
+		if path == stagingPath && len(mounter.mounts) == 0 {
+			return syscall.ENOTCONN
+		}
+		return nil
+	}
+	t.Cleanup(func() { probeMountPath = origProbeMountPath })
+
+	origReadMountInfo := readMountInfo
+	readMountInfo = func() ([]byte, error) {
+		return []byte(
+			"1 0 0:42 / " + stagingPath + " rw - fuse.glusterfs gluster:media rw\n" +
+				"2 0 0:42 / " + podTarget + " rw - fuse.glusterfs gluster:media rw\n" +
+				"3 0 0:42 /projects/2026 " + subPathTarget + " rw - fuse.glusterfs gluster:media rw\n",
+		), nil
+	}
+	t.Cleanup(func() { readMountInfo = origReadMountInfo })
+
+	origMountHelper := mountHelper
+	mountHelper = func(_ context.Context, fsType, source, target, opts string) (string, error) {
+		t.Fatalf("mount helper called for %s", target)
+		return "", nil
+	}
+	t.Cleanup(func() { mountHelper = origMountHelper })
+
+	n.restoreVolumes(context.Background())
+
+	wantUnmounts := []string{subPathTarget, podTarget, stagingPath}
+	if len(mounter.unmounts) != len(wantUnmounts) {
+		t.Fatalf("restoreVolumes() unmounts = %v, want %v", mounter.unmounts, wantUnmounts)
+	}
+	for i := range wantUnmounts {
+		if mounter.unmounts[i] != wantUnmounts[i] {
+			t.Errorf("restoreVolumes() unmounts[%d] = %q, want %q", i, mounter.unmounts[i], wantUnmounts[i])
+		}
+	}
+
+	wantMounts := []string{stagingPath, podTarget, subPathTarget}
+	// The subPath directory is opened without following symlinks and bound
+	// from its descriptor.
+	wantSources := []string{"gluster:media", stagingPath, "/proc/self/fd/"}
+	if len(mounter.mounts) != len(wantMounts) {
+		t.Fatalf("restoreVolumes() mounts = %v, want %v", mounter.mounts, wantMounts)
+	}
+	for i := range wantMounts {
+		if mounter.mounts[i] != wantMounts[i] || !strings.HasPrefix(mounter.sources[i], wantSources[i]) {
+			t.Errorf("restoreVolumes() mount[%d] = %q -> %q, want %q -> %q",
+				i, mounter.sources[i], mounter.mounts[i], wantSources[i], wantMounts[i])
+		}
+	}
+	if len(reporter.started) != 1 || reporter.started[0] != "JustmountStagingMountRestoring" {
+		t.Fatalf("restoreVolumes() repair start reports = %v, want [JustmountStagingMountRestoring]", reporter.started)
+	}
+	if len(reporter.completed) != 1 || reporter.completed[0] != "JustmountStagingMountRestored" {
+		t.Fatalf("restoreVolumes() repair completion reports = %v, want [JustmountStagingMountRestored]", reporter.completed)
+	}
+}
+
+func TestRestoreVolumeRemountsMissingStagingAndSkipsRemovedTargets(t *testing.T) {
+	stagingPath := filepath.Join(t.TempDir(), "stage")
+	liveTarget := t.TempDir()
+	removedTarget := filepath.Join(t.TempDir(), "removed")
+
+	mounter := &recordingMounter{mounted: map[string]bool{}}
+	n := NewNodeWithMounter("node-id", "/tmp/test-csi.sock", mounter)
+	rec := volumeRecord{
+		VolumeID:          "test-volume",
+		StagingTargetPath: stagingPath,
+		Source:            "tmpfs",
+		FsType:            "tmpfs",
+	}
+	if err := n.state.putStage(rec); err != nil {
+		t.Fatalf("putStage() error = %v", err)
+	}
+	for _, target := range []string{liveTarget, removedTarget} {
+		if err := n.state.putPublish(rec.VolumeID, stagingPath, publishRecord{TargetPath: target}); err != nil {
+			t.Fatalf("putPublish() error = %v", err)
+		}
+	}
+
+	rec, _ = n.state.get(rec.VolumeID)
+	if err := n.restoreVolume(context.Background(), rec); err != nil {
+		t.Fatalf("restoreVolume() error = %v, want nil", err)
+	}
+	if len(mounter.unmounts) != 0 {
+		t.Fatalf("restoreVolume() unmounts = %v, want none", mounter.unmounts)
+	}
+	wantMounts := []string{stagingPath, liveTarget}
+	if len(mounter.mounts) != len(wantMounts) || mounter.mounts[0] != wantMounts[0] || mounter.mounts[1] != wantMounts[1] {
+		t.Fatalf("restoreVolume() mounts = %v, want %v", mounter.mounts, wantMounts)
+	}
+}
+
+func TestRestoreVolumeRefusesSymlinkedSubDir(t *testing.T) {
+	stagingPath := filepath.Join(t.TempDir(), "stage")
+	outside := t.TempDir()
+	target := t.TempDir()
+
+	mounter := &recordingMounter{mounted: map[string]bool{}}
+	n := NewNodeWithMounter("node-id", "/tmp/test-csi.sock", mounter)
+	rec := volumeRecord{
+		VolumeID:          "test-volume",
+		StagingTargetPath: stagingPath,
+		Source:            "tmpfs",
+		FsType:            "tmpfs",
+	}
+	if err := n.state.putStage(rec); err != nil {
+		t.Fatalf("putStage() error = %v", err)
+	}
+	if err := n.state.putPublish(rec.VolumeID, stagingPath, publishRecord{
+		TargetPath:    target,
+		VolumeContext: map[string]string{"subDir": "data"},
+	}); err != nil {
+		t.Fatalf("putPublish() error = %v", err)
+	}
+	// A pod replaced the published subDir with a symlink out of the volume.
+	if err := os.MkdirAll(stagingPath, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(stagingPath, "data")); err != nil {
+		t.Fatal(err)
+	}
+
+	rec, _ = n.state.get(rec.VolumeID)
+	if err := n.restoreVolume(context.Background(), rec); !errors.Is(err, errSubDirSymlink) {
+		t.Fatalf("restoreVolume() error = %v, want %v", err, errSubDirSymlink)
+	}
+	for i, mount := range mounter.mounts {
+		if mount == target {
+			t.Fatalf("restoreVolume() bound %s onto the target", mounter.sources[i])
+		}
+	}
+}
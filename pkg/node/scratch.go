@@ -0,0 +1,292 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/joejulian/csi-justmount/pkg/mountopts"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+const (
+	tmpfsFsType = "tmpfs"
+	ramfsFsType = "ramfs"
+)
+
+// isScratchFsType reports whether fsType is a memory-backed filesystem that
+// starts out empty on every mount.
+func isScratchFsType(fsType string) bool {
+	return fsType == tmpfsFsType || fsType == ramfsFsType
+}
+
+// scratchOptions describes the size, inodes and template attributes of a
+// scratch volume.
+type scratchOptions struct {
+	// size is the limit in bytes, or 0 when none was given.
+	size int64
+	// inodes is the inode limit, or 0 when none was given.
+	inodes int64
+	// template is the template directory relative to the node's template
+	// root, or "" when the volume starts out empty.
+	template string
+}
+
+// parseScratchOptions validates the scratch volume attributes in
+// volumeContext. They are refused for filesystems other than tmpfs and ramfs.
+func parseScratchOptions(volumeContext map[string]string, fsType string) (scratchOptions, error) {
+	var opts scratchOptions
+	for _, key := range []string{"size", "inodes", "template"} {
+		if _, ok := volumeContext[key]; ok && !isScratchFsType(fsType) {
+			return opts, fmt.Errorf("%s is only supported for tmpfs and ramfs volumes", key)
+		}
+	}
+	if v := volumeContext["size"]; v != "" {
+		q, err := resource.ParseQuantity(v)
+		if err != nil || q.Sign() <= 0 {
+			return opts, fmt.Errorf("invalid size %q: must be a positive quantity such as 512Mi", v)
+		}
+		opts.size = q.Value()
+	}
+	if v := volumeContext["inodes"]; v != "" {
+		inodes, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || inodes <= 0 {
+			return opts, fmt.Errorf("invalid inodes %q: must be a positive integer", v)
+		}
+		opts.inodes = inodes
+	}
+	if v := volumeContext["template"]; v != "" {
+		if filepath.IsAbs(v) {
+			return opts, fmt.Errorf("template %q must be relative to the template directory", v)
+		}
+		for _, part := range strings.Split(v, "/") {
+			if part == ".." {
+				return opts, fmt.Errorf("template %q must not contain '..'", v)
+			}
+		}
+		opts.template = filepath.Clean(v)
+	}
+	return opts, nil
+}
+
+// scratchMountOptions fills in the limits of a scratch volume. A size
+// attribute wins over size= in opts, and the storage capacity of the PV is
+// used when neither sets one. tmpfs enforces the limits through size= and
+// nr_inodes=; ramfs has no limits, so they are only recorded for
+// NodeGetVolumeStats.
+func (n *Node) scratchMountOptions(ctx context.Context, volumeID, fsType, opts string, scratch *scratchOptions) string {
+	hasOption := func(key string) bool {
+		for _, opt := range mountopts.Split(opts) {
+			if strings.HasPrefix(opt, key+"=") {
+				return true
+			}
+		}
+		return false
+	}
+	if scratch.size == 0 && (fsType == ramfsFsType || !hasOption("size")) {
+		scratch.size = n.volumeCapacity(ctx, volumeID)
+	}
+	if fsType != tmpfsFsType {
+		return opts
+	}
+	if scratch.size > 0 {
+		opts = mountopts.Merge(opts, "size="+strconv.FormatInt(scratch.size, 10))
+	}
+	if scratch.inodes > 0 {
+		opts = mountopts.Merge(opts, "nr_inodes="+strconv.FormatInt(scratch.inodes, 10))
+	}
+	if !hasOption("size") {
+		Logger(ctx).Warn("tmpfs volume has no size limit; the kernel default of half the node's memory applies")
+	}
+	return opts
+}
+
+// volumeCapacity returns the storage capacity of the PV for volumeID, or 0
+// when it is not known.
+func (n *Node) volumeCapacity(ctx context.Context, volumeID string) int64 {
+	if n.pvcReporter == nil {
+		return 0
+	}
+	capacity, err := n.pvcReporter.VolumeCapacity(ctx, volumeID)
+	if err != nil {
+		Logger(ctx).Warn("failed to look up volume capacity", zap.Error(err))
+		return 0
+	}
+	return capacity
+}
+
+// seedScratchVolume copies the template directory of rec into its freshly
+// mounted staging path. The template is opened below the node's template root
+// without following symlinks, and its contents are copied with their modes and
+// owners; symlinks in it are copied as symlinks.
+func (n *Node) seedScratchVolume(ctx context.Context, rec volumeRecord) error {
+	scratch, err := parseScratchOptions(rec.VolumeContext, rec.FsType)
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+	if scratch.template == "" {
+		return nil
+	}
+	if n.scratchTemplateDir == "" {
+		return status.Error(codes.FailedPrecondition, "template requires the node plugin to run with --scratch-template-dir")
+	}
+	dir, err := openSubDir(n.scratchTemplateDir, subDirOptions{path: scratch.template})
+	if err != nil {
+		Logger(ctx).Error("failed to open scratch template", zap.String("template", scratch.template), zap.Error(err))
+		if errors.Is(err, errSubDirSymlink) || os.IsNotExist(err) {
+			return status.Errorf(codes.FailedPrecondition, "failed to open template: %v", err)
+		}
+		return status.Errorf(codes.Internal, "failed to open template: %v", err)
+	}
+	defer func() { _ = dir.Close() }()
+
+	// The trailing "." resolves the /proc/self/fd link so the walk descends.
+	if err := copyTree(subDirBindSource(dir)+"/.", rec.StagingTargetPath); err != nil {
+		Logger(ctx).Error("failed to seed scratch volume", zap.String("template", scratch.template), zap.Error(err))
+		return status.Errorf(codes.Internal, "failed to seed volume from template %q: %v", scratch.template, err)
+	}
+	Logger(ctx).Info("seeded scratch volume from template", zap.String("template", scratch.template))
+	return nil
+}
+
+// copyTree copies the directories, regular files and symlinks below src into
+// dst, keeping their modes and owners. Other file types are refused.
+func copyTree(src, dst string) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		switch mode := info.Mode(); {
+		case mode.IsDir():
+			if err := os.Mkdir(target, 0700); err != nil {
+				return err
+			}
+		case mode.IsRegular():
+			if err := copyFile(path, target); err != nil {
+				return err
+			}
+		case mode&fs.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			if err := os.Symlink(link, target); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("%s: unsupported file type %s", rel, mode.Type())
+		}
+		stat, ok := info.Sys().(*syscall.Stat_t)
+		if ok {
+			if err := os.Lchown(target, int(stat.Uid), int(stat.Gid)); err != nil {
+				return err
+			}
+		}
+		if info.Mode()&fs.ModeSymlink != 0 {
+			return nil
+		}
+		// chown clears setuid and setgid, so the mode goes last.
+		return os.Chmod(target, info.Mode()&(fs.ModePerm|fs.ModeSetuid|fs.ModeSetgid|fs.ModeSticky))
+	})
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.OpenFile(src, os.O_RDONLY|syscall.O_NOFOLLOW, 0)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = in.Close() }()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		_ = out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// ramfsVolumeStats reports the usage of a ramfs volume against its recorded
+// limits. ramfs reports nothing through statfs, so the tree is walked.
+func (n *Node) ramfsVolumeStats(ctx context.Context, req *csi.NodeGetVolumeStatsRequest, rec volumeRecord) (*csi.NodeGetVolumeStatsResponse, error) {
+	var usedBytes, usedInodes int64
+	err := filepath.WalkDir(req.GetVolumePath(), func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		// ramfs does not account blocks, so file sizes are the best measure
+		// of the memory its pages hold.
+		usedInodes++
+		if info.Mode().IsRegular() {
+			usedBytes += info.Size()
+		}
+		return nil
+	})
+	if err != nil {
+		Logger(ctx).Error("NodeGetVolumeStats failed to measure ramfs volume",
+			zap.String("volume_id", req.GetVolumeId()),
+			zap.String("volume_path", req.GetVolumePath()),
+			zap.Error(err),
+		)
+		return nil, status.Errorf(codes.Internal, "failed to measure volume usage: %v", err)
+	}
+
+	usage := []*csi.VolumeUsage{scratchUsage(usedBytes, rec.SizeLimit, csi.VolumeUsage_BYTES)}
+	if rec.InodeLimit > 0 {
+		usage = append(usage, scratchUsage(usedInodes, rec.InodeLimit, csi.VolumeUsage_INODES))
+	}
+	Logger(ctx).Info("NodeGetVolumeStats complete",
+		zap.String("volume_id", req.GetVolumeId()),
+		zap.String("volume_path", req.GetVolumePath()),
+		zap.Int64("used_bytes", usedBytes),
+		zap.Int64("size_limit", rec.SizeLimit),
+	)
+	return &csi.NodeGetVolumeStatsResponse{
+		Usage: usage,
+		VolumeCondition: &csi.VolumeCondition{
+			Abnormal: false,
+			Message:  "volume path is usable",
+		},
+	}, nil
+}
+
+func scratchUsage(used, limit int64, unit csi.VolumeUsage_Unit) *csi.VolumeUsage {
+	available := limit - used
+	if available < 0 {
+		available = 0
+	}
+	return &csi.VolumeUsage{
+		Available: available,
+		Total:     limit,
+		Used:      used,
+		Unit:      unit,
+	}
+}
@@ -0,0 +1,211 @@
+package node
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestNodeStageVolumeScratchLimits(t *testing.T) {
+	tests := []struct {
+		name       string
+		fsType     string
+		context    map[string]string
+		capacity   int64
+		wantCode   codes.Code
+		wantData   string
+		wantLimits [2]int64
+	}{
+		{
+			name:       "size and inodes attributes",
+			fsType:     "tmpfs",
+			context:    map[string]string{"size": "512Mi", "inodes": "10000"},
+			capacity:   1 << 30,
+			wantData:   "size=536870912,nr_inodes=10000",
+			wantLimits: [2]int64{512 << 20, 10000},
+		},
+		{
+			name:       "size from PV capacity",
+			fsType:     "tmpfs",
+			context:    map[string]string{},
+			capacity:   1 << 30,
+			wantData:   "size=1073741824",
+			wantLimits: [2]int64{1 << 30, 0},
+		},
+		{
+			name:     "size from mountOptions wins over capacity",
+			fsType:   "tmpfs",
+			context:  map[string]string{"mountOptions": "size=50%"},
+			capacity: 1 << 30,
+			wantData: "size=50%",
+		},
+		{
+			name:       "size attribute wins over mountOptions",
+			fsType:     "tmpfs",
+			context:    map[string]string{"mountOptions": "size=50%,mode=1777", "size": "1Gi"},
+			wantData:   "mode=1777,size=1073741824",
+			wantLimits: [2]int64{1 << 30, 0},
+		},
+		{
+			name:       "ramfs limits are only recorded",
+			fsType:     "ramfs",
+			context:    map[string]string{},
+			capacity:   1 << 30,
+			wantLimits: [2]int64{1 << 30, 0},
+		},
+		{
+			name:    "unknown capacity leaves tmpfs unlimited",
+			fsType:  "tmpfs",
+			context: map[string]string{},
+		},
+		{
+			name:     "size on another filesystem",
+			fsType:   "ext4",
+			context:  map[string]string{"size": "1Gi"},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name:     "invalid size",
+			fsType:   "tmpfs",
+			context:  map[string]string{"size": "lots"},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name:     "template without template directory",
+			fsType:   "tmpfs",
+			context:  map[string]string{"template": "base"},
+			wantCode: codes.FailedPrecondition,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			volumeContext := map[string]string{"source": tt.fsType}
+			for k, v := range tt.context {
+				volumeContext[k] = v
+			}
+			mounter := &recordingMounter{mounted: map[string]bool{}}
+			n := NewNodeWithMounter("node-id", "/tmp/test-csi.sock", mounter)
+			n.pvcReporter = &recordingPVCReporter{capacity: tt.capacity}
+
+			_, err := n.NodeStageVolume(context.Background(),
+				stageRequest(filepath.Join(t.TempDir(), "stage"), tt.fsType, volumeContext))
+			if status.Code(err) != tt.wantCode {
+				t.Fatalf("NodeStageVolume() error = %v, want code %v", err, tt.wantCode)
+			}
+			if tt.wantCode != codes.OK {
+				return
+			}
+			if len(mounter.data) != 1 || mounter.data[0] != tt.wantData {
+				t.Fatalf("mount data = %q, want %q", mounter.data, tt.wantData)
+			}
+			rec, _ := n.state.get("test-volume")
+			if got := [2]int64{rec.SizeLimit, rec.InodeLimit}; got != tt.wantLimits {
+				t.Fatalf("recorded limits = %v, want %v", got, tt.wantLimits)
+			}
+		})
+	}
+}
+
+func TestNodeStageVolumeSeedsScratchTemplate(t *testing.T) {
+	templateDir := t.TempDir()
+	base := filepath.Join(templateDir, "base")
+	if err := os.MkdirAll(filepath.Join(base, "conf"), 0750); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(base, "conf", "app.ini"), []byte("debug=false\n"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("conf/app.ini", filepath.Join(base, "app.ini")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("/etc", filepath.Join(templateDir, "escape")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		template string
+		wantCode codes.Code
+	}{
+		{name: "template is copied", template: "base"},
+		{name: "missing template", template: "missing", wantCode: codes.FailedPrecondition},
+		{name: "symlinked template", template: "escape", wantCode: codes.FailedPrecondition},
+		{name: "template outside the template directory", template: "../base", wantCode: codes.InvalidArgument},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stagingPath := filepath.Join(t.TempDir(), "stage")
+			mounter := &recordingMounter{mounted: map[string]bool{}}
+			n := NewNodeWithMounter("node-id", "/tmp/test-csi.sock", mounter, WithScratchTemplateDir(templateDir))
+
+			_, err := n.NodeStageVolume(context.Background(), stageRequest(stagingPath, "tmpfs", map[string]string{
+				"source":   "tmpfs",
+				"size":     "64Mi",
+				"template": tt.template,
+			}))
+			if status.Code(err) != tt.wantCode {
+				t.Fatalf("NodeStageVolume() error = %v, want code %v", err, tt.wantCode)
+			}
+			if tt.wantCode != codes.OK {
+				if len(mounter.mounts) != len(mounter.unmounts) {
+					t.Fatalf("unseeded volume left mounted: mounts %v, unmounts %v", mounter.mounts, mounter.unmounts)
+				}
+				return
+			}
+
+			data, err := os.ReadFile(filepath.Join(stagingPath, "app.ini"))
+			if err != nil || string(data) != "debug=false\n" {
+				t.Fatalf("seeded app.ini = %q, %v", data, err)
+			}
+			if link, err := os.Readlink(filepath.Join(stagingPath, "app.ini")); err != nil || link != "conf/app.ini" {
+				t.Fatalf("seeded symlink = %q, %v, want conf/app.ini", link, err)
+			}
+			for path, want := range map[string]os.FileMode{"conf": 0750 | os.ModeDir, "conf/app.ini": 0640} {
+				info, err := os.Stat(filepath.Join(stagingPath, path))
+				if err != nil || info.Mode() != want {
+					t.Fatalf("seeded %s mode = %v, %v, want %v", path, info.Mode(), err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestNodeGetVolumeStatsRamfsUsage(t *testing.T) {
+	volumePath := t.TempDir()
+	if err := os.WriteFile(filepath.Join(volumePath, "data"), make([]byte, 4096), 0644); err != nil {
+		t.Fatal(err)
+	}
+	n := NewNodeWithMounter("node-id", "/tmp/test-csi.sock", &recordingMounter{mounted: map[string]bool{}})
+	if err := n.state.putStage(volumeRecord{
+		VolumeID:          "test-volume",
+		StagingTargetPath: volumePath,
+		FsType:            "ramfs",
+		SizeLimit:         1 << 20,
+		InodeLimit:        100,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := n.NodeGetVolumeStats(context.Background(), &csi.NodeGetVolumeStatsRequest{
+		VolumeId:   "test-volume",
+		VolumePath: volumePath,
+	})
+	if err != nil {
+		t.Fatalf("NodeGetVolumeStats() error = %v", err)
+	}
+	if len(resp.GetUsage()) != 2 {
+		t.Fatalf("usage = %v, want bytes and inodes", resp.GetUsage())
+	}
+	bytes, inodes := resp.GetUsage()[0], resp.GetUsage()[1]
+	if bytes.GetUnit() != csi.VolumeUsage_BYTES || bytes.GetTotal() != 1<<20 || bytes.GetUsed() != 4096 || bytes.GetAvailable() != 1<<20-4096 {
+		t.Fatalf("byte usage = %v, want 4096 of %d", bytes, 1<<20)
+	}
+	if inodes.GetUnit() != csi.VolumeUsage_INODES || inodes.GetTotal() != 100 || inodes.GetUsed() != 2 {
+		t.Fatalf("inode usage = %v, want 2 of 100", inodes)
+	}
+}
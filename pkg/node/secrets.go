@@ -0,0 +1,165 @@
+package node
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"syscall"
+
+	"go.uber.org/zap"
+)
+
+// secretRefPattern matches ${secret.<key>} and ${secretFile.<key>} references
+// to node-stage secrets in mountOptions.
+var secretRefPattern = regexp.MustCompile(`\$\{(secret|secretFile)\.([^}]+)\}`)
+
+var (
+	errSecretNotFound     = errors.New("secret not found")
+	errSecretsUnavailable = errors.New("node-stage secrets are not available")
+	errNoSecretsDir       = errors.New("no secrets directory is configured")
+	errInvalidSecretKey   = errors.New("invalid secret key")
+)
+
+func hasSecretRefs(s string) bool {
+	return secretRefPattern.MatchString(s)
+}
+
+// expandSecretRefs replaces secret references in s. ${secret.key} is replaced
+// by the value itself; ${secretFile.key} is replaced by the path of a 0600 file
+// holding the value in the private secrets tmpfs. With nil secrets, as when
+// restoring mounts after a restart, only file references that still exist on
+// disk can be resolved.
+func (n *Node) expandSecretRefs(ctx context.Context, volumeID, s string, secrets map[string]string) (string, error) {
+	var expandErr error
+	out := secretRefPattern.ReplaceAllStringFunc(s, func(ref string) string {
+		if expandErr != nil {
+			return ref
+		}
+		m := secretRefPattern.FindStringSubmatch(ref)
+		kind, key := m[1], m[2]
+
+		if kind == "secret" {
+			if secrets == nil {
+				expandErr = fmt.Errorf("%w: option references secret %q", errSecretsUnavailable, key)
+				return ref
+			}
+			value, ok := secrets[key]
+			if !ok {
+				expandErr = fmt.Errorf("%w: %q", errSecretNotFound, key)
+				return ref
+			}
+			if strings.Contains(value, ",") {
+				expandErr = fmt.Errorf("secret %q contains a comma; reference it with ${secretFile.%s} instead", key, key)
+				return ref
+			}
+			return value
+		}
+
+		path, err := n.secretFilePath(volumeID, key)
+		if err != nil {
+			expandErr = err
+			return ref
+		}
+		if secrets == nil {
+			if _, err := os.Stat(path); err != nil {
+				expandErr = fmt.Errorf("%w: secret file for %q: %v", errSecretsUnavailable, key, err)
+				return ref
+			}
+			return path
+		}
+		value, ok := secrets[key]
+		if !ok {
+			expandErr = fmt.Errorf("%w: %q", errSecretNotFound, key)
+			return ref
+		}
+		if err := n.writeSecretFile(ctx, path, value); err != nil {
+			expandErr = fmt.Errorf("write secret file for %q: %w", key, err)
+			return ref
+		}
+		return path
+	})
+	if expandErr != nil {
+		return "", expandErr
+	}
+	return out, nil
+}
+
+// secretFilePath returns where the secret key for volumeID is written. The key
+// becomes the file name, so it has to be a single path element.
+func (n *Node) secretFilePath(volumeID, key string) (string, error) {
+	if n.secretsDir == "" {
+		return "", errNoSecretsDir
+	}
+	if key == "." || key == ".." || strings.Contains(key, "/") {
+		return "", fmt.Errorf("%w: %q", errInvalidSecretKey, key)
+	}
+	return filepath.Join(n.secretVolumeDir(volumeID), key), nil
+}
+
+// secretVolumeDir returns the directory holding the secret files of volumeID.
+// It is named by a hash of the volume ID, so no ID, not even "." or "..", can
+// point it at another volume's files or outside the secrets directory.
+func (n *Node) secretVolumeDir(volumeID string) string {
+	sum := sha256.Sum256([]byte(volumeID))
+	return filepath.Join(n.secretsDir, hex.EncodeToString(sum[:]))
+}
+
+// writeSecretFile writes value to path with mode 0600, mounting the private
+// secrets tmpfs first so secrets never reach persistent storage.
+func (n *Node) writeSecretFile(ctx context.Context, path, value string) error {
+	if err := n.ensureSecretsMount(ctx); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if err := f.Chmod(0600); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if _, err := f.WriteString(value); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// ensureSecretsMount mounts a tmpfs only root can read at the secrets
+// directory unless one is already there.
+func (n *Node) ensureSecretsMount(ctx context.Context) error {
+	if err := os.MkdirAll(n.secretsDir, 0700); err != nil {
+		return err
+	}
+	mounted, err := n.mounter.IsMountPoint(n.secretsDir)
+	if err != nil {
+		return err
+	}
+	if mounted {
+		return nil
+	}
+	Logger(ctx).Info("mounting secrets tmpfs", zap.String("path", n.secretsDir))
+	return n.mounter.Mount("tmpfs", n.secretsDir, "tmpfs", syscall.MS_NOSUID|syscall.MS_NODEV|syscall.MS_NOEXEC, "mode=0700")
+}
+
+// removeSecretFiles deletes every secret file written for volumeID.
+func (n *Node) removeSecretFiles(ctx context.Context, volumeID string) error {
+	if n.secretsDir == "" {
+		return nil
+	}
+	dir := n.secretVolumeDir(volumeID)
+	if err := os.RemoveAll(dir); err != nil {
+		Logger(ctx).Error("failed to remove secret files", zap.String("volume_id", volumeID), zap.Error(err))
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func stageSecretsRequest(stagingPath, opts string, secrets map[string]string) *csi.NodeStageVolumeRequest {
+	req := stageRequest(stagingPath, "cifs", map[string]string{
+		"source":       "//server/share",
+		"fileMode":     "0755",
+		"mountOptions": opts,
+	})
+	req.Secrets = secrets
+	return req
+}
+
+func TestNodeStageVolumeExpandsSecretReferences(t *testing.T) {
+	stagingPath := t.TempDir()
+	stateDir := t.TempDir()
+	secretsDir := filepath.Join(t.TempDir(), "secrets")
+	mounter := &recordingMounter{mounted: map[string]bool{}}
+	n := NewNodeWithMounter("node-id", "/tmp/test-csi.sock", mounter,
+		WithStateDir(stateDir), WithSecretsDir(secretsDir))
+
+	req := stageSecretsRequest(stagingPath,
+		"ro,username=${secret.username},credentials=${secretFile.credentials}",
+		map[string]string{"username": "alice", "credentials": "password=hunter2\n"},
+	)
+	if _, err := n.NodeStageVolume(context.Background(), req); err != nil {
+		t.Fatalf("NodeStageVolume() error = %v", err)
+	}
+
+	credsPath := filepath.Join(secretsDir, secretDirName("test-volume"), "credentials")
+	wantMounts := []string{secretsDir, stagingPath}
+	if len(mounter.mounts) != len(wantMounts) || mounter.mounts[0] != wantMounts[0] || mounter.mounts[1] != wantMounts[1] {
+		t.Fatalf("NodeStageVolume() mounts = %v, want %v", mounter.mounts, wantMounts)
+	}
+	if want := "username=alice,credentials=" + credsPath; mounter.data[1] != want {
+		t.Fatalf("NodeStageVolume() mount data = %q, want %q", mounter.data[1], want)
+	}
+	info, err := os.Stat(credsPath)
+	if err != nil {
+		t.Fatalf("stat credentials file: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Fatalf("credentials file mode = %v, want 0600", info.Mode().Perm())
+	}
+	if got, _ := os.ReadFile(credsPath); string(got) != "password=hunter2\n" {
+		t.Fatalf("credentials file content = %q, want the secret value", got)
+	}
+
+	entries, err := os.ReadDir(stateDir)
+	if err != nil {
+		t.Fatalf("read state dir: %v", err)
+	}
+	for _, entry := range entries {
+		data, err := os.ReadFile(filepath.Join(stateDir, entry.Name()))
+		if err != nil {
+			t.Fatalf("read state record: %v", err)
+		}
+		if strings.Contains(string(data), "alice") || strings.Contains(string(data), "hunter2") {
+			t.Fatalf("state record %s contains a secret value: %s", entry.Name(), data)
+		}
+	}
+
+	if _, err := n.NodeUnstageVolume(context.Background(), &csi.NodeUnstageVolumeRequest{
+		VolumeId:          "test-volume",
+		StagingTargetPath: stagingPath,
+	}); err != nil {
+		t.Fatalf("NodeUnstageVolume() error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Dir(credsPath)); !os.IsNotExist(err) {
+		t.Fatalf("secret files after unstage: stat error = %v, want not exist", err)
+	}
+}
+
+func TestNodeStageVolumeRejectsMissingSecret(t *testing.T) {
+	mounter := &recordingMounter{mounted: map[string]bool{}}
+	n := NewNodeWithMounter("node-id", "/tmp/test-csi.sock", mounter, WithSecretsDir(t.TempDir()))
+
+	req := stageSecretsRequest(t.TempDir(), "password=${secret.password}", map[string]string{"username": "alice"})
+	_, err := n.NodeStageVolume(context.Background(), req)
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("NodeStageVolume() code = %v, want %v", status.Code(err), codes.InvalidArgument)
+	}
+	if len(mounter.mounts) != 0 {
+		t.Fatalf("NodeStageVolume() mounts = %v, want none", mounter.mounts)
+	}
+}
+
+func TestResolveMountOptionsAfterRestart(t *testing.T) {
+	secretsDir := t.TempDir()
+	n := NewNodeWithMounter("node-id", "/tmp/test-csi.sock", &recordingMounter{mounted: map[string]bool{}},
+		WithSecretsDir(secretsDir))
+	credsPath := filepath.Join(secretsDir, secretDirName("test-volume"), "key")
+	if err := os.MkdirAll(filepath.Dir(credsPath), 0700); err != nil {
+		t.Fatalf("create secrets dir: %v", err)
+	}
+	if err := os.WriteFile(credsPath, []byte("key"), 0600); err != nil {
+		t.Fatalf("write secret file: %v", err)
+	}
+
+	rec := volumeRecord{
+		VolumeID:     "test-volume",
+		MountOptions: "IdentityFile=${secretFile.key}",
+		MountData:    "IdentityFile=${secretFile.key}",
+	}
+	opts, data, err := n.resolveMountOptions(context.Background(), rec)
+	if err != nil {
+		t.Fatalf("resolveMountOptions() error = %v", err)
+	}
+	if want := "IdentityFile=" + credsPath; opts != want || data != want {
+		t.Fatalf("resolveMountOptions() = %q, %q, want %q", opts, data, want)
+	}
+
+	rec.MountOptions = "password=${secret.password}"
+	if _, _, err := n.resolveMountOptions(context.Background(), rec); err == nil {
+		t.Fatalf("resolveMountOptions() with inline secret error = nil, want error")
+	}
+}
+
+func secretDirName(volumeID string) string {
+	return filepath.Base((&Node{secretsDir: "/"}).secretVolumeDir(volumeID))
+}
+
+func TestSecretFilesStayInsideTheVolumeDirectory(t *testing.T) {
+	secretsDir := filepath.Join(t.TempDir(), "secrets")
+	sibling := filepath.Join(filepath.Dir(secretsDir), "volumes")
+	if err := os.MkdirAll(sibling, 0700); err != nil {
+		t.Fatal(err)
+	}
+	other := filepath.Join(secretsDir, secretDirName("other-volume"), "key")
+	if err := os.MkdirAll(filepath.Dir(other), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(other, []byte("key"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	n := NewNodeWithMounter("node-id", "/tmp/test-csi.sock", &recordingMounter{mounted: map[string]bool{}},
+		WithSecretsDir(secretsDir))
+
+	for _, volumeID := range []string{".", "..", "../volumes"} {
+		if err := n.removeSecretFiles(context.Background(), volumeID); err != nil {
+			t.Fatalf("removeSecretFiles(%q) error = %v", volumeID, err)
+		}
+	}
+	for _, path := range []string{sibling, other} {
+		if _, err := os.Stat(path); err != nil {
+			t.Fatalf("%s after removing dot volume IDs: %v", path, err)
+		}
+	}
+
+	for _, key := range []string{".", "..", "../key"} {
+		if _, err := n.secretFilePath("test-volume", key); !errors.Is(err, errInvalidSecretKey) {
+			t.Fatalf("secretFilePath(%q) error = %v, want %v", key, err, errInvalidSecretKey)
+		}
+	}
+}
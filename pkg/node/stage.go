@@ -6,12 +6,14 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"syscall"
 	"time"
 
 	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/joejulian/csi-justmount/pkg/mountopts"
 	"go.uber.org/zap"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
@@ -39,6 +41,9 @@ func (n *Node) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolumeRequ
 		Logger(ctx).Error("NodeStageVolume invalid argument: volume_capability is required")
 		return nil, status.Error(codes.InvalidArgument, "volume_capability is required")
 	}
+	if req.GetVolumeCapability().GetBlock() != nil {
+		return n.nodeStageBlockVolume(ctx, req)
+	}
 
 	// Retrieve the fsType from volume capability and ensure it is specified
 	mount := req.GetVolumeCapability().GetMount()
@@ -55,19 +60,30 @@ func (n *Node) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolumeRequ
 		Logger(ctx).Error("NodeStageVolume invalid argument: fsType is required")
 		return nil, status.Error(codes.InvalidArgument, "fsType is required in volume capability or volume context")
 	}
+	if fsType == overlayFsType {
+		return n.nodeStageOverlayVolume(ctx, req)
+	}
 
-	// Retrieve and apply file mode from VolumeContext; fileMode is required
-	modeStr, ok := req.GetVolumeContext()["fileMode"]
-	if !ok {
-		Logger(ctx).Error("NodeStageVolume invalid argument: fileMode is required")
-		return nil, status.Error(codes.InvalidArgument, "fileMode is a required parameter in VolumeContext")
+	// Retrieve the optional ownership attributes applied to the staging root
+	modeStr := req.GetVolumeContext()["fileMode"]
+	if modeStr != "" {
+		if _, err := strconv.ParseUint(modeStr, 8, 32); err != nil {
+			Logger(ctx).Error("NodeStageVolume invalid argument: invalid fileMode", zap.Error(err))
+			return nil, status.Errorf(codes.InvalidArgument, "invalid file mode: %v", err)
+		}
 	}
-	mode, err := strconv.ParseUint(modeStr, 8, 32)
-	if err != nil {
-		Logger(ctx).Error("NodeStageVolume invalid argument: invalid fileMode", zap.Error(err))
-		return nil, status.Errorf(codes.InvalidArgument, "invalid file mode: %v", err)
+	uidStr, gidStr := req.GetVolumeContext()["uid"], req.GetVolumeContext()["gid"]
+	for name, v := range map[string]string{"uid": uidStr, "gid": gidStr} {
+		if _, err := parseOwnerID(v); err != nil {
+			Logger(ctx).Error("NodeStageVolume invalid argument: invalid "+name, zap.Error(err))
+			return nil, status.Errorf(codes.InvalidArgument, "invalid %s: %v", name, err)
+		}
+	}
+
+	if _, err := repairModeFor(req.GetVolumeContext()); err != nil {
+		Logger(ctx).Error("NodeStageVolume invalid argument: invalid repair mode", zap.Error(err))
+		return nil, status.Error(codes.InvalidArgument, err.Error())
 	}
-	fileMode := os.FileMode(mode)
 
 	// Retrieve mount source from VolumeContext
 	source, ok := req.GetVolumeContext()["source"]
@@ -76,6 +92,157 @@ func (n *Node) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolumeRequ
 		return nil, status.Error(codes.InvalidArgument, "source is a required parameter in VolumeContext")
 	}
 
+	// Parse mount options, adding the fsGroup kubelet asks for where the
+	// filesystem takes it as a mount option
+	opts := mergedMountOptions(req.GetVolumeCapability(), req.GetVolumeContext())
+	mountGroup := mount.GetVolumeMountGroup()
+	if mountGroup != "" {
+		if _, err := parseMountGroup(mountGroup); err != nil {
+			Logger(ctx).Error("NodeStageVolume invalid argument: invalid volume mount group", zap.Error(err))
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		if option := mountGroupOption(fsType, mountGroup); option != "" {
+			opts = mountopts.Merge(opts, option)
+		}
+	}
+	// Scratch volumes take their limits from the size and inodes attributes or
+	// from the capacity of the PV.
+	scratch, err := parseScratchOptions(req.GetVolumeContext(), fsType)
+	if err != nil {
+		Logger(ctx).Error("NodeStageVolume invalid argument: invalid scratch volume options", zap.Error(err))
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if scratch.template != "" && n.scratchTemplateDir == "" {
+		Logger(ctx).Error("NodeStageVolume template requested without a template directory")
+		return nil, status.Error(codes.FailedPrecondition, "template requires the node plugin to run with --scratch-template-dir")
+	}
+	if isScratchFsType(fsType) {
+		opts = n.scratchMountOptions(ctx, req.GetVolumeId(), fsType, opts, &scratch)
+	}
+	parsed, err := mountopts.Parse(opts)
+	if err != nil {
+		Logger(ctx).Error("NodeStageVolume invalid argument: invalid mount options", zap.Error(err))
+		return nil, status.Errorf(codes.InvalidArgument, "invalid mountOptions: %v", err)
+	}
+	if err := checkMountOperation(fsType, parsed.Flags); err != nil {
+		Logger(ctx).Error("NodeStageVolume invalid argument: unsupported mount options", zap.String("opts", opts), zap.Error(err))
+		return nil, status.Errorf(codes.InvalidArgument, "mountOptions %v", err)
+	}
+	recursive, err := parseBindRecursive(req.GetVolumeContext(), fsType)
+	if err != nil {
+		Logger(ctx).Error("NodeStageVolume invalid argument: invalid recursive", zap.Error(err))
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	// The policy sees the host directory a bind volume resolves to, so a
+	// symlink cannot hide it.
+	policySource := source
+	if fsType == bindFsType {
+		if parsed.Data != "" {
+			Logger(ctx).Error("NodeStageVolume invalid argument: bind volume with filesystem options", zap.String("opts", opts))
+			return nil, status.Errorf(codes.InvalidArgument, "fsType bind only takes mount flags, not %q", parsed.Data)
+		}
+		resolved, err := n.resolveBindSource(source)
+		if err != nil {
+			Logger(ctx).Error("NodeStageVolume refusing bind source", zap.String("source", source), zap.Error(err))
+			return nil, bindSourceError(err)
+		}
+		policySource = resolved
+		parsed.Flags |= syscall.MS_BIND
+		if recursive {
+			parsed.Flags |= syscall.MS_REC
+		}
+	}
+
+	// A regular file as source is a filesystem image mounted through a loop
+	// device. Reader-only access attaches the device read-only, so the mount
+	// has to be read-only as well.
+	loop, err := isLoopImage(source, fsType)
+	if err != nil {
+		Logger(ctx).Error("NodeStageVolume failed to inspect source", zap.String("source", source), zap.Error(err))
+		return nil, status.Errorf(codes.InvalidArgument, "failed to inspect source: %v", err)
+	}
+	if loop && readerOnly(req.GetVolumeCapability()) {
+		parsed.Flags |= syscall.MS_RDONLY
+	}
+	format, err := parseFormatOptions(req.GetVolumeContext())
+	if err != nil {
+		Logger(ctx).Error("NodeStageVolume invalid argument: invalid format options", zap.Error(err))
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	fsck, err := parseFsckBeforeMount(req.GetVolumeContext(), fsType)
+	if err != nil {
+		Logger(ctx).Error("NodeStageVolume invalid argument: invalid fsckBeforeMount", zap.Error(err))
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	// Image files are never formatted: a regular file with no signature is as
+	// likely to be a database or a log as an empty image.
+	if format.enabled && loop {
+		Logger(ctx).Error("NodeStageVolume invalid argument: formatIfBlank needs a block device source",
+			zap.String("source", source),
+		)
+		return nil, status.Error(codes.InvalidArgument, "formatIfBlank requires a block device source; image files are not formatted")
+	}
+	if (format.enabled || fsck) && !loop {
+		if err := checkBlockDevice(source); err != nil {
+			Logger(ctx).Error("NodeStageVolume invalid argument: formatIfBlank and fsckBeforeMount need a block device source",
+				zap.String("source", source),
+				zap.Error(err),
+			)
+			return nil, status.Errorf(codes.InvalidArgument, "formatIfBlank and fsckBeforeMount require a block device or image file source: %v", err)
+		}
+	}
+
+	if err := n.checkPolicy(ctx, fsType, policySource, opts); err != nil {
+		return nil, err
+	}
+
+	release, err := n.acquireOperation(ctx, "NodeStageVolume", req.GetVolumeId(), req.GetStagingTargetPath())
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	// Record how the volume is staged so it can be found again after a restart.
+	// Secret references stay unexpanded in the persisted fields.
+	record := volumeRecord{
+		VolumeID:          req.GetVolumeId(),
+		StagingTargetPath: filepath.Clean(req.GetStagingTargetPath()),
+		Source:            source,
+		FsType:            fsType,
+		Loop:              loop,
+		MountOptions:      opts,
+		MountFlags:        parsed.Flags,
+		MountPropagation:  parsed.Propagation,
+		MountData:         parsed.Data,
+		FileMode:          modeStr,
+		UID:               uidStr,
+		GID:               gidStr,
+		MountGroup:        mountGroup,
+		SizeLimit:         scratch.size,
+		InodeLimit:        scratch.inodes,
+		VolumeContext:     req.GetVolumeContext(),
+	}
+	if hasSecretRefs(opts) {
+		if record.resolvedOptions, err = n.expandSecretRefs(ctx, record.VolumeID, opts, req.GetSecrets()); err == nil {
+			record.resolvedData, err = n.expandSecretRefs(ctx, record.VolumeID, parsed.Data, req.GetSecrets())
+		}
+		if err != nil {
+			_ = n.removeSecretFiles(ctx, record.VolumeID)
+			Logger(ctx).Error("NodeStageVolume failed to resolve secret references", zap.Error(err))
+			if errors.Is(err, errSecretNotFound) || errors.Is(err, errNoSecretsDir) || errors.Is(err, errInvalidSecretKey) {
+				return nil, status.Errorf(codes.InvalidArgument, "invalid mountOptions: %v", err)
+			}
+			return nil, status.Errorf(codes.Internal, "failed to resolve secret references: %v", err)
+		}
+		// A secret value can spell out options of its own, so the expanded
+		// options have to pass the policy too. Only the unexpanded ones are
+		// logged.
+		if err := refusedByPolicy(ctx, n.policy.check(fsType, policySource, record.resolvedOptions), fsType, policySource, opts); err != nil {
+			_ = n.removeSecretFiles(ctx, record.VolumeID)
+			return nil, err
+		}
+	}
+
 	// Create the staging path if it doesn't exist
 	volumePath := req.GetStagingTargetPath()
 	if err := os.MkdirAll(volumePath, 0755); err != nil {
@@ -89,6 +256,13 @@ func (n *Node) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolumeRequ
 	isMounted, err := n.mounter.IsMountPoint(volumePath)
 	if err == nil && isMounted {
 		if err := probeMountPath(volumePath); err == nil {
+			if existing, ok := n.state.get(req.GetVolumeId()); !ok {
+				if err := n.saveStageState(ctx, record); err != nil {
+					return nil, err
+				}
+			} else if err := mountGroupConflict(ctx, "NodeStageVolume", existing.MountGroup, mountGroup); err != nil {
+				return nil, err
+			}
 			Logger(ctx).Info("NodeStageVolume already mounted")
 			return &csi.NodeStageVolumeResponse{}, nil
 		} else if !isDisconnectedMountError(err) {
@@ -117,39 +291,187 @@ func (n *Node) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolumeRequ
 		return nil, status.Errorf(codes.Internal, "failed to verify staging target path mountpoint: %v", err)
 	}
 
-	// Parse mount options
-	opts := strings.TrimSpace(req.GetVolumeContext()["mountOptions"])
-	var flags uintptr
-	var dataOpts []string
-	if opts != "" {
-		for _, opt := range strings.Split(opts, ",") {
-			o := strings.TrimSpace(opt)
-			if o == "" {
-				continue
-			}
-			switch o {
-			case "ro":
-				flags |= syscall.MS_RDONLY
-			case "rw":
-				// no flag needed
-			case "nosuid":
-				flags |= syscall.MS_NOSUID
-			case "nodev":
-				flags |= syscall.MS_NODEV
-			case "noexec":
-				flags |= syscall.MS_NOEXEC
-			case "noatime":
-				flags |= syscall.MS_NOATIME
-			case "relatime":
-				flags |= syscall.MS_RELATIME
-			default:
-				dataOpts = append(dataOpts, o)
-			}
+	// Perform the mount operation with the specified fsType
+	if err := n.mountStaging(ctx, &record); err != nil {
+		_ = releaseLoopDevice(ctx, &record)
+		_ = n.removeSecretFiles(ctx, record.VolumeID)
+		return nil, err
+	}
+
+	// Apply ownership and the volume mount group after mounting, as the mount
+	// replaces the directory's own permissions. A staging mount that cannot
+	// take them is not left behind, or a retry would find it mounted and skip
+	// this step.
+	if err := applyStagingPermissions(ctx, record); err != nil {
+		Logger(ctx).Error("NodeStageVolume failed to set staging path ownership", zap.Error(err))
+		_ = n.unmountAllAtPath(ctx, volumePath)
+		_ = releaseLoopDevice(ctx, &record)
+		_ = n.removeSecretFiles(ctx, record.VolumeID)
+		if isPermissionChangeRefused(err) {
+			return nil, status.Errorf(codes.InvalidArgument,
+				"filesystem %q refused fileMode/uid/gid on the staging path; remove those attributes or use mount options such as uid=/gid=/file_mode=: %v",
+				fsType, err)
 		}
+		return nil, status.Errorf(codes.Internal, "failed to set staging path ownership: %v", err)
+	}
+	if err := n.applyMountGroup(ctx, record); err != nil {
+		Logger(ctx).Error("NodeStageVolume failed to apply volume mount group", zap.Error(err))
+		_ = n.unmountAllAtPath(ctx, volumePath)
+		_ = releaseLoopDevice(ctx, &record)
+		_ = n.removeSecretFiles(ctx, record.VolumeID)
+		if isPermissionChangeRefused(err) {
+			return nil, status.Errorf(codes.InvalidArgument,
+				"filesystem %q refused the volume mount group on the staging path; set fsGroupPolicy to None for this driver or give the group with a mount option such as gid=: %v",
+				fsType, err)
+		}
+		return nil, status.Errorf(codes.Internal, "failed to apply volume mount group: %v", err)
+	}
+	if err := n.saveStageState(ctx, record); err != nil {
+		return nil, err
+	}
+
+	// Return success if mounting succeeded
+	Logger(ctx).Info("NodeStageVolume complete")
+	return &csi.NodeStageVolumeResponse{}, nil
+}
+
+func (n *Node) NodeUnstageVolume(ctx context.Context, req *csi.NodeUnstageVolumeRequest) (*csi.NodeUnstageVolumeResponse, error) {
+	Logger(ctx).Info("NodeUnstageVolume start",
+		zap.String("volume_id", req.GetVolumeId()),
+		zap.String("staging_target_path", req.GetStagingTargetPath()),
+	)
+	// Check if volume_id is provided
+	if req.GetVolumeId() == "" {
+		Logger(ctx).Error("NodeUnstageVolume invalid argument: volume_id is required")
+		return nil, status.Error(codes.InvalidArgument, "volume_id is required")
+	}
+
+	// Check if staging_target_path is provided
+	if req.GetStagingTargetPath() == "" {
+		Logger(ctx).Error("NodeUnstageVolume invalid argument: staging_target_path is required")
+		return nil, status.Error(codes.InvalidArgument, "staging_target_path is required")
+	}
+
+	release, err := n.acquireOperation(ctx, "NodeUnstageVolume", req.GetVolumeId(), req.GetStagingTargetPath())
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	// Block volumes are never mounted at the staging path, and overlay
+	// volumes only mount their layers below it.
+	stagingPath := req.GetStagingTargetPath()
+	rec, ok := n.state.get(req.GetVolumeId())
+	switch {
+	case ok && len(rec.Layers) > 0:
+		if err := n.unstageOverlayVolume(ctx, rec); err != nil {
+			return nil, err
+		}
+	case !ok || !rec.Block:
+		recursive := ok && rec.FsType == bindFsType && rec.MountFlags&syscall.MS_REC != 0
+		if err := n.unmountStagingPath(ctx, stagingPath, recursive); err != nil {
+			return nil, err
+		}
+	}
+	if ok && rec.LoopDevice != "" {
+		if err := releaseLoopDevice(ctx, &rec); err != nil {
+			Logger(ctx).Error("NodeUnstageVolume failed to detach loop device", zap.Error(err))
+			return nil, status.Errorf(codes.Internal, "failed to detach loop device: %v", err)
+		}
+	}
+	// Never recurse during cleanup; anything left in the staging directory
+	// after unmounting is not ours to delete.
+	if err := os.Remove(stagingPath); err != nil && !os.IsNotExist(err) {
+		Logger(ctx).Error("NodeUnstageVolume failed to remove staging target path", zap.Error(err))
+		return nil, status.Errorf(codes.Internal, "failed to remove staging target path: %v", err)
+	}
+	if err := n.removeSecretFiles(ctx, req.GetVolumeId()); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to remove secret files: %v", err)
+	}
+	if err := n.state.deleteStage(req.GetVolumeId()); err != nil {
+		Logger(ctx).Error("NodeUnstageVolume failed to remove stage state", zap.Error(err))
+		return nil, status.Errorf(codes.Internal, "failed to remove stage state: %v", err)
+	}
+
+	// Return success response
+	Logger(ctx).Info("NodeUnstageVolume complete")
+	return &csi.NodeUnstageVolumeResponse{}, nil
+}
+
+// unmountStagingPath removes every mount layer at the staging path. It refuses
+// while publish targets still bind the staging mount, unless the staging mount
+// or those binds are disconnected, in which case the binds are unmounted too.
+// Submounts a recursive bind carried below the staging path go first.
+// A path that is not mounted, or no longer exists, is already unstaged.
+func (n *Node) unmountStagingPath(ctx context.Context, stagingPath string, recursive bool) error {
+	isMounted, err := n.mounter.IsMountPoint(stagingPath)
+	if err != nil && !os.IsNotExist(err) {
+		Logger(ctx).Error("NodeUnstageVolume failed to check staging mountpoint", zap.Error(err))
+		return status.Errorf(codes.Internal, "failed to verify staging target path mountpoint: %v", err)
+	}
+	if !isMounted {
+		Logger(ctx).Info("NodeUnstageVolume staging target path is not mounted")
+		return nil
+	}
+
+	live, err := n.liveDependentMounts(stagingPath)
+	if err != nil {
+		Logger(ctx).Error("NodeUnstageVolume failed to check dependent bind mounts", zap.Error(err))
+		return status.Errorf(codes.Internal, "failed to check dependent bind mounts: %v", err)
+	}
+	if len(live) > 0 {
+		Logger(ctx).Error("NodeUnstageVolume staging target path is still published",
+			zap.Strings("target_paths", live),
+		)
+		return status.Errorf(codes.FailedPrecondition, "staging target path is still bind-mounted at %s", strings.Join(live, ", "))
+	}
+	if err := n.unmountDependentMounts(ctx, stagingPath); err != nil {
+		Logger(ctx).Error("NodeUnstageVolume failed to unmount disconnected bind mounts", zap.Error(err))
+		return status.Errorf(codes.Internal, "failed to unmount disconnected bind mounts: %v", err)
+	}
+	if recursive {
+		if err := n.unmountSubmounts(ctx, stagingPath); err != nil {
+			Logger(ctx).Error("NodeUnstageVolume failed to unmount submounts", zap.Error(err))
+			return status.Errorf(codes.Internal, "failed to unmount submounts of staging target path: %v", err)
+		}
+	}
+	if err := n.unmountAllAtPath(ctx, stagingPath); err != nil {
+		Logger(ctx).Error("NodeUnstageVolume failed to unmount staging target path", zap.Error(err))
+		return status.Errorf(codes.Internal, "failed to unmount staging target path: %v", err)
+	}
+	return nil
+}
+
+// mountStaging mounts the recorded source at the staging path, falling back to
+// the mount helper when the kernel does not know the filesystem type.
+func (n *Node) mountStaging(ctx context.Context, rec *volumeRecord) error {
+	if rec.FsType == bindFsType {
+		return n.mountBindStaging(ctx, rec)
+	}
+	source := rec.Source
+	if rec.Loop {
+		if err := ensureLoopDevice(ctx, rec); err != nil {
+			Logger(ctx).Error("failed to attach loop device", zap.String("image", rec.Source), zap.Error(err))
+			return status.Errorf(codes.Internal, "failed to attach loop device: %v", err)
+		}
+		source = rec.LoopDevice
+	}
+	if err := n.formatIfBlank(ctx, *rec, source); err != nil {
+		return err
+	}
+	if err := n.checkFilesystem(ctx, *rec, source); err != nil {
+		return err
+	}
+	volumePath := rec.StagingTargetPath
+	fsType := rec.FsType
+	opts := rec.MountOptions
+	flags := rec.MountFlags
+	mountOpts, data, err := n.resolveMountOptions(ctx, *rec)
+	if err != nil {
+		Logger(ctx).Error("failed to resolve secret references", zap.String("target", volumePath), zap.Error(err))
+		return status.Errorf(codes.FailedPrecondition, "failed to resolve secret references: %v", err)
 	}
-	data := strings.Join(dataOpts, ",")
 
-	// Perform the mount operation with the specified fsType
 	err = n.mounter.Mount(source, volumePath, fsType, flags, data)
 	if err != nil {
 		if isNoSuchDevice(err) {
@@ -159,7 +481,27 @@ func (n *Node) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolumeRequ
 				zap.String("target", volumePath),
 				zap.String("opts", opts),
 			)
-			out, execErr := mountHelper(fsType, source, volumePath, opts)
+			helperCtx, cancel := n.mountHelperContext(ctx)
+			out, execErr := mountHelper(helperCtx, fsType, source, volumePath, mountOpts)
+			helperErr := helperCtx.Err()
+			cancel()
+			if execErr != nil && helperErr != nil {
+				Logger(ctx).Error("mount helper did not finish",
+					zap.String("fs_type", fsType),
+					zap.String("source", source),
+					zap.String("target", volumePath),
+					zap.String("output", out),
+					zap.Error(helperErr),
+				)
+				if err := n.cleanupPartialMount(ctx, volumePath); err != nil {
+					Logger(ctx).Error("failed to clean up partial mount", zap.String("target", volumePath), zap.Error(err))
+				}
+				code := codes.DeadlineExceeded
+				if errors.Is(helperErr, context.Canceled) {
+					code = codes.Canceled
+				}
+				return status.Errorf(code, "mount helper for fsType %q did not finish: %v", fsType, helperErr)
+			}
 			if execErr != nil {
 				Logger(ctx).Error("mount helper failed",
 					zap.String("fs_type", fsType),
@@ -169,7 +511,7 @@ func (n *Node) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolumeRequ
 					zap.String("output", out),
 					zap.Error(execErr),
 				)
-				return nil, status.Errorf(
+				return status.Errorf(
 					codes.Internal,
 					"failed to mount volume (fsType=%q): syscall mount returned ENODEV and helper failed; ensure mount.%s is installed in the node image and /dev/fuse is available, or ensure kernel support for %s. helper error: %v",
 					fsType,
@@ -185,9 +527,6 @@ func (n *Node) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolumeRequ
 				zap.String("opts", opts),
 				zap.String("output", out),
 			)
-			logMountInfo(ctx, volumePath, "mountinfo after helper")
-			time.Sleep(1 * time.Second)
-			logMountInfo(ctx, volumePath, "mountinfo after helper delay")
 		} else {
 			Logger(ctx).Error("mount failed",
 				zap.String("fs_type", fsType),
@@ -197,58 +536,75 @@ func (n *Node) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolumeRequ
 				zap.Error(err),
 			)
 			if isPermissionError(err) {
-				return nil, status.Errorf(
+				return status.Errorf(
 					codes.Internal,
 					"failed to mount volume (fsType=%q): permission denied; ensure the node plugin has CAP_SYS_ADMIN (or privileged), and /dev/fuse is available for FUSE filesystems. mount error: %v",
 					fsType,
 					err,
 				)
 			}
-			return nil, status.Errorf(codes.Internal, "failed to mount volume (fsType=%q): %v", fsType, err)
+			return status.Errorf(codes.Internal, "failed to mount volume (fsType=%q): %v", fsType, err)
 		}
 	}
-	logMountInfo(ctx, volumePath, "mountinfo after syscall mount")
-	time.Sleep(1 * time.Second)
-	logMountInfo(ctx, volumePath, "mountinfo after syscall mount delay")
-
-	// Re-apply file mode after mounting, as mount may override permissions
-	if err := os.Chmod(volumePath, fileMode); err != nil {
-		Logger(ctx).Error("NodeStageVolume failed to set file mode", zap.Error(err))
-		return nil, status.Errorf(codes.Internal, "failed to set file mode after mount: %v", err)
+	if err := n.waitForMount(ctx, volumePath, true); err != nil {
+		Logger(ctx).Error("staging mount did not become ready", zap.String("target", volumePath), zap.Error(err))
+		return err
 	}
-
-	// Return success if mounting succeeded
-	Logger(ctx).Info("NodeStageVolume complete")
-	return &csi.NodeStageVolumeResponse{}, nil
+	logMountInfo(ctx, volumePath, "mountinfo after mount")
+	if err := n.seedScratchVolume(ctx, *rec); err != nil {
+		// A half-seeded volume is not left behind, or a retry would find it
+		// mounted and skip seeding.
+		if unmountErr := n.unmountAllAtPath(ctx, volumePath); unmountErr != nil {
+			Logger(ctx).Error("failed to unmount unseeded scratch volume", zap.String("target", volumePath), zap.Error(unmountErr))
+		}
+		return err
+	}
+	if rec.MountPropagation != 0 {
+		if err := n.mounter.Mount("", volumePath, "", rec.MountPropagation, ""); err != nil {
+			Logger(ctx).Error("failed to set mount propagation", zap.String("target", volumePath), zap.Error(err))
+			return status.Errorf(codes.Internal, "failed to set mount propagation: %v", err)
+		}
+	}
+	return nil
 }
 
-func (n *Node) NodeUnstageVolume(ctx context.Context, req *csi.NodeUnstageVolumeRequest) (*csi.NodeUnstageVolumeResponse, error) {
-	Logger(ctx).Info("NodeUnstageVolume start",
-		zap.String("volume_id", req.GetVolumeId()),
-		zap.String("staging_target_path", req.GetStagingTargetPath()),
+// mergedMountOptions combines the capability mount flags, which come from the
+// PV or StorageClass mountOptions, with the mountOptions volume attribute. The
+// attribute takes precedence where both set the same flag or data key.
+func mergedMountOptions(capability *csi.VolumeCapability, volumeContext map[string]string) string {
+	return mountopts.Merge(
+		strings.Join(capability.GetMount().GetMountFlags(), ","),
+		volumeContext["mountOptions"],
 	)
-	// Check if volume_id is provided
-	if req.GetVolumeId() == "" {
-		Logger(ctx).Error("NodeUnstageVolume invalid argument: volume_id is required")
-		return nil, status.Error(codes.InvalidArgument, "volume_id is required")
-	}
+}
 
-	// Check if staging_target_path is provided
-	if req.GetStagingTargetPath() == "" {
-		Logger(ctx).Error("NodeUnstageVolume invalid argument: staging_target_path is required")
-		return nil, status.Error(codes.InvalidArgument, "staging_target_path is required")
+// resolveMountOptions returns the helper option string and mount data for rec
+// with secret references expanded. Records loaded from disk carry no secret
+// values, so only references to secret files that still exist can be resolved.
+func (n *Node) resolveMountOptions(ctx context.Context, rec volumeRecord) (string, string, error) {
+	if !hasSecretRefs(rec.MountOptions) {
+		return rec.MountOptions, rec.MountData, nil
 	}
-
-	// Attempt to unmount the staging target path
-	err := n.mounter.Unmount(req.GetStagingTargetPath(), 0)
+	if rec.resolvedOptions != "" {
+		return rec.resolvedOptions, rec.resolvedData, nil
+	}
+	opts, err := n.expandSecretRefs(ctx, rec.VolumeID, rec.MountOptions, nil)
 	if err != nil {
-		Logger(ctx).Error("NodeUnstageVolume failed to unmount staging target path", zap.Error(err))
-		return nil, status.Errorf(codes.Internal, "failed to unmount staging target path: %v", err)
+		return "", "", err
+	}
+	data, err := n.expandSecretRefs(ctx, rec.VolumeID, rec.MountData, nil)
+	if err != nil {
+		return "", "", err
 	}
+	return opts, data, nil
+}
 
-	// Return success response
-	Logger(ctx).Info("NodeUnstageVolume complete")
-	return &csi.NodeUnstageVolumeResponse{}, nil
+func (n *Node) saveStageState(ctx context.Context, record volumeRecord) error {
+	if err := n.state.putStage(record); err != nil {
+		Logger(ctx).Error("NodeStageVolume failed to persist stage state", zap.Error(err))
+		return status.Errorf(codes.Internal, "failed to persist stage state: %v", err)
+	}
+	return nil
 }
 
 func isNoSuchDevice(err error) bool {
@@ -258,15 +614,66 @@ func isNoSuchDevice(err error) bool {
 	return strings.Contains(strings.ToLower(err.Error()), "no such device")
 }
 
-func execMountHelper(fsType, source, target, opts string) (string, error) {
+// defaultMountHelperTimeout bounds a mount helper run when
+// WithMountHelperTimeout is not given.
+const defaultMountHelperTimeout = 2 * time.Minute
+
+// mountHelperWaitDelay bounds how long a process group command waits for
+// output pipes held open by its descendants after the group is killed.
+const mountHelperWaitDelay = 5 * time.Second
+
+// mountHelperContext bounds a mount helper run by the node's mount helper
+// timeout in addition to ctx.
+func (n *Node) mountHelperContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if n.mountHelperTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, n.mountHelperTimeout)
+}
+
+// cleanupPartialMount lazily detaches anything an interrupted mount helper
+// left at path. A lazy detach is used because the server the helper was
+// waiting on may still be unreachable.
+func (n *Node) cleanupPartialMount(ctx context.Context, path string) error {
+	for i := 0; i < 10; i++ {
+		mounted, err := n.mounter.IsMountPoint(path)
+		if err != nil || !mounted {
+			return err
+		}
+		Logger(ctx).Info("detaching partial mount", zap.String("path", path), zap.Int("attempt", i+1))
+		if err := n.mounter.Unmount(path, syscall.MNT_DETACH); err != nil && !errors.Is(err, syscall.EINVAL) {
+			return err
+		}
+	}
+	return fmt.Errorf("%q remains mounted after detach attempts", path)
+}
+
+// newProcessGroupCommand prepares name to run in its own process group, so
+// that it and any children it forks are killed together when ctx ends.
+func newProcessGroupCommand(ctx context.Context, name string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	cmd.WaitDelay = mountHelperWaitDelay
+	return cmd
+}
+
+// execMountHelper runs mount(8) in its own process group so that the helper
+// and any mount.<type> children it forked are killed together when ctx ends.
+func execMountHelper(ctx context.Context, fsType, source, target, opts string) (string, error) {
 	args := []string{"-t", fsType}
 	if strings.TrimSpace(opts) != "" {
 		args = append(args, "-o", opts)
 	}
 	args = append(args, source, target)
-	cmd := exec.Command("mount", args...)
+	cmd := newProcessGroupCommand(ctx, "mount", args...)
 	out, err := cmd.CombinedOutput()
 	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return strings.TrimSpace(string(out)), fmt.Errorf("mount helper killed: %w", ctxErr)
+		}
 		if errors.Is(err, exec.ErrNotFound) {
 			return strings.TrimSpace(string(out)), fmt.Errorf("mount helper not found in PATH (mount/mount.%s): %w", fsType, err)
 		}
@@ -287,7 +694,7 @@ func logMountInfo(ctx context.Context, path, message string) {
 }
 
 func mountInfoSample(limit int) []string {
-	data, err := os.ReadFile("/proc/self/mountinfo")
+	data, err := readMountInfo()
 	if err != nil {
 		return []string{fmt.Sprintf("read mountinfo failed: %v", err)}
 	}
@@ -307,6 +714,80 @@ func mountInfoSample(limit int) []string {
 
 var mountHelper = execMountHelper
 
+// parseOwnerID parses an optional numeric uid or gid attribute.
+func parseOwnerID(v string) (int, error) {
+	if v == "" {
+		return -1, nil
+	}
+	id, err := strconv.Atoi(v)
+	if err != nil || id < 0 {
+		return -1, fmt.Errorf("%q is not a numeric ID", v)
+	}
+	return id, nil
+}
+
+// checkMountOperation refuses mount options that turn the staging mount into
+// another mount(2) operation. remount and move act on existing mounts, and
+// bind or rbind would bind any host path the PV names, so they are only
+// accepted for fsType bind, which checks the source against the allowed bind
+// paths.
+func checkMountOperation(fsType string, flags uintptr) error {
+	if flags&(syscall.MS_REMOUNT|syscall.MS_MOVE) != 0 {
+		return errors.New("must not contain remount or move")
+	}
+	if flags&syscall.MS_BIND != 0 && fsType != bindFsType {
+		return fmt.Errorf("must not contain bind or rbind unless fsType is %s", bindFsType)
+	}
+	return nil
+}
+
+// applyStagingPermissions applies the recorded uid, gid and fileMode to the
+// staging root. Unset attributes leave the filesystem's own values, and
+// read-only mounts are skipped since they cannot be changed.
+func applyStagingPermissions(ctx context.Context, rec volumeRecord) error {
+	if rec.FileMode == "" && rec.UID == "" && rec.GID == "" {
+		return nil
+	}
+	if rec.MountFlags&syscall.MS_RDONLY != 0 {
+		Logger(ctx).Info("skipping fileMode, uid and gid on read-only staging mount")
+		return nil
+	}
+	uid, err := parseOwnerID(rec.UID)
+	if err != nil {
+		return err
+	}
+	gid, err := parseOwnerID(rec.GID)
+	if err != nil {
+		return err
+	}
+	if uid >= 0 || gid >= 0 {
+		if err := os.Chown(rec.StagingTargetPath, uid, gid); err != nil {
+			return err
+		}
+	}
+	// chown clears setuid and setgid, so the mode goes last.
+	if rec.FileMode != "" {
+		mode, err := strconv.ParseUint(rec.FileMode, 8, 32)
+		if err != nil {
+			return err
+		}
+		if err := syscall.Chmod(rec.StagingTargetPath, uint32(mode)); err != nil {
+			return &os.PathError{Op: "chmod", Path: rec.StagingTargetPath, Err: err}
+		}
+	}
+	return nil
+}
+
+// isPermissionChangeRefused reports whether the filesystem, rather than the
+// node, rejected a chmod or chown.
+func isPermissionChangeRefused(err error) bool {
+	return errors.Is(err, syscall.EPERM) ||
+		errors.Is(err, syscall.EACCES) ||
+		errors.Is(err, syscall.EROFS) ||
+		errors.Is(err, syscall.EOPNOTSUPP) ||
+		errors.Is(err, syscall.ENOSYS)
+}
+
 func isPermissionError(err error) bool {
 	if errors.Is(err, syscall.EPERM) || errors.Is(err, syscall.EACCES) {
 		return true
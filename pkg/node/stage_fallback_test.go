@@ -3,36 +3,64 @@ package node
 import (
 	"context"
 	"errors"
+	"os"
 	"path/filepath"
 	"syscall"
 	"testing"
+	"time"
 
 	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
+// stageRequest returns a NodeStageVolumeRequest for a mount volume of fsType.
+func stageRequest(stagingPath, fsType string, volumeContext map[string]string) *csi.NodeStageVolumeRequest {
+	return &csi.NodeStageVolumeRequest{
+		VolumeId:          "test-volume",
+		StagingTargetPath: stagingPath,
+		VolumeCapability: &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Mount{
+				Mount: &csi.VolumeCapability_MountVolume{FsType: fsType},
+			},
+		},
+		VolumeContext: volumeContext,
+	}
+}
+
+// stubMounter fails every syscall mount with mountErr. Paths mounted by a
+// stubbed mount helper are recorded in mounted.
 type stubMounter struct {
 	mountErr error
+	mounted  map[string]bool
 }
 
-func (s stubMounter) Mount(source, target, fstype string, flags uintptr, data string) error {
+func newStubMounter(mountErr error) *stubMounter {
+	return &stubMounter{mountErr: mountErr, mounted: map[string]bool{}}
+}
+
+func (s *stubMounter) Mount(source, target, fstype string, flags uintptr, data string) error {
 	return s.mountErr
 }
 
-func (s stubMounter) Unmount(target string, flags int) error {
+func (s *stubMounter) Unmount(target string, flags int) error {
+	delete(s.mounted, target)
 	return nil
 }
 
-func (s stubMounter) IsMountPoint(path string) (bool, error) {
-	return false, nil
+func (s *stubMounter) IsMountPoint(path string) (bool, error) {
+	return s.mounted[path], nil
 }
 
 func TestNodeStageVolumeExecFallback(t *testing.T) {
 	ctx := context.Background()
 	stagePath := filepath.Join(t.TempDir(), "stage")
 
+	mounter := newStubMounter(syscall.ENODEV)
 	var gotType, gotSource, gotTarget, gotOpts string
 	origHelper := mountHelper
-	mountHelper = func(fsType, source, target, opts string) (string, error) {
+	mountHelper = func(_ context.Context, fsType, source, target, opts string) (string, error) {
+		mounter.mounted[target] = true
 		gotType = fsType
 		gotSource = source
 		gotTarget = target
@@ -41,7 +69,7 @@ func TestNodeStageVolumeExecFallback(t *testing.T) {
 	}
 	t.Cleanup(func() { mountHelper = origHelper })
 
-	n := NewNodeWithMounter("node-1", "endpoint", stubMounter{mountErr: syscall.ENODEV})
+	n := NewNodeWithMounter("node-1", "endpoint", mounter)
 	req := &csi.NodeStageVolumeRequest{
 		VolumeId:          "vol-1",
 		StagingTargetPath: stagePath,
@@ -72,13 +100,13 @@ func TestNodeStageVolumeNoExecFallbackOnOtherError(t *testing.T) {
 
 	origHelper := mountHelper
 	called := false
-	mountHelper = func(fsType, source, target, opts string) (string, error) {
+	mountHelper = func(_ context.Context, fsType, source, target, opts string) (string, error) {
 		called = true
 		return "", nil
 	}
 	t.Cleanup(func() { mountHelper = origHelper })
 
-	n := NewNodeWithMounter("node-1", "endpoint", stubMounter{mountErr: errors.New("boom")})
+	n := NewNodeWithMounter("node-1", "endpoint", newStubMounter(errors.New("boom")))
 	req := &csi.NodeStageVolumeRequest{
 		VolumeId:          "vol-1",
 		StagingTargetPath: stagePath,
@@ -100,3 +128,103 @@ func TestNodeStageVolumeNoExecFallbackOnOtherError(t *testing.T) {
 		t.Fatalf("mount helper should not be called for non-ENODEV errors")
 	}
 }
+
+func TestNodeStageVolumeMergesCapabilityMountFlags(t *testing.T) {
+	mounter := newStubMounter(syscall.ENODEV)
+	var gotOpts string
+	origHelper := mountHelper
+	mountHelper = func(_ context.Context, fsType, source, target, opts string) (string, error) {
+		mounter.mounted[target] = true
+		gotOpts = opts
+		return "ok", nil
+	}
+	t.Cleanup(func() { mountHelper = origHelper })
+
+	n := NewNodeWithMounter("node-1", "endpoint", mounter)
+	req := &csi.NodeStageVolumeRequest{
+		VolumeId:          "vol-1",
+		StagingTargetPath: filepath.Join(t.TempDir(), "stage"),
+		VolumeCapability: &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Mount{
+				Mount: &csi.VolumeCapability_MountVolume{
+					FsType:     "glusterfs",
+					MountFlags: []string{"nosuid", "uid=1000,ro"},
+				},
+			},
+		},
+		VolumeContext: map[string]string{
+			"fileMode":     "0755",
+			"source":       "gluster:media",
+			"mountOptions": "uid=0,rw",
+		},
+	}
+
+	if _, err := n.NodeStageVolume(context.Background(), req); err != nil {
+		t.Fatalf("NodeStageVolume() error = %v", err)
+	}
+	if want := "nosuid,uid=0,rw"; gotOpts != want {
+		t.Fatalf("mount helper opts = %q, want %q", gotOpts, want)
+	}
+	rec, _ := n.state.get("vol-1")
+	if rec.MountFlags != syscall.MS_NOSUID || rec.MountData != "uid=0" {
+		t.Fatalf("stage record flags = %#x data = %q, want %#x %q", rec.MountFlags, rec.MountData, syscall.MS_NOSUID, "uid=0")
+	}
+}
+
+func TestNodeStageVolumeMountHelperTimeout(t *testing.T) {
+	stagePath := filepath.Join(t.TempDir(), "stage")
+	mounter := newStubMounter(syscall.ENODEV)
+	origHelper := mountHelper
+	mountHelper = func(ctx context.Context, fsType, source, target, opts string) (string, error) {
+		// A helper that mounted but then hung waiting on the server.
+		mounter.mounted[target] = true
+		<-ctx.Done()
+		return "", ctx.Err()
+	}
+	t.Cleanup(func() { mountHelper = origHelper })
+
+	n := NewNodeWithMounter("node-1", "endpoint", mounter, WithMountHelperTimeout(50*time.Millisecond))
+	req := &csi.NodeStageVolumeRequest{
+		VolumeId:          "vol-1",
+		StagingTargetPath: stagePath,
+		VolumeCapability: &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Mount{
+				Mount: &csi.VolumeCapability_MountVolume{FsType: "glusterfs"},
+			},
+		},
+		VolumeContext: map[string]string{"source": "gluster:media"},
+	}
+
+	_, err := n.NodeStageVolume(context.Background(), req)
+	if status.Code(err) != codes.DeadlineExceeded {
+		t.Fatalf("NodeStageVolume() error = %v, want DeadlineExceeded", err)
+	}
+	if mounter.mounted[stagePath] {
+		t.Fatalf("NodeStageVolume() left the partial mount at %s", stagePath)
+	}
+	if _, ok := n.state.get("vol-1"); ok {
+		t.Fatalf("NodeStageVolume() recorded stage state for a failed mount")
+	}
+}
+
+func TestExecMountHelperKillsProcessGroup(t *testing.T) {
+	bin := t.TempDir()
+	// The fake helper forks a child that keeps the output pipe open, as
+	// mount.<type> helpers do while waiting on a server.
+	script := "#!/bin/sh\nsleep 60 &\nsleep 60\n"
+	if err := os.WriteFile(filepath.Join(bin, "mount"), []byte(script), 0755); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	t.Setenv("PATH", bin+":"+os.Getenv("PATH"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := execMountHelper(ctx, "glusterfs", "gluster:media", t.TempDir(), "")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("execMountHelper() error = %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed >= mountHelperWaitDelay {
+		t.Fatalf("execMountHelper() took %s, want the process group killed before the wait delay", elapsed)
+	}
+}
@@ -58,7 +58,7 @@ func TestNodeStageVolume(t *testing.T) {
 			stagingPath:     stagingPath,
 		},
 		{
-			name:     "Missing fileMode in VolumeContext",
+			name:     "Missing fileMode keeps the filesystem mode",
 			volumeID: "test-volume",
 			volumeCapability: &csi.VolumeCapability{
 				AccessType: &csi.VolumeCapability_Mount{
@@ -71,9 +71,9 @@ func TestNodeStageVolume(t *testing.T) {
 				},
 			},
 			fsType:          "tmpfs",
-			fileMode:        "", // Missing fileMode should trigger an error
+			fileMode:        "", // fileMode is optional
 			source:          "tmpfs",
-			expectErrorCode: codes.InvalidArgument,
+			expectErrorCode: codes.OK,
 			stagingPath:     stagingPath,
 		},
 		{
@@ -133,6 +133,86 @@ func TestNodeStageVolume(t *testing.T) {
 			expectErrorCode: codes.InvalidArgument,
 			stagingPath:     stagingPath,
 		},
+		{
+			name:     "Conflicting mount options",
+			volumeID: "test-volume",
+			volumeCapability: &csi.VolumeCapability{
+				AccessType: &csi.VolumeCapability_Mount{
+					Mount: &csi.VolumeCapability_MountVolume{
+						FsType: "tmpfs",
+					},
+				},
+				AccessMode: &csi.VolumeCapability_AccessMode{
+					Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+				},
+			},
+			fsType:          "tmpfs",
+			fileMode:        "0755",
+			source:          "tmpfs",
+			mountOptions:    "ro,rw",
+			expectErrorCode: codes.InvalidArgument,
+			stagingPath:     stagingPath,
+		},
+		{
+			name:     "Bind mount option on another fsType",
+			volumeID: "test-volume",
+			volumeCapability: &csi.VolumeCapability{
+				AccessType: &csi.VolumeCapability_Mount{
+					Mount: &csi.VolumeCapability_MountVolume{
+						FsType: "tmpfs",
+					},
+				},
+				AccessMode: &csi.VolumeCapability_AccessMode{
+					Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+				},
+			},
+			fsType:          "tmpfs",
+			fileMode:        "0755",
+			source:          "/etc",
+			mountOptions:    "bind",
+			expectErrorCode: codes.InvalidArgument,
+			stagingPath:     stagingPath,
+		},
+		{
+			name:     "Rbind mount option on another fsType",
+			volumeID: "test-volume",
+			volumeCapability: &csi.VolumeCapability{
+				AccessType: &csi.VolumeCapability_Mount{
+					Mount: &csi.VolumeCapability_MountVolume{
+						FsType: "tmpfs",
+					},
+				},
+				AccessMode: &csi.VolumeCapability_AccessMode{
+					Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+				},
+			},
+			fsType:          "tmpfs",
+			fileMode:        "0755",
+			source:          "/etc",
+			mountOptions:    "rbind",
+			expectErrorCode: codes.InvalidArgument,
+			stagingPath:     stagingPath,
+		},
+		{
+			name:     "Remount mount option",
+			volumeID: "test-volume",
+			volumeCapability: &csi.VolumeCapability{
+				AccessType: &csi.VolumeCapability_Mount{
+					Mount: &csi.VolumeCapability_MountVolume{
+						FsType: "tmpfs",
+					},
+				},
+				AccessMode: &csi.VolumeCapability_AccessMode{
+					Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+				},
+			},
+			fsType:          "tmpfs",
+			fileMode:        "0755",
+			source:          "/etc",
+			mountOptions:    "remount",
+			expectErrorCode: codes.InvalidArgument,
+			stagingPath:     stagingPath,
+		},
 	}
 
 	for _, tc := range tests {
@@ -201,6 +281,7 @@ func TestNodeUnstageVolume(t *testing.T) {
 		name              string
 		volumeID          string
 		stagingTargetPath string
+		notMounted        bool
 		expectErrorCode   codes.Code
 	}{
 		{
@@ -209,6 +290,13 @@ func TestNodeUnstageVolume(t *testing.T) {
 			stagingTargetPath: stagingPath, // Temp directory will be created dynamically
 			expectErrorCode:   codes.OK,
 		},
+		{
+			name:              "Repeated unstage of removed staging path",
+			volumeID:          "test-volume",
+			stagingTargetPath: stagingPath,
+			notMounted:        true,
+			expectErrorCode:   codes.OK,
+		},
 		{
 			name:              "Missing volumeID",
 			volumeID:          "",
@@ -227,7 +315,7 @@ func TestNodeUnstageVolume(t *testing.T) {
 		t.Run(tc.name, func(t *testing.T) {
 
 			// Simulate mounting for the valid test case
-			if tc.expectErrorCode == codes.OK {
+			if tc.expectErrorCode == codes.OK && !tc.notMounted {
 				_ = fake.Mount("tmpfs", stagingPath, "tmpfs", 0, "")
 				tc.stagingTargetPath = stagingPath
 			}
@@ -245,6 +333,8 @@ func TestNodeUnstageVolume(t *testing.T) {
 				isMounted, err := fake.IsMountPoint(stagingPath)
 				assert.NoError(t, err)
 				assert.False(t, isMounted, "The volume mount path should be unmounted")
+				_, err = os.Stat(stagingPath)
+				assert.True(t, os.IsNotExist(err), "The staging path should be removed")
 			} else {
 				st, _ := status.FromError(err)
 				assert.Equal(t, tc.expectErrorCode, st.Code())
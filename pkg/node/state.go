@@ -0,0 +1,348 @@
+package node
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+)
+
+const volumeRecordSuffix = ".json"
+
+// volumeRecord is the durable description of a staged volume. One record is
+// kept per volume ID so the plugin can find its mounts again after a restart.
+type volumeRecord struct {
+	VolumeID          string                   `json:"volumeId"`
+	StagingTargetPath string                   `json:"stagingTargetPath"`
+	Source            string                   `json:"source,omitempty"`
+	FsType            string                   `json:"fsType,omitempty"`
+	Block             bool                     `json:"block,omitempty"`
+	Loop              bool                     `json:"loop,omitempty"`
+	LoopDevice        string                   `json:"loopDevice,omitempty"`
+	MountOptions      string                   `json:"mountOptions,omitempty"`
+	MountFlags        uintptr                  `json:"mountFlags,omitempty"`
+	MountPropagation  uintptr                  `json:"mountPropagation,omitempty"`
+	MountData         string                   `json:"mountData,omitempty"`
+	FileMode          string                   `json:"fileMode,omitempty"`
+	UID               string                   `json:"uid,omitempty"`
+	GID               string                   `json:"gid,omitempty"`
+	MountGroup        string                   `json:"mountGroup,omitempty"`
+	SizeLimit         int64                    `json:"sizeLimit,omitempty"`
+	InodeLimit        int64                    `json:"inodeLimit,omitempty"`
+	Layers            []volumeRecord           `json:"layers,omitempty"`
+	VolumeContext     map[string]string        `json:"volumeContext,omitempty"`
+	Publishes         map[string]publishRecord `json:"publishes,omitempty"`
+	StagedAt          time.Time                `json:"stagedAt"`
+
+	// resolvedOptions and resolvedData are MountOptions and MountData with
+	// secret references expanded. They are kept in memory only so secret
+	// values never reach the state directory.
+	resolvedOptions string
+	resolvedData    string
+}
+
+// publishRecord is the durable description of a bind mount published from a
+// staged volume.
+type publishRecord struct {
+	TargetPath    string            `json:"targetPath"`
+	Readonly      bool              `json:"readonly,omitempty"`
+	MountFlags    uintptr           `json:"mountFlags,omitempty"`
+	UpperDir      string            `json:"upperDir,omitempty"`
+	VolumeContext map[string]string `json:"volumeContext,omitempty"`
+}
+
+// publishRequests rebuilds the publish requests recorded for the volume,
+// ordered by target path.
+func (r volumeRecord) publishRequests() []*csi.NodePublishVolumeRequest {
+	targets := make([]string, 0, len(r.Publishes))
+	for target := range r.Publishes {
+		targets = append(targets, target)
+	}
+	sort.Strings(targets)
+	reqs := make([]*csi.NodePublishVolumeRequest, 0, len(targets))
+	for _, target := range targets {
+		pub := r.Publishes[target]
+		reqs = append(reqs, &csi.NodePublishVolumeRequest{
+			VolumeId:          r.VolumeID,
+			StagingTargetPath: r.StagingTargetPath,
+			TargetPath:        pub.TargetPath,
+			Readonly:          pub.Readonly,
+			VolumeContext:     pub.VolumeContext,
+		})
+	}
+	return reqs
+}
+
+// stateStore indexes volume records in memory and, when dir is set, mirrors
+// each record to <dir>/<volume id>.json.
+type stateStore struct {
+	dir string
+
+	mu      sync.Mutex
+	records map[string]*volumeRecord
+}
+
+func newStateStore(dir string) *stateStore {
+	return &stateStore{
+		dir:     dir,
+		records: map[string]*volumeRecord{},
+	}
+}
+
+// load replaces the in-memory index with the records found on disk. Records
+// that cannot be decoded are skipped and returned as warnings.
+func (s *stateStore) load() ([]error, error) {
+	if s.dir == "" {
+		return nil, nil
+	}
+	if err := os.MkdirAll(s.dir, 0700); err != nil {
+		return nil, fmt.Errorf("create state dir %q: %w", s.dir, err)
+	}
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("read state dir %q: %w", s.dir, err)
+	}
+
+	var warnings []error
+	records := map[string]*volumeRecord{}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), volumeRecordSuffix) {
+			continue
+		}
+		path := filepath.Join(s.dir, entry.Name())
+		data, err := os.ReadFile(path)
+		if err != nil {
+			warnings = append(warnings, fmt.Errorf("read %q: %w", path, err))
+			continue
+		}
+		var rec volumeRecord
+		if err := json.Unmarshal(data, &rec); err != nil {
+			warnings = append(warnings, fmt.Errorf("decode %q: %w", path, err))
+			continue
+		}
+		if rec.VolumeID == "" {
+			warnings = append(warnings, fmt.Errorf("decode %q: missing volume id", path))
+			continue
+		}
+		records[rec.VolumeID] = &rec
+	}
+
+	s.mu.Lock()
+	s.records = records
+	s.mu.Unlock()
+	return warnings, nil
+}
+
+// get returns a copy of the record for volumeID.
+func (s *stateStore) get(volumeID string) (volumeRecord, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rec, ok := s.records[volumeID]
+	if !ok {
+		return volumeRecord{}, false
+	}
+	return rec.clone(), true
+}
+
+// getByStagingPath returns a copy of the record staged at path.
+func (s *stateStore) getByStagingPath(path string) (volumeRecord, bool) {
+	cleaned := filepath.Clean(path)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, rec := range s.records {
+		if filepath.Clean(rec.StagingTargetPath) == cleaned {
+			return rec.clone(), true
+		}
+	}
+	return volumeRecord{}, false
+}
+
+// volumeForPath returns the ID of the volume staged, published or holding an
+// overlay layer at path.
+func (s *stateStore) volumeForPath(path string) (string, bool) {
+	cleaned := filepath.Clean(path)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, rec := range s.records {
+		if filepath.Clean(rec.StagingTargetPath) == cleaned {
+			return rec.VolumeID, true
+		}
+		if _, ok := rec.Publishes[cleaned]; ok {
+			return rec.VolumeID, true
+		}
+		for _, layer := range rec.Layers {
+			if filepath.Clean(layer.StagingTargetPath) == cleaned {
+				return rec.VolumeID, true
+			}
+		}
+	}
+	return "", false
+}
+
+// list returns copies of all records ordered by staging path.
+func (s *stateStore) list() []volumeRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]volumeRecord, 0, len(s.records))
+	for _, rec := range s.records {
+		out = append(out, rec.clone())
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].StagingTargetPath < out[j].StagingTargetPath
+	})
+	return out
+}
+
+// putStage records a staged volume, keeping any publishes already known for it.
+func (s *stateStore) putStage(rec volumeRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rec = rec.clone()
+	if existing, ok := s.records[rec.VolumeID]; ok && len(rec.Publishes) == 0 {
+		rec.Publishes = existing.clone().Publishes
+	}
+	if rec.StagedAt.IsZero() {
+		rec.StagedAt = time.Now().UTC()
+	}
+	return s.persistLocked(&rec)
+}
+
+// deleteStage forgets a staged volume.
+func (s *stateStore) deleteStage(volumeID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.records[volumeID]; !ok {
+		return nil
+	}
+	if s.dir != "" {
+		if err := os.Remove(s.recordPath(volumeID)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("remove state for volume %q: %w", volumeID, err)
+		}
+	}
+	delete(s.records, volumeID)
+	return nil
+}
+
+// putPublish records a publish target. Volumes that were staged before state
+// was tracked get a minimal record.
+func (s *stateStore) putPublish(volumeID, stagingPath string, pub publishRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var rec volumeRecord
+	if existing, ok := s.records[volumeID]; ok {
+		rec = existing.clone()
+	} else {
+		rec = volumeRecord{
+			VolumeID:          volumeID,
+			StagingTargetPath: filepath.Clean(stagingPath),
+			StagedAt:          time.Now().UTC(),
+		}
+	}
+	if rec.Publishes == nil {
+		rec.Publishes = map[string]publishRecord{}
+	}
+	pub.TargetPath = filepath.Clean(pub.TargetPath)
+	rec.Publishes[pub.TargetPath] = pub
+	return s.persistLocked(&rec)
+}
+
+// getPublish returns the publish record for targetPath on whichever volume
+// holds it.
+func (s *stateStore) getPublish(targetPath string) (publishRecord, bool) {
+	targetPath = filepath.Clean(targetPath)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, rec := range s.records {
+		if pub, ok := rec.Publishes[targetPath]; ok {
+			pub.VolumeContext = maps.Clone(pub.VolumeContext)
+			return pub, true
+		}
+	}
+	return publishRecord{}, false
+}
+
+// deletePublish forgets a publish target on whichever volume holds it.
+func (s *stateStore) deletePublish(targetPath string) error {
+	targetPath = filepath.Clean(targetPath)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, existing := range s.records {
+		if _, ok := existing.Publishes[targetPath]; !ok {
+			continue
+		}
+		rec := existing.clone()
+		delete(rec.Publishes, targetPath)
+		if err := s.persistLocked(&rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *stateStore) persistLocked(rec *volumeRecord) error {
+	if s.dir != "" {
+		if err := writeFileAtomic(s.recordPath(rec.VolumeID), rec); err != nil {
+			return fmt.Errorf("write state for volume %q: %w", rec.VolumeID, err)
+		}
+	}
+	s.records[rec.VolumeID] = rec
+	return nil
+}
+
+func (s *stateStore) recordPath(volumeID string) string {
+	return filepath.Join(s.dir, url.PathEscape(volumeID)+volumeRecordSuffix)
+}
+
+func writeFileAtomic(path string, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (r volumeRecord) clone() volumeRecord {
+	out := r
+	out.VolumeContext = maps.Clone(r.VolumeContext)
+	if r.Layers != nil {
+		out.Layers = make([]volumeRecord, len(r.Layers))
+		for i, layer := range r.Layers {
+			out.Layers[i] = layer.clone()
+		}
+	}
+	if r.Publishes != nil {
+		out.Publishes = make(map[string]publishRecord, len(r.Publishes))
+		for target, pub := range r.Publishes {
+			pub.VolumeContext = maps.Clone(pub.VolumeContext)
+			out.Publishes[target] = pub
+		}
+	}
+	return out
+}
@@ -0,0 +1,150 @@
+package node
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+)
+
+func TestStateStorePersistsAndReloadsRecords(t *testing.T) {
+	dir := t.TempDir()
+	store := newStateStore(dir)
+
+	rec := volumeRecord{
+		VolumeID:          "ns/volume with space",
+		StagingTargetPath: "/var/lib/kubelet/plugins/justmount.csi.driver/vol/globalmount",
+		Source:            "gluster:media",
+		FsType:            "glusterfs",
+		MountOptions:      "rw,nosuid",
+		MountData:         "",
+		FileMode:          "0755",
+		VolumeContext:     map[string]string{"source": "gluster:media"},
+	}
+	if err := store.putStage(rec); err != nil {
+		t.Fatalf("putStage() error = %v", err)
+	}
+	if err := store.putPublish(rec.VolumeID, rec.StagingTargetPath, publishRecord{
+		TargetPath: "/var/lib/kubelet/pods/uid/volumes/kubernetes.io~csi/pv/mount/",
+		Readonly:   true,
+	}); err != nil {
+		t.Fatalf("putPublish() error = %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "corrupt.json"), []byte("{"), 0600); err != nil {
+		t.Fatalf("write corrupt record: %v", err)
+	}
+
+	reloaded := newStateStore(dir)
+	warnings, err := reloaded.load()
+	if err != nil {
+		t.Fatalf("load() error = %v", err)
+	}
+	if len(warnings) != 1 {
+		t.Fatalf("load() warnings = %v, want one for the corrupt record", warnings)
+	}
+
+	got, ok := reloaded.getByStagingPath(rec.StagingTargetPath + "/")
+	if !ok {
+		t.Fatalf("getByStagingPath() found = false, want true")
+	}
+	if got.Source != rec.Source || got.FsType != rec.FsType || got.MountOptions != rec.MountOptions {
+		t.Fatalf("reloaded record = %+v, want %+v", got, rec)
+	}
+	if got.StagedAt.IsZero() {
+		t.Fatalf("reloaded record StagedAt is zero")
+	}
+	reqs := got.publishRequests()
+	if len(reqs) != 1 || reqs[0].GetTargetPath() != "/var/lib/kubelet/pods/uid/volumes/kubernetes.io~csi/pv/mount" || !reqs[0].GetReadonly() {
+		t.Fatalf("reloaded publish requests = %v, want one readonly cleaned target", reqs)
+	}
+
+	if err := reloaded.deletePublish(reqs[0].GetTargetPath()); err != nil {
+		t.Fatalf("deletePublish() error = %v", err)
+	}
+	if err := reloaded.deleteStage(rec.VolumeID); err != nil {
+		t.Fatalf("deleteStage() error = %v", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("read state dir: %v", err)
+	}
+	for _, entry := range entries {
+		if entry.Name() != "corrupt.json" {
+			t.Errorf("state dir entry %q remains after deleteStage()", entry.Name())
+		}
+	}
+}
+
+func TestNodeStageAndUnstageMaintainState(t *testing.T) {
+	stateDir := t.TempDir()
+	stagingPath := filepath.Join(t.TempDir(), "stage")
+	targetPath := filepath.Join(t.TempDir(), "target")
+	mounter := &recordingMounter{mounted: map[string]bool{}}
+	n := NewNodeWithMounter("node-id", "/tmp/test-csi.sock", mounter, WithStateDir(stateDir))
+	ctx := context.Background()
+
+	capability := &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Mount{
+			Mount: &csi.VolumeCapability_MountVolume{FsType: "tmpfs"},
+		},
+	}
+	if _, err := n.NodeStageVolume(ctx, &csi.NodeStageVolumeRequest{
+		VolumeId:          "test-volume",
+		StagingTargetPath: stagingPath,
+		VolumeCapability:  capability,
+		VolumeContext: map[string]string{
+			"fileMode":     "0755",
+			"source":       "tmpfs",
+			"mountOptions": "nosuid,size=1m",
+		},
+	}); err != nil {
+		t.Fatalf("NodeStageVolume() error = %v", err)
+	}
+	if _, err := n.NodePublishVolume(ctx, &csi.NodePublishVolumeRequest{
+		VolumeId:          "test-volume",
+		StagingTargetPath: stagingPath,
+		TargetPath:        targetPath,
+		VolumeCapability:  capability,
+	}); err != nil {
+		t.Fatalf("NodePublishVolume() error = %v", err)
+	}
+
+	reloaded := newStateStore(stateDir)
+	if _, err := reloaded.load(); err != nil {
+		t.Fatalf("load() error = %v", err)
+	}
+	rec, ok := reloaded.get("test-volume")
+	if !ok {
+		t.Fatalf("state for test-volume missing after stage")
+	}
+	if rec.StagingTargetPath != stagingPath || rec.Source != "tmpfs" || rec.FsType != "tmpfs" || rec.MountData != "size=1m" {
+		t.Fatalf("state after stage = %+v", rec)
+	}
+	if _, ok := rec.Publishes[targetPath]; !ok {
+		t.Fatalf("state publishes = %v, want %s", rec.Publishes, targetPath)
+	}
+
+	if _, err := n.NodeUnpublishVolume(ctx, &csi.NodeUnpublishVolumeRequest{
+		VolumeId:   "test-volume",
+		TargetPath: targetPath,
+	}); err != nil {
+		t.Fatalf("NodeUnpublishVolume() error = %v", err)
+	}
+	if rec, _ := n.state.get("test-volume"); len(rec.Publishes) != 0 {
+		t.Fatalf("state publishes after unpublish = %v, want none", rec.Publishes)
+	}
+	if _, err := n.NodeUnstageVolume(ctx, &csi.NodeUnstageVolumeRequest{
+		VolumeId:          "test-volume",
+		StagingTargetPath: stagingPath,
+	}); err != nil {
+		t.Fatalf("NodeUnstageVolume() error = %v", err)
+	}
+	if _, ok := n.state.get("test-volume"); ok {
+		t.Fatalf("state for test-volume remains after unstage")
+	}
+	if entries, _ := os.ReadDir(stateDir); len(entries) != 0 {
+		t.Fatalf("state dir entries after unstage = %d, want 0", len(entries))
+	}
+}
@@ -2,6 +2,7 @@ package node
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"syscall"
 
@@ -37,7 +38,21 @@ func (n *Node) NodeGetVolumeStats(ctx context.Context, req *csi.NodeGetVolumeSta
 		return nil, status.Error(codes.InvalidArgument, "volume_path is required")
 	}
 
-	if err := probeMountPath(req.GetVolumePath()); err != nil {
+	if err := n.probeMountPathWithTimeout(req.GetVolumePath(), n.watchdog.ProbeTimeout); err != nil {
+		if errors.Is(err, errProbeTimeout) {
+			message := fmt.Sprintf("volume path is unresponsive: %v", err)
+			Logger(ctx).Warn("NodeGetVolumeStats reporting abnormal volume condition",
+				zap.String("volume_id", req.GetVolumeId()),
+				zap.String("volume_path", req.GetVolumePath()),
+				zap.String("message", message),
+			)
+			return &csi.NodeGetVolumeStatsResponse{
+				VolumeCondition: &csi.VolumeCondition{
+					Abnormal: true,
+					Message:  message,
+				},
+			}, nil
+		}
 		if !isDisconnectedMountError(err) {
 			Logger(ctx).Error("NodeGetVolumeStats failed to probe volume path",
 				zap.String("volume_id", req.GetVolumeId()),
@@ -62,6 +77,10 @@ func (n *Node) NodeGetVolumeStats(ctx context.Context, req *csi.NodeGetVolumeSta
 		}, nil
 	}
 
+	if rec, ok := n.state.get(req.GetVolumeId()); ok && rec.FsType == ramfsFsType && rec.SizeLimit > 0 {
+		return n.ramfsVolumeStats(ctx, req, rec)
+	}
+
 	var stat syscall.Statfs_t
 	if err := syscall.Statfs(req.GetVolumePath(), &stat); err != nil {
 		if !isDisconnectedMountError(err) {
@@ -0,0 +1,135 @@
+package node
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// defaultSubDirMode is applied to directories created for subDirCreate when
+// subDirMode is not set.
+const defaultSubDirMode os.FileMode = 0755
+
+var errSubDirSymlink = errors.New("subDir must not traverse symlinks")
+
+// subDirOptions describes the subDir publish attributes.
+type subDirOptions struct {
+	path   string
+	create bool
+	mode   os.FileMode
+	uid    int
+	gid    int
+}
+
+// parseSubDirOptions validates the subDir attributes in volumeContext. The
+// returned path is empty when the whole staging mount is published.
+func parseSubDirOptions(volumeContext map[string]string) (subDirOptions, error) {
+	opts := subDirOptions{mode: defaultSubDirMode, uid: -1, gid: -1}
+	subDir := volumeContext["subDir"]
+	if subDir == "" {
+		return opts, nil
+	}
+	if filepath.IsAbs(subDir) {
+		return opts, fmt.Errorf("subDir %q must be relative", subDir)
+	}
+	for _, part := range strings.Split(subDir, "/") {
+		if part == ".." {
+			return opts, fmt.Errorf("subDir %q must not contain '..'", subDir)
+		}
+	}
+	opts.path = filepath.Clean(subDir)
+	if opts.path == "." {
+		return opts, fmt.Errorf("subDir %q does not name a subdirectory", subDir)
+	}
+
+	if v, ok := volumeContext["subDirCreate"]; ok {
+		create, err := strconv.ParseBool(v)
+		if err != nil {
+			return opts, fmt.Errorf("invalid subDirCreate %q: %w", v, err)
+		}
+		opts.create = create
+	}
+	if v, ok := volumeContext["subDirMode"]; ok {
+		mode, err := strconv.ParseUint(v, 8, 32)
+		if err != nil || mode > 0o7777 {
+			return opts, fmt.Errorf("invalid subDirMode %q", v)
+		}
+		opts.mode = os.FileMode(mode)
+	}
+	for key, dst := range map[string]*int{"subDirUid": &opts.uid, "subDirGid": &opts.gid} {
+		v, ok := volumeContext[key]
+		if !ok {
+			continue
+		}
+		id, err := strconv.Atoi(v)
+		if err != nil || id < 0 {
+			return opts, fmt.Errorf("invalid %s %q", key, v)
+		}
+		*dst = id
+	}
+	return opts, nil
+}
+
+// openSubDir opens opts.path below root one component at a time without
+// following symlinks, so neither a symlink nor a concurrent rename can point
+// the bind mount outside root. Missing directories are created with opts.mode
+// and owner when opts.create is set. The caller binds from the returned
+// file's /proc/self/fd path and closes it afterwards.
+func openSubDir(root string, opts subDirOptions) (*os.File, error) {
+	fd, err := syscall.Open(root, syscall.O_RDONLY|syscall.O_DIRECTORY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: root, Err: err}
+	}
+	current := root
+	for _, name := range strings.Split(opts.path, "/") {
+		current = filepath.Join(current, name)
+		next, err := openSubDirComponent(fd, name, opts)
+		_ = syscall.Close(fd)
+		if err != nil {
+			if errors.Is(err, syscall.ELOOP) || errors.Is(err, syscall.ENOTDIR) {
+				return nil, fmt.Errorf("%w: %s", errSubDirSymlink, current)
+			}
+			return nil, &os.PathError{Op: "open", Path: current, Err: err}
+		}
+		fd = next
+	}
+	return os.NewFile(uintptr(fd), filepath.Join(root, opts.path)), nil
+}
+
+func openSubDirComponent(dirfd int, name string, opts subDirOptions) (int, error) {
+	const flags = syscall.O_RDONLY | syscall.O_DIRECTORY | syscall.O_NOFOLLOW | syscall.O_CLOEXEC
+	fd, err := syscall.Openat(dirfd, name, flags, 0)
+	if err != syscall.ENOENT || !opts.create {
+		return fd, err
+	}
+	if err := syscall.Mkdirat(dirfd, name, uint32(opts.mode.Perm())); err == syscall.EEXIST {
+		// Created concurrently; leave its mode and owner alone.
+		return syscall.Openat(dirfd, name, flags, 0)
+	} else if err != nil {
+		return -1, err
+	}
+	if fd, err = syscall.Openat(dirfd, name, flags, 0); err != nil {
+		return -1, err
+	}
+	// Mkdirat is subject to the umask; set the requested mode explicitly.
+	if err := syscall.Fchmod(fd, uint32(opts.mode)); err != nil {
+		_ = syscall.Close(fd)
+		return -1, err
+	}
+	if opts.uid >= 0 || opts.gid >= 0 {
+		if err := syscall.Fchown(fd, opts.uid, opts.gid); err != nil {
+			_ = syscall.Close(fd)
+			return -1, err
+		}
+	}
+	return fd, nil
+}
+
+// subDirBindSource returns the path to bind from for an open subDir.
+func subDirBindSource(f *os.File) string {
+	return fmt.Sprintf("/proc/self/fd/%d", f.Fd())
+}
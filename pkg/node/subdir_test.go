@@ -0,0 +1,114 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestParseSubDirOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		ctx     map[string]string
+		want    subDirOptions
+		wantErr bool
+	}{
+		{name: "unset", ctx: map[string]string{}, want: subDirOptions{mode: defaultSubDirMode, uid: -1, gid: -1}},
+		{
+			name: "nested with create",
+			ctx:  map[string]string{"subDir": "teams/media/", "subDirCreate": "true", "subDirMode": "2770", "subDirUid": "1000", "subDirGid": "2000"},
+			want: subDirOptions{path: "teams/media", create: true, mode: 0o2770, uid: 1000, gid: 2000},
+		},
+		{name: "absolute", ctx: map[string]string{"subDir": "/etc"}, wantErr: true},
+		{name: "parent", ctx: map[string]string{"subDir": "a/../../b"}, wantErr: true},
+		{name: "dot", ctx: map[string]string{"subDir": "./"}, wantErr: true},
+		{name: "bad create", ctx: map[string]string{"subDir": "a", "subDirCreate": "maybe"}, wantErr: true},
+		{name: "bad mode", ctx: map[string]string{"subDir": "a", "subDirMode": "999"}, wantErr: true},
+		{name: "bad uid", ctx: map[string]string{"subDir": "a", "subDirUid": "-5"}, wantErr: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := parseSubDirOptions(tc.ctx)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("parseSubDirOptions() error = nil, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseSubDirOptions() error = %v", err)
+			}
+			if got != tc.want {
+				t.Fatalf("parseSubDirOptions() = %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestOpenSubDir(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+	if err := os.Symlink(outside, filepath.Join(root, "escape")); err != nil {
+		t.Fatalf("create symlink: %v", err)
+	}
+
+	if _, err := openSubDir(root, subDirOptions{path: "escape/data", create: true, mode: 0755, uid: -1, gid: -1}); !errors.Is(err, errSubDirSymlink) {
+		t.Fatalf("openSubDir(symlink) error = %v, want %v", err, errSubDirSymlink)
+	}
+	if _, err := os.Stat(filepath.Join(outside, "data")); !os.IsNotExist(err) {
+		t.Fatalf("openSubDir() created a directory through the symlink")
+	}
+
+	if _, err := openSubDir(root, subDirOptions{path: "missing", mode: 0755, uid: -1, gid: -1}); !os.IsNotExist(err) {
+		t.Fatalf("openSubDir(missing) error = %v, want not exist", err)
+	}
+
+	f, err := openSubDir(root, subDirOptions{path: "teams/media", create: true, mode: 0750, uid: -1, gid: -1})
+	if err != nil {
+		t.Fatalf("openSubDir(create) error = %v", err)
+	}
+	defer func() { _ = f.Close() }()
+	info, err := os.Stat(filepath.Join(root, "teams", "media"))
+	if err != nil {
+		t.Fatalf("stat created subDir: %v", err)
+	}
+	if !info.IsDir() || info.Mode().Perm() != 0750 {
+		t.Fatalf("created subDir mode = %v, want directory with 0750", info.Mode())
+	}
+	if target, err := os.Readlink(subDirBindSource(f)); err != nil || target != filepath.Join(root, "teams", "media") {
+		t.Fatalf("subDirBindSource() -> %q, %v, want the created directory", target, err)
+	}
+}
+
+func TestNodePublishVolumeBindsSubDir(t *testing.T) {
+	stagingPath := t.TempDir()
+	targetPath := filepath.Join(t.TempDir(), "target")
+	mounter := &recordingMounter{mounted: map[string]bool{stagingPath: true}}
+	n := NewNodeWithMounter("node-id", "/tmp/test-csi.sock", mounter)
+	stubMountInfo(t, "")
+
+	req := publishRequest(stagingPath, targetPath, false)
+	req.VolumeContext = map[string]string{"subDir": "team-a", "subDirCreate": "true"}
+	if _, err := n.NodePublishVolume(context.Background(), req); err != nil {
+		t.Fatalf("NodePublishVolume() error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(stagingPath, "team-a")); err != nil {
+		t.Fatalf("subDir was not created: %v", err)
+	}
+	if len(mounter.sources) != 1 || !strings.HasPrefix(mounter.sources[0], "/proc/self/fd/") {
+		t.Fatalf("NodePublishVolume() bind sources = %v, want a /proc/self/fd path", mounter.sources)
+	}
+
+	req.TargetPath = filepath.Join(t.TempDir(), "other")
+	req.VolumeContext = map[string]string{"subDir": "team-b"}
+	_, err := n.NodePublishVolume(context.Background(), req)
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("NodePublishVolume() missing subDir code = %v, want %v", status.Code(err), codes.FailedPrecondition)
+	}
+}
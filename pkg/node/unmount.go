@@ -0,0 +1,154 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"syscall"
+	"time"
+
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// UnmountConfig controls how a busy or unresponsive mount is released. A
+// plain unmount is retried with backoff, then escalated to MNT_FORCE and
+// finally MNT_DETACH for the filesystem types that allow each step.
+type UnmountConfig struct {
+	// Retries of the plain unmount after it reports EBUSY.
+	Retries int
+	// Backoff before the first retry. It doubles for each later retry.
+	Backoff time.Duration
+	// ForceFsTypes lists the filesystem types, as shown in mountinfo, that
+	// escalate to MNT_FORCE. "fuse.*" matches every FUSE subtype and "*"
+	// matches all types.
+	ForceFsTypes []string
+	// DetachFsTypes lists the filesystem types that escalate to MNT_DETACH,
+	// using the same patterns as ForceFsTypes.
+	DetachFsTypes []string
+}
+
+// DefaultUnmountConfig returns the unmount ladder used when WithUnmount is not
+// given. Network and FUSE filesystems, whose servers can disappear, escalate
+// to forced and lazy unmounts. Local filesystems only retry, because a lazy
+// detach would leave the device busy with no mount to show for it.
+func DefaultUnmountConfig() UnmountConfig {
+	return UnmountConfig{
+		Retries:       3,
+		Backoff:       100 * time.Millisecond,
+		ForceFsTypes:  []string{"nfs", "nfs4", "cifs", "smb3", "ceph", "fuse", "fuse.*"},
+		DetachFsTypes: []string{"nfs", "nfs4", "cifs", "smb3", "ceph", "fuse", "fuse.*"},
+	}
+}
+
+// unmountStep is one rung of the unmount ladder.
+type unmountStep struct {
+	name  string
+	flags int
+}
+
+// unmountSteps returns the ladder for a mount of fsType.
+func (c UnmountConfig) unmountSteps(fsType string) []unmountStep {
+	steps := []unmountStep{{name: "unmount"}}
+	for i := 0; i < c.Retries; i++ {
+		steps = append(steps, unmountStep{name: "retry"})
+	}
+	if matchFsType(c.ForceFsTypes, fsType) {
+		steps = append(steps, unmountStep{name: "force", flags: syscall.MNT_FORCE})
+	}
+	if matchFsType(c.DetachFsTypes, fsType) {
+		steps = append(steps, unmountStep{name: "detach", flags: syscall.MNT_DETACH})
+	}
+	return steps
+}
+
+func matchFsType(patterns []string, fsType string) bool {
+	if fsType == "" {
+		return false
+	}
+	for _, pattern := range patterns {
+		if pattern == "*" || pattern == fsType {
+			return true
+		}
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok && strings.HasPrefix(fsType, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// unmountOnce removes the top mount at path, climbing the unmount ladder
+// while the kernel refuses. A path that is not mounted is not an error.
+func (n *Node) unmountOnce(ctx context.Context, path string, attempt int) error {
+	fsType := ""
+	if entry, ok, err := mountInfoEntryForPath(path); err == nil && ok {
+		fsType = entry.FSType
+	}
+	steps := n.unmount.unmountSteps(fsType)
+	backoff := n.unmount.Backoff
+
+	var err error
+	last := steps[0].name
+	for i, step := range steps {
+		if step.name == "retry" {
+			if !errors.Is(err, syscall.EBUSY) {
+				// Only a busy mount can become free by waiting.
+				continue
+			}
+			timer := time.NewTimer(backoff)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return fmt.Errorf("unmount %q: %w (last error: %v)", path, ctx.Err(), err)
+			case <-timer.C:
+			}
+			backoff *= 2
+		}
+
+		last = step.name
+		previous := err
+		err = n.mounter.Unmount(path, step.flags)
+		if err == nil || errors.Is(err, syscall.EINVAL) {
+			if step.flags != 0 {
+				Logger(ctx).Warn("unmounted path by escalation",
+					zap.String("path", path),
+					zap.String("fs_type", fsType),
+					zap.String("step", step.name),
+					zap.Int("attempt", attempt),
+				)
+				n.reportUnmountEvent(ctx, path, "JustmountUnmountEscalated",
+					fmt.Sprintf("unmounted %s on node %s with a %s unmount after: %v", path, n.nodeID, step.name, previous))
+			}
+			return nil
+		}
+		Logger(ctx).Error("failed to unmount path",
+			zap.String("path", path),
+			zap.String("fs_type", fsType),
+			zap.String("step", step.name),
+			zap.Int("step_index", i+1),
+			zap.Int("steps", len(steps)),
+			zap.Int("attempt", attempt),
+			zap.Error(err),
+		)
+		if errors.Is(err, syscall.EPERM) {
+			// Escalating does not help without privilege.
+			break
+		}
+	}
+	if last == "force" || last == "detach" {
+		n.reportUnmountEvent(ctx, path, "JustmountUnmountFailed",
+			fmt.Sprintf("could not unmount %s on node %s even with a %s unmount: %v", path, n.nodeID, last, err))
+	}
+	return fmt.Errorf("unmount %q failed at step %s: %w", path, last, err)
+}
+
+// reportUnmountEvent reports an escalated unmount on the claim of the volume
+// mounted at path. Paths no recorded volume uses are only logged.
+func (n *Node) reportUnmountEvent(ctx context.Context, path, reason, message string) {
+	volumeID, ok := n.state.volumeForPath(path)
+	if !ok {
+		return
+	}
+	n.reportVolumeEvent(ctx, volumeID, corev1.EventTypeWarning, reason, message)
+}
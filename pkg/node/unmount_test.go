@@ -0,0 +1,154 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// busyMounter fails unmounts whose flags are listed in errs and records the
+// flags of every unmount attempt.
+type busyMounter struct {
+	recordingMounter
+	errs       map[int]error
+	unmountFlg []int
+}
+
+func (m *busyMounter) Unmount(target string, flags int) error {
+	m.unmountFlg = append(m.unmountFlg, flags)
+	if err, ok := m.errs[flags]; ok {
+		return err
+	}
+	return m.recordingMounter.Unmount(target, flags)
+}
+
+func TestUnmountOnceEscalates(t *testing.T) {
+	path := t.TempDir()
+	busy := syscall.EBUSY
+
+	tests := []struct {
+		name      string
+		fsType    string
+		errs      map[int]error
+		wantFlags []int
+		wantErr   bool
+		wantEvent string
+	}{
+		{
+			name:      "plain unmount",
+			fsType:    "fuse.sshfs",
+			wantFlags: []int{0},
+		},
+		{
+			name:      "local filesystem only retries",
+			fsType:    "ext4",
+			errs:      map[int]error{0: busy},
+			wantFlags: []int{0, 0, 0},
+			wantErr:   true,
+		},
+		{
+			name:      "force after retries",
+			fsType:    "nfs4",
+			errs:      map[int]error{0: busy},
+			wantFlags: []int{0, 0, 0, syscall.MNT_FORCE},
+			wantEvent: "JustmountUnmountEscalated",
+		},
+		{
+			name:      "detach after force",
+			fsType:    "fuse.glusterfs",
+			errs:      map[int]error{0: busy, syscall.MNT_FORCE: busy},
+			wantFlags: []int{0, 0, 0, syscall.MNT_FORCE, syscall.MNT_DETACH},
+			wantEvent: "JustmountUnmountEscalated",
+		},
+		{
+			name:      "detach fails",
+			fsType:    "nfs4",
+			errs:      map[int]error{0: busy, syscall.MNT_FORCE: busy, syscall.MNT_DETACH: busy},
+			wantFlags: []int{0, 0, 0, syscall.MNT_FORCE, syscall.MNT_DETACH},
+			wantErr:   true,
+			wantEvent: "JustmountUnmountFailed",
+		},
+		{
+			name:      "dead server skips retries",
+			fsType:    "fuse.sshfs",
+			errs:      map[int]error{0: syscall.ENOTCONN},
+			wantFlags: []int{0, syscall.MNT_FORCE},
+			wantEvent: "JustmountUnmountEscalated",
+		},
+		{
+			name:      "permission denied does not escalate",
+			fsType:    "nfs",
+			errs:      map[int]error{0: syscall.EPERM},
+			wantFlags: []int{0},
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stubMountInfo(t, "1 0 0:42 / "+path+" rw - "+tt.fsType+" src rw\n")
+			mounter := &busyMounter{recordingMounter: recordingMounter{mounted: map[string]bool{path: true}}, errs: tt.errs}
+			cfg := DefaultUnmountConfig()
+			cfg.Retries = 2
+			cfg.Backoff = time.Millisecond
+			n := NewNodeWithMounter("node-id", "/tmp/test-csi.sock", mounter, WithUnmount(cfg))
+			reporter := &recordingPVCReporter{}
+			n.pvcReporter = reporter
+			if err := n.state.putStage(volumeRecord{VolumeID: "test-volume", StagingTargetPath: path}); err != nil {
+				t.Fatal(err)
+			}
+
+			err := n.unmountOnce(context.Background(), path, 1)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unmountOnce() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(mounter.unmountFlg) != len(tt.wantFlags) {
+				t.Fatalf("unmount flags = %v, want %v", mounter.unmountFlg, tt.wantFlags)
+			}
+			for i := range tt.wantFlags {
+				if mounter.unmountFlg[i] != tt.wantFlags[i] {
+					t.Fatalf("unmount flags = %v, want %v", mounter.unmountFlg, tt.wantFlags)
+				}
+			}
+			var wantEvents []string
+			if tt.wantEvent != "" {
+				wantEvents = []string{tt.wantEvent}
+			}
+			if len(reporter.events) != len(wantEvents) || (len(wantEvents) == 1 && reporter.events[0] != wantEvents[0]) {
+				t.Fatalf("events = %v, want %v", reporter.events, wantEvents)
+			}
+		})
+	}
+}
+
+func TestUnmountOnceStopsWhenContextEnds(t *testing.T) {
+	path := t.TempDir()
+	stubMountInfo(t, "1 0 0:42 / "+path+" rw - ext4 /dev/sda1 rw\n")
+	mounter := &busyMounter{recordingMounter: recordingMounter{mounted: map[string]bool{}}, errs: map[int]error{0: syscall.EBUSY}}
+	n := NewNodeWithMounter("node-id", "/tmp/test-csi.sock", mounter, WithUnmount(UnmountConfig{Retries: 5, Backoff: time.Hour}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := n.unmountOnce(ctx, path, 1); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("unmountOnce() error = %v, want context.DeadlineExceeded", err)
+	}
+}
+
+func TestMatchFsType(t *testing.T) {
+	patterns := []string{"nfs", "fuse.*"}
+	for fsType, want := range map[string]bool{
+		"nfs":        true,
+		"nfs4":       false,
+		"fuse.sshfs": true,
+		"fuse":       false,
+		"":           false,
+	} {
+		if got := matchFsType(patterns, fsType); got != want {
+			t.Errorf("matchFsType(%q) = %v, want %v", fsType, got, want)
+		}
+	}
+	if !matchFsType([]string{"*"}, "ext4") {
+		t.Errorf("matchFsType(*, ext4) = false, want true")
+	}
+}
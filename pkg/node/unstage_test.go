@@ -0,0 +1,77 @@
+package node
+
+import (
+	"context"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestNodeUnstageVolumeDependentBinds(t *testing.T) {
+	tests := []struct {
+		name         string
+		disconnected func(stagingPath, podTarget string) string
+		wantCode     codes.Code
+		wantUnmounts func(stagingPath, podTarget string) []string
+	}{
+		{
+			name:         "live bind refuses",
+			disconnected: func(string, string) string { return "" },
+			wantCode:     codes.FailedPrecondition,
+			wantUnmounts: func(string, string) []string { return nil },
+		},
+		{
+			name:         "disconnected bind is released",
+			disconnected: func(_, podTarget string) string { return podTarget },
+			wantCode:     codes.OK,
+			wantUnmounts: func(stagingPath, podTarget string) []string { return []string{podTarget, stagingPath} },
+		},
+		{
+			name:         "disconnected staging releases binds",
+			disconnected: func(stagingPath, _ string) string { return stagingPath },
+			wantCode:     codes.OK,
+			wantUnmounts: func(stagingPath, podTarget string) []string { return []string{podTarget, stagingPath} },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stagingPath := filepath.Join(t.TempDir(), "staging")
+			podTarget := filepath.Join(t.TempDir(), "pod-target")
+			mounter := &recordingMounter{mounted: map[string]bool{stagingPath: true, podTarget: true}}
+			n := NewNodeWithMounter("node-id", "/tmp/test-csi.sock", mounter)
+
+			stubMountInfo(t, "1 0 0:42 / "+stagingPath+" rw - fuse.sshfs host:/ rw\n"+
+				"2 0 0:42 / "+podTarget+" rw - fuse.sshfs host:/ rw\n")
+			dead := tt.disconnected(stagingPath, podTarget)
+			origProbeMountPath := probeMountPath
+			probeMountPath = func(path string) error {
+				if path == dead {
+					return syscall.ENOTCONN
+				}
+				return nil
+			}
+			t.Cleanup(func() { probeMountPath = origProbeMountPath })
+
+			_, err := n.NodeUnstageVolume(context.Background(), &csi.NodeUnstageVolumeRequest{
+				VolumeId:          "test-volume",
+				StagingTargetPath: stagingPath,
+			})
+			if status.Code(err) != tt.wantCode {
+				t.Fatalf("NodeUnstageVolume() error = %v, want code %v", err, tt.wantCode)
+			}
+			want := tt.wantUnmounts(stagingPath, podTarget)
+			if len(mounter.unmounts) != len(want) {
+				t.Fatalf("NodeUnstageVolume() unmounts = %v, want %v", mounter.unmounts, want)
+			}
+			for i := range want {
+				if mounter.unmounts[i] != want[i] {
+					t.Fatalf("NodeUnstageVolume() unmounts = %v, want %v", mounter.unmounts, want)
+				}
+			}
+		})
+	}
+}
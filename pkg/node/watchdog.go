@@ -0,0 +1,182 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// WatchdogConfig controls the background mount health watchdog.
+type WatchdogConfig struct {
+	// Interval between probe rounds. Zero disables the watchdog.
+	Interval time.Duration
+	// ProbeTimeout bounds a single staging path probe. Zero means no timeout.
+	ProbeTimeout time.Duration
+	// Concurrency limits how many staging paths are probed at once.
+	Concurrency int
+}
+
+var errProbeTimeout = errors.New("mount probe timed out")
+
+// probeSet tracks paths whose probe is still blocked in the kernel. A path is
+// not probed again until its last probe returns, so a hung mount holds on to
+// one goroutine rather than one per watchdog round.
+type probeSet struct {
+	mu    sync.Mutex
+	paths map[string]bool
+}
+
+// start marks path as being probed. It returns false when a probe of path is
+// already outstanding.
+func (s *probeSet) start(path string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.paths[path] {
+		return false
+	}
+	if s.paths == nil {
+		s.paths = map[string]bool{}
+	}
+	s.paths[path] = true
+	return true
+}
+
+func (s *probeSet) finish(path string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.paths, path)
+}
+
+func (s *probeSet) running(path string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.paths[path]
+}
+
+func (n *Node) runWatchdog(ctx context.Context) {
+	BaseLogger().Info("starting mount health watchdog",
+		zap.Duration("interval", n.watchdog.Interval),
+		zap.Duration("probe_timeout", n.watchdog.ProbeTimeout),
+		zap.Int("concurrency", n.watchdog.Concurrency),
+	)
+	ticker := time.NewTicker(n.watchdog.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			BaseLogger().Info("stopping mount health watchdog")
+			return
+		case <-ticker.C:
+			n.checkStagedVolumes(ctx)
+		}
+	}
+}
+
+func (n *Node) checkStagedVolumes(ctx context.Context) {
+	concurrency := n.watchdog.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for _, rec := range n.state.list() {
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return
+		case sem <- struct{}{}:
+		}
+		wg.Add(1)
+		go func(rec volumeRecord) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			n.checkStagedVolume(ctx, rec)
+		}(rec)
+	}
+	wg.Wait()
+}
+
+func (n *Node) checkStagedVolume(ctx context.Context, rec volumeRecord) {
+	l := BaseLogger().With(
+		zap.String("component", "watchdog"),
+		zap.String("volume_id", rec.VolumeID),
+		zap.String("staging_target_path", rec.StagingTargetPath),
+	)
+	ctx = withLogger(ctx, l)
+
+	release, holder, ok := n.locks.tryAcquire("watchdog", volumeLockKey(rec.VolumeID), pathLockKey(rec.StagingTargetPath))
+	if !ok {
+		l.Debug("watchdog skipping volume with operation in progress", zap.String("in_progress", holder))
+		return
+	}
+	defer release()
+
+	isMounted, err := n.mounter.IsMountPoint(rec.StagingTargetPath)
+	if err != nil {
+		l.Warn("watchdog failed to verify staging mountpoint", zap.Error(err))
+		return
+	}
+	if !isMounted {
+		l.Debug("watchdog skipping staging path that is not mounted")
+		return
+	}
+
+	if n.probes.running(rec.StagingTargetPath) {
+		l.Debug("watchdog skipping staging path whose last probe has not returned")
+		return
+	}
+	err = n.probeMountPathWithTimeout(rec.StagingTargetPath, n.watchdog.ProbeTimeout)
+	if err == nil {
+		return
+	}
+	if errors.Is(err, errProbeTimeout) {
+		l.Warn("watchdog probe timed out", zap.Error(err))
+		n.reportVolumeEvent(ctx, rec.VolumeID, corev1.EventTypeWarning, "JustmountMountUnresponsive",
+			fmt.Sprintf("staging mount %s on node %s is unresponsive: %v", rec.StagingTargetPath, n.nodeID, err))
+		return
+	}
+	if !isDisconnectedMountError(err) {
+		l.Warn("watchdog probe failed", zap.Error(err))
+		return
+	}
+
+	l.Warn("watchdog repairing disconnected staging mount", zap.Error(err))
+	remounted, err := n.repairDisconnectedStaging(ctx, rec.StagingTargetPath, rec.VolumeContext, rec.publishRequests())
+	if err != nil {
+		l.Error("watchdog failed to repair disconnected staging mount", zap.Error(err))
+		return
+	}
+	l.Info("watchdog repaired disconnected staging mount", zap.Bool("remounted", remounted))
+}
+
+// probeMountPathWithTimeout runs probeMountPath but gives up after timeout. A
+// probe stuck in the kernel cannot be interrupted, so its goroutine is left to
+// finish on its own, and until it does the path reports a timeout straight
+// away instead of being probed again.
+func (n *Node) probeMountPathWithTimeout(path string, timeout time.Duration) error {
+	if timeout <= 0 {
+		return probeMountPath(path)
+	}
+	if !n.probes.start(path) {
+		return fmt.Errorf("%w: an earlier probe of %s has not returned", errProbeTimeout, path)
+	}
+	probe := probeMountPath
+	result := make(chan error, 1)
+	go func() {
+		defer n.probes.finish(path)
+		result <- probe(path)
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case err := <-result:
+		return err
+	case <-timer.C:
+		return fmt.Errorf("%w after %s", errProbeTimeout, timeout)
+	}
+}
@@ -0,0 +1,189 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestWatchdogUnstagesDisconnectedStagingAndReportsPublishes(t *testing.T) {
+	stagingPath := t.TempDir()
+	podTarget := filepath.Join(t.TempDir(), "pod-target")
+
+	mounter := &recordingMounter{
+		mounted: map[string]bool{
+			stagingPath: true,
+			podTarget:   true,
+		},
+	}
+	n := NewNodeWithMounter("node-id", "/tmp/test-csi.sock", mounter, WithWatchdog(WatchdogConfig{
+		Interval:     time.Minute,
+		ProbeTimeout: time.Second,
+		Concurrency:  2,
+	}))
+	reporter := &recordingPVCReporter{}
+	n.pvcReporter = reporter
+
+	if err := n.state.putStage(volumeRecord{VolumeID: "test-volume", StagingTargetPath: stagingPath}); err != nil {
+		t.Fatalf("putStage() error = %v", err)
+	}
+	if err := n.state.putPublish("test-volume", stagingPath, publishRecord{TargetPath: podTarget}); err != nil {
+		t.Fatalf("putPublish() error = %v", err)
+	}
+
+	origProbeMountPath := probeMountPath
+	probeMountPath = func(path string) error {
+		// The replacement mount answers once it is in place.
+		if path == stagingPath && len(mounter.mounts) == 0 {
+			return syscall.ENOTCONN
+		}
+		return nil
+	}
+	t.Cleanup(func() { probeMountPath = origProbeMountPath })
+
+	origReadMountInfo := readMountInfo
+	readMountInfo = func() ([]byte, error) {
+		return []byte(
+			"1 0 0:42 / " + stagingPath + " rw - fuse.glusterfs gluster:media rw\n" +
+				"2 0 0:42 / " + podTarget + " rw - fuse.glusterfs gluster:media rw\n",
+		), nil
+	}
+	t.Cleanup(func() { readMountInfo = origReadMountInfo })
+
+	n.checkStagedVolumes(context.Background())
+
+	wantUnmounts := []string{podTarget, stagingPath}
+	if len(mounter.unmounts) != len(wantUnmounts) {
+		t.Fatalf("checkStagedVolumes() unmounts = %v, want %v", mounter.unmounts, wantUnmounts)
+	}
+	for i := range wantUnmounts {
+		if mounter.unmounts[i] != wantUnmounts[i] {
+			t.Errorf("checkStagedVolumes() unmounts[%d] = %q, want %q", i, mounter.unmounts[i], wantUnmounts[i])
+		}
+	}
+	if len(reporter.started) != 1 || reporter.started[0] != "JustmountStagingMountDisconnected" {
+		t.Fatalf("checkStagedVolumes() repair start reports = %v, want [JustmountStagingMountDisconnected]", reporter.started)
+	}
+	if len(reporter.completed) != 1 || reporter.completed[0] != "JustmountStagingMountUnstaged" {
+		t.Fatalf("checkStagedVolumes() repair completion reports = %v, want [JustmountStagingMountUnstaged]", reporter.completed)
+	}
+}
+
+func TestWatchdogLeavesHealthyAndUnmountedStagingAlone(t *testing.T) {
+	healthyPath := t.TempDir()
+	unmountedPath := t.TempDir()
+
+	mounter := &recordingMounter{
+		mounted: map[string]bool{
+			healthyPath: true,
+		},
+	}
+	n := NewNodeWithMounter("node-id", "/tmp/test-csi.sock", mounter)
+	for id, path := range map[string]string{"healthy": healthyPath, "unmounted": unmountedPath} {
+		if err := n.state.putStage(volumeRecord{VolumeID: id, StagingTargetPath: path}); err != nil {
+			t.Fatalf("putStage(%q) error = %v", id, err)
+		}
+	}
+
+	origProbeMountPath := probeMountPath
+	probeMountPath = func(path string) error {
+		if path == unmountedPath {
+			return syscall.ENOTCONN
+		}
+		return nil
+	}
+	t.Cleanup(func() { probeMountPath = origProbeMountPath })
+
+	n.checkStagedVolumes(context.Background())
+
+	if len(mounter.unmounts) != 0 {
+		t.Fatalf("checkStagedVolumes() unmounts = %v, want none", mounter.unmounts)
+	}
+}
+
+// stubHungProbe makes probeMountPath block until the returned release func is
+// called. Cleanup releases the probe and waits for it to return before
+// restoring probeMountPath.
+func stubHungProbe(t *testing.T) (calls *atomic.Int32, release func()) {
+	t.Helper()
+	calls = &atomic.Int32{}
+	unblock := make(chan struct{})
+	var once sync.Once
+	release = func() { once.Do(func() { close(unblock) }) }
+	var running sync.WaitGroup
+	origProbeMountPath := probeMountPath
+	probeMountPath = func(path string) error {
+		running.Add(1)
+		defer running.Done()
+		calls.Add(1)
+		<-unblock
+		return nil
+	}
+	t.Cleanup(func() {
+		release()
+		running.Wait()
+		probeMountPath = origProbeMountPath
+	})
+	return calls, release
+}
+
+func TestProbeMountPathWithTimeout(t *testing.T) {
+	calls, release := stubHungProbe(t)
+	n := NewNodeWithMounter("node-id", "/tmp/test-csi.sock", &recordingMounter{mounted: map[string]bool{}})
+
+	for i := 0; i < 3; i++ {
+		err := n.probeMountPathWithTimeout("/hung", 10*time.Millisecond)
+		if !errors.Is(err, errProbeTimeout) {
+			t.Fatalf("probeMountPathWithTimeout() error = %v, want %v", err, errProbeTimeout)
+		}
+		if isDisconnectedMountError(err) {
+			t.Fatalf("isDisconnectedMountError(%v) = true, want false", err)
+		}
+	}
+	if got := calls.Load(); got != 1 {
+		t.Fatalf("probeMountPath calls = %d while the first probe hangs, want 1", got)
+	}
+
+	release()
+	deadline := time.Now().Add(time.Second)
+	for n.probes.running("/hung") {
+		if time.Now().After(deadline) {
+			t.Fatalf("probe of /hung still outstanding after it returned")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if err := n.probeMountPathWithTimeout("/hung", time.Second); err != nil {
+		t.Fatalf("probeMountPathWithTimeout() after the probe returned error = %v", err)
+	}
+}
+
+func TestCheckStagedVolumeReportsUnresponsiveMount(t *testing.T) {
+	stagingPath := t.TempDir()
+	calls, _ := stubHungProbe(t)
+	mounter := &recordingMounter{mounted: map[string]bool{stagingPath: true}}
+	n := NewNodeWithMounter("node-id", "/tmp/test-csi.sock", mounter,
+		WithWatchdog(WatchdogConfig{Interval: time.Minute, ProbeTimeout: 10 * time.Millisecond}))
+	reporter := &recordingPVCReporter{}
+	n.pvcReporter = reporter
+	if err := n.state.putStage(volumeRecord{VolumeID: "test-volume", StagingTargetPath: stagingPath}); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		n.checkStagedVolumes(context.Background())
+	}
+	if got := calls.Load(); got != 1 {
+		t.Fatalf("probeMountPath calls = %d over three rounds, want 1", got)
+	}
+	if len(reporter.events) != 1 || reporter.events[0] != "JustmountMountUnresponsive" {
+		t.Fatalf("events = %v, want one JustmountMountUnresponsive", reporter.events)
+	}
+	if len(mounter.unmounts) != 0 {
+		t.Fatalf("checkStagedVolumes() unmounts = %v, want none", mounter.unmounts)
+	}
+}
@@ -1,10 +1,10 @@
 package util
 
 import (
-	"os"
 	"path/filepath"
-	"strings"
 	"syscall"
+
+	"github.com/joejulian/csi-justmount/pkg/mountinfo"
 )
 
 // Helper function to check if a path is a mount point
@@ -30,40 +30,17 @@ func IsMountPoint(path string) (bool, error) {
 	return stat.Dev != parentStat.Dev, nil
 }
 
-var mountInfoPath = "/proc/self/mountinfo"
+var mountInfoPath = mountinfo.SelfPath
 
 func isMountPointFromMountInfo(path string) (bool, error) {
-	data, err := os.ReadFile(mountInfoPath)
+	entries, warnings, err := mountinfo.ReadFile(mountInfoPath)
 	if err != nil {
 		return false, err
 	}
-	for _, line := range strings.Split(string(data), "\n") {
-		if strings.TrimSpace(line) == "" {
-			continue
-		}
-		parts := strings.SplitN(line, " - ", 2)
-		if len(parts) < 1 {
-			continue
-		}
-		fields := strings.Fields(parts[0])
-		if len(fields) < 5 {
-			continue
-		}
-		mountPoint := unescapeMountPath(fields[4])
-		if filepath.Clean(mountPoint) == path {
-			return true, nil
-		}
+	// A skipped line may be the mount in question, so let IsMountPoint fall
+	// back to comparing devices.
+	if len(warnings) > 0 {
+		return false, warnings[0]
 	}
-	return false, nil
-}
-
-func unescapeMountPath(path string) string {
-	// mountinfo uses octal escapes for special chars.
-	replacer := strings.NewReplacer(
-		"\\040", " ",
-		"\\011", "\t",
-		"\\012", "\n",
-		"\\134", "\\",
-	)
-	return replacer.Replace(path)
+	return mountinfo.IsMountPoint(entries, path), nil
 }
@@ -61,3 +61,19 @@ func TestIsMountPointUsesMountInfo(t *testing.T) {
 		t.Fatalf("IsMountPoint should return true when mountinfo contains the path")
 	}
 }
+
+func TestIsMountPointFromMountInfoRefusesMalformedLine(t *testing.T) {
+	mountInfo := filepath.Join(t.TempDir(), "mountinfo")
+	data := []byte("garbage\n36 25 0:32 / /mnt/test rw,relatime - fuse.sshfs sshfs#host:/ rw\n")
+	if err := os.WriteFile(mountInfo, data, 0644); err != nil {
+		t.Fatalf("write mountinfo: %v", err)
+	}
+
+	orig := mountInfoPath
+	mountInfoPath = mountInfo
+	t.Cleanup(func() { mountInfoPath = orig })
+
+	if _, err := isMountPointFromMountInfo("/mnt/other"); err == nil {
+		t.Fatal("isMountPointFromMountInfo() with a malformed line error = nil, want an error so IsMountPoint falls back")
+	}
+}